@@ -0,0 +1,104 @@
+package main
+
+import (
+	"bytes"
+	"golang.org/x/net/html"
+	"net/url"
+	"strings"
+)
+
+var linkAttributes = map[string]bool{"href": true, "src": true, "poster": true}
+
+func resolveLink(base *url.URL, link string) string {
+	trimmed := strings.TrimSpace(link)
+	if len(trimmed) < 1 || strings.HasPrefix(trimmed, "#") {
+		return link //Same-page anchors work wherever the content is
+	}
+	u, err := url.Parse(trimmed)
+	if err != nil {
+		return link
+	}
+	return base.ResolveReference(u).String()
+}
+
+func mapSrcset(srcset string, f func(string) string) string {
+	candidates := strings.Split(srcset, ",")
+	for i, candidate := range candidates {
+		fields := strings.Fields(candidate)
+		if len(fields) > 0 {
+			fields[0] = f(fields[0])
+		}
+		candidates[i] = strings.Join(fields, " ")
+	}
+	return strings.Join(candidates, ", ")
+}
+
+func mapLinks(content []byte, f func(string) string) []byte {
+	var b bytes.Buffer
+	z := html.NewTokenizer(bytes.NewReader(content))
+	for {
+		tt := z.Next()
+		if tt == html.ErrorToken {
+			return b.Bytes()
+		}
+		if tt != html.StartTagToken && tt != html.SelfClosingTagToken {
+			b.Write(z.Raw())
+			continue
+		}
+		raw := append([]byte(nil), z.Raw()...) //Token() unescapes in place
+		tok := z.Token()
+		changed := false
+		for i, attr := range tok.Attr {
+			newVal := attr.Val
+			if linkAttributes[attr.Key] {
+				newVal = f(attr.Val)
+			} else if attr.Key == "srcset" {
+				newVal = mapSrcset(attr.Val, f)
+			}
+			if newVal != attr.Val {
+				tok.Attr[i].Val = newVal
+				changed = true
+			}
+		}
+		if changed {
+			writeStartTag(&b, tok, tok.Attr)
+		} else {
+			b.Write(raw)
+		}
+	}
+}
+
+func pageBase(pageURL string) (*url.URL, error) {
+	return url.Parse(strings.TrimSuffix(pageURL, "/") + "/") //Each article is the index.html of its directory
+}
+
+func absoluteURLs(content, pageURL string) (string, error) {
+	base, err := pageBase(pageURL)
+	if err != nil {
+		return "", err
+	}
+	return string(mapLinks([]byte(content), func(link string) string {
+		return resolveLink(base, link)
+	})), nil
+}
+
+func absoluteItem(ji jsfItem) (jsfItem, error) {
+	content, err := absoluteURLs(ji.ContentHTML, ji.URL)
+	if err != nil {
+		return ji, err
+	}
+	ji.ContentHTML = content
+	return ji, nil
+}
+
+func absoluteItemList(itemList []jsfItem) ([]jsfItem, error) {
+	res := make([]jsfItem, len(itemList))
+	for i, ji := range itemList {
+		var err error
+		res[i], err = absoluteItem(ji)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return res, nil
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"testing"
+)
+
+var absoluteURLsTests = []struct {
+	input  string
+	output string
+}{
+	{"<img src=\"attachments/1200.jpg\">", "<img src=\"http://ratan.blog/2020/trip/attachments/1200.jpg\">"},
+	{"<a href=\"../other\">Other</a>", "<a href=\"http://ratan.blog/2020/other\">Other</a>"},
+	{"<a href=\"/about\">About</a>", "<a href=\"http://ratan.blog/about\">About</a>"},
+	{"<a href=\"https://example.com/x\">x</a>", "<a href=\"https://example.com/x\">x</a>"},
+	{"<a href=\"#fn1\">1</a>", "<a href=\"#fn1\">1</a>"},
+	{"<a href=\"mailto:me@ratan.blog\">me</a>", "<a href=\"mailto:me@ratan.blog\">me</a>"},
+	{"<img srcset=\"a.jpg 1x, b.jpg 2x\" alt=\"A &amp; B\"/>", "<img srcset=\"http://ratan.blog/2020/trip/a.jpg 1x, http://ratan.blog/2020/trip/b.jpg 2x\" alt=\"A &amp; B\" />"},
+	{"<p class=\"x\">Text &amp; <em>more</em></p>", "<p class=\"x\">Text &amp; <em>more</em></p>"},
+}
+
+func TestAbsoluteURLs(t *testing.T) {
+	for _, pageURL := range []string{"http://ratan.blog/2020/trip", "http://ratan.blog/2020/trip/"} {
+		for _, test := range absoluteURLsTests {
+			res, err := absoluteURLs(test.input, pageURL)
+			if err != nil {
+				t.Errorf("Error (%s) when all parameters valid.", err.Error())
+			}
+			if res != test.output {
+				t.Errorf("Wrong output for '%s' on '%s', expected '%s', actual '%s'", test.input, pageURL, test.output, res)
+			}
+		}
+	}
+}
+
+func TestAbsoluteItemList(t *testing.T) {
+	itemList := []jsfItem{{URL: "http://ratan.blog/hello", ContentHTML: "<img src=\"attachments/a.png\">"}}
+	res, err := absoluteItemList(itemList)
+	if err != nil {
+		t.Errorf("Error (%s) when all parameters valid.", err.Error())
+	}
+	expected := "<img src=\"http://ratan.blog/hello/attachments/a.png\">"
+	if res[0].ContentHTML != expected {
+		t.Errorf("Wrong content, expected '%s', actual '%s'", expected, res[0].ContentHTML)
+	}
+	if itemList[0].ContentHTML != "<img src=\"attachments/a.png\">" {
+		t.Errorf("Original item changed: '%s'", itemList[0].ContentHTML)
+	}
+}
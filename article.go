@@ -5,22 +5,48 @@ import (
 	"errors"
 	"fmt"
 	"github.com/ratanvarghese/tqtime"
-	"github.com/russross/blackfriday"
 	"html/template"
 	"io"
 	"io/ioutil"
 	"net/http"
 	"net/url"
 	"os"
+	"path"
 	"path/filepath"
+	"reflect"
+	"sort"
 	"strings"
 	"time"
 )
 
 type jsfAttachment struct {
-	URL      string `json:"url"`
-	MIMEType string `json:"mime_type"`
-	valid    bool
+	URL               string          `json:"url"`
+	MIMEType          string          `json:"mime_type"`
+	Title             string          `json:"title,omitempty"`
+	SizeInBytes       int64           `json:"size_in_bytes,omitempty"`
+	DurationInSeconds float64         `json:"duration_in_seconds,omitempty"`
+	Blom              *blomAttachment `json:"_blom,omitempty"`
+	path              string          //Relative to the attachments directory
+	valid             bool
+}
+
+type jsfAuthor struct {
+	Name   string `json:"name,omitempty"`
+	URL    string `json:"url,omitempty"`
+	Avatar string `json:"avatar,omitempty"`
+}
+
+type blomMeta struct {
+	Slug        string           `json:"slug,omitempty"`
+	Aliases     []string         `json:"aliases,omitempty"` //Former URLs
+	Summary     string           `json:"summary,omitempty"` //Set by the author, unlike jsfItem.Summary
+	Season      int              `json:"season,omitempty"`
+	Episode     int              `json:"episode,omitempty"`
+	Explicit    *bool            `json:"explicit,omitempty"` //Unset means the podcast's setting
+	TOC         bool             `json:"toc,omitempty"`
+	Attachments *attachmentRules `json:"attachments,omitempty"`
+	Gallery     string           `json:"gallery,omitempty"`   //Image order, no gallery if unset
+	IDFrozen    bool             `json:"id_frozen,omitempty"` //Only needed when the ID is the URL
 }
 
 type jsfItem struct {
@@ -28,17 +54,49 @@ type jsfItem struct {
 	URL           string          `json:"url"`
 	Title         string          `json:"title"`
 	ContentHTML   string          `json:"content_html"`
+	Summary       string          `json:"summary,omitempty"`
+	Image         string          `json:"image,omitempty"`
+	BannerImage   string          `json:"banner_image,omitempty"`
+	ExternalURL   string          `json:"external_url,omitempty"`
 	DatePublished string          `json:"date_published"`
 	DateModified  string          `json:"date_modified"`
+	Authors       []jsfAuthor     `json:"authors,omitempty"`
+	Author        *jsfAuthor      `json:"author,omitempty"` //JSON Feed 1.0, only read from old item files
+	Language      string          `json:"language,omitempty"`
 	Tags          []string        `json:"tags"`
 	Attachments   []jsfAttachment `json:"attachments"`
+	Blom          *blomMeta       `json:"_blom,omitempty"`
+	Gallery       []galleryImage  `json:"_gallery,omitempty"`
+	dir           string          //Source directory, relative to the blog root
+	stripped      []string        //Lines for the strip_metadata report
+}
+
+type articleFlags struct {
+	title     string
+	tagList   string
+	slug      string
+	aliasList string
+	summary   string
+	season    int
+	episode   int
+	explicit  *bool //Unset keeps the previous setting
+	toc       *bool //Unset keeps the previous setting
+	gallery   string
+	language  *string //Unset keeps the previous setting
+	authors   *string //Unset keeps the previous setting
 }
 
 type articleExport struct {
-	Title       string
-	Date        template.HTML
-	Today       template.HTML
-	ContentHTML template.HTML
+	Title        string
+	Date         template.HTML
+	Today        template.HTML
+	ContentHTML  template.HTML
+	Summary      string
+	TOC          []tocEntry
+	TOCHTML      template.HTML
+	Gallery      []galleryImage
+	GalleryHTML  template.HTML
+	GalleryImage *galleryImage //Only on the page of one gallery image
 }
 
 const articleMode = "article"
@@ -50,9 +108,10 @@ const contentFileHTML = "content.html"
 const itemFile = "item.json"
 const finalWebpageFile = "index.html"
 
-func (ja *jsfAttachment) init(basename string, article string, fileStart []byte) error {
-	ja.MIMEType = http.DetectContentType(fileStart)
-	URLRelativeToHost, err := url.Parse(article + "/" + attachmentDir + "/" + basename)
+func (ja *jsfAttachment) init(relPath string, article string, fileStart []byte) error {
+	ja.MIMEType = attachmentMIMEType(relPath, http.DetectContentType(fileStart))
+	ja.path = filepath.ToSlash(relPath)
+	URLRelativeToHost, err := url.Parse(article + "/" + attachmentDir + "/" + ja.path)
 	if err != nil {
 		return err
 	}
@@ -68,6 +127,18 @@ func (ja *jsfAttachment) init(basename string, article string, fileStart []byte)
 	return nil
 }
 
+func itemURL(urlPath string) (string, error) {
+	base, err := url.Parse(hostRawURL)
+	if err != nil {
+		return "", err
+	}
+	u, err := url.Parse(filepath.ToSlash(urlPath))
+	if err != nil {
+		return "", err
+	}
+	return base.ResolveReference(u).String(), nil
+}
+
 func (ji *jsfItem) init(published, modified time.Time, title, directory, tagList string) error {
 	if len(title) < 1 {
 		return errors.New("Blank title")
@@ -77,17 +148,11 @@ func (ji *jsfItem) init(published, modified time.Time, title, directory, tagList
 		return errors.New("Blank directory")
 	}
 
-	base, err := url.Parse(hostRawURL)
-	if err != nil {
-		return err
-	}
-
-	u, err := url.Parse(filepath.Base(directory))
+	var err error
+	ji.URL, err = itemURL(directory)
 	if err != nil {
 		return err
 	}
-
-	ji.URL = base.ResolveReference(u).String()
 	ji.ID = ji.URL
 	ji.Title = title
 	ji.DatePublished = published.Format(time.RFC3339)
@@ -105,29 +170,56 @@ func (ji *jsfItem) init(published, modified time.Time, title, directory, tagList
 func (articleE *articleExport) init(published time.Time, title string, content []byte) {
 	articleE.Title = title
 	articleE.Date = template.HTML(dualDateStr(published))
-	articleE.Today = "Today is " + template.HTML(dualDateStr(time.Now()))
+	articleE.Today = todaySnippet(buildTime())
 	articleE.ContentHTML = template.HTML(content)
 }
 
+func tqDateStr(gDate time.Time) string {
+	tqDate := tqtime.LongDate(gDate.Year(), gDate.YearDay())
+	return strings.Replace(tqDate, "After Tranquility", "AT", 1)
+}
+
 func dualDateStr(gDate time.Time) string {
+	return dualDateSeperated(gDate, "<br />")
+}
+
+func dualDateSeperated(gDate time.Time, seperator string) string {
 	const outputGDateFormat = "Monday, 2 January, 2006 CE"
-	tqDate := tqtime.LongDate(gDate.Year(), gDate.YearDay())
-	tqDateBetter := strings.Replace(tqDate, "After Tranquility", "AT", 1)
 	gDateStr := gDate.Format(outputGDateFormat)
-	return fmt.Sprintf("%s<br />[Gregorian: %s]", tqDateBetter, gDateStr)
+	return fmt.Sprintf("%s%s[Gregorian: %s]", tqDateStr(gDate), seperator, gDateStr)
 }
 
-func getAttachPaths(articlePath string) (map[string]bool, error) {
+func getAttachPaths(articlePath string, rules attachmentRules) (map[string]bool, error) {
 	attachPath := filepath.Join(articlePath, attachmentDir)
-	attachList, err := ioutil.ReadDir(attachPath)
+	res := make(map[string]bool) //Values say whether the attachment is published
+	err := filepath.Walk(attachPath, func(curPath string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if curPath == attachPath {
+			return nil
+		}
+		relPath, err := filepath.Rel(attachPath, curPath)
+		if err != nil {
+			return err
+		}
+		if matchesPattern(rules.Exclude, relPath, info.IsDir()) {
+			if info.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if !info.IsDir() && rules.included(relPath) {
+			res[curPath] = rules.published(relPath)
+		}
+		return nil
+	})
 	if err != nil {
 		return nil, err
 	}
-
-	res := make(map[string]bool)
-	for _, attachFileInfo := range attachList {
-		if !attachFileInfo.IsDir() {
-			res[filepath.Join(attachPath, attachFileInfo.Name())] = true
+	for attachPath := range res {
+		if isSidecar(attachPath, res) {
+			delete(res, attachPath) //Describes another attachment
 		}
 	}
 
@@ -143,11 +235,11 @@ func attachmentsFromReaders(article string, filepaths []string, readers []io.Rea
 	attachList := make([]jsfAttachment, len(filepaths))
 	for i, curpath := range filepaths {
 		b := make([]byte, bytesNeededToFindMIMEType)
-		_, err := readers[i].Read(b)
-		if err != nil {
+		n, err := io.ReadFull(readers[i], b)
+		if err != nil && err != io.ErrUnexpectedEOF && err != io.EOF { //Small and empty files are fine
 			return attachList, err
 		}
-		err = attachList[i].init(filepath.Base(curpath), article, b)
+		err = attachList[i].init(curpath, article, b[:n])
 		if err != nil {
 			return attachList, err
 		}
@@ -166,14 +258,17 @@ func getArticleContent(articlePath string) ([]byte, time.Time, error) {
 		if err != nil {
 			return nil, modified, err
 		}
-		articleContent = blackfriday.MarkdownCommon(mdContent)
-		modified = MDFileInfo.ModTime()
+		articleContent, err = renderMarkdown(mdContent)
+		if err != nil {
+			return nil, modified, err
+		}
+		modified = clampTime(MDFileInfo.ModTime())
 	} else if HTMLFileInfo, err := os.Stat(HTMLContentPath); err == nil {
 		articleContent, err = ioutil.ReadFile(HTMLContentPath)
 		if err != nil {
 			return nil, modified, err
 		}
-		modified = HTMLFileInfo.ModTime()
+		modified = clampTime(HTMLFileInfo.ModTime())
 	} else {
 		err := fmt.Errorf("no '%s' or '%s' found", MDContentPath, HTMLContentPath)
 		return nil, modified, err
@@ -199,25 +294,26 @@ func getPreviousItem(articlePath string) (jsfItem, bool, error) {
 func filesFromAttachPathMap(attachPathMap map[string]bool) ([]string, []*os.File, []io.Reader, error) {
 	var err error
 	pathCount := len(attachPathMap)
-	attachPathList := make([]string, pathCount)
+	attachPathList := make([]string, 0, pathCount)
 	attachFileList := make([]*os.File, pathCount)
 	attachReaderList := make([]io.Reader, pathCount)
-	i := 0
 	for path := range attachPathMap {
-		attachPathList[i] = path
+		attachPathList = append(attachPathList, path)
+	}
+	sort.Strings(attachPathList) //Map order is random
+	for i, path := range attachPathList {
 		attachFileList[i], err = os.Open(path)
 		if err != nil {
 			return attachPathList, attachFileList, attachReaderList, err
 		}
 		attachReaderList[i] = attachFileList[i]
-		i++
 	}
 	return attachPathList, attachFileList, attachReaderList, nil
 }
 
 func writeItemFile(res jsfItem, articlePath string) error {
 	itemFilePath := filepath.Join(articlePath, itemFile)
-	f, err := os.Create(itemFilePath)
+	f, err := ioutil.TempFile(articlePath, itemFile+".*") //Renamed into place, other articles read it while building
 	if err != nil {
 		return err
 	}
@@ -225,38 +321,91 @@ func writeItemFile(res jsfItem, articlePath string) error {
 	enc.SetEscapeHTML(false)
 	err = enc.Encode(res)
 	if err != nil {
+		f.Close()
+		os.Remove(f.Name())
 		return err
 	}
 	err = f.Close()
-	return err
+	if err != nil {
+		os.Remove(f.Name())
+		return err
+	}
+	err = os.Chmod(f.Name(), 0664)
+	if err != nil {
+		os.Remove(f.Name())
+		return err
+	}
+	return os.Rename(f.Name(), itemFilePath)
 }
 
-func (res *jsfItem) initAttachments(articlePath string) error {
-	attachPathMap, err := getAttachPaths(articlePath)
+func (res *jsfItem) initAttachments(articlePath, articleURLPath string, rules attachmentRules) error {
+	attachPathMap, err := getAttachPaths(articlePath, rules)
 	if err != nil {
 		return err
 	}
+	for attachPath, published := range attachPathMap {
+		if !published {
+			delete(attachPathMap, attachPath) //Copied, but not in the feeds
+		}
+	}
 	attachPathList, attachFileList, attachReaderList, err := filesFromAttachPathMap(attachPathMap)
+	defer func() {
+		for _, attachFile := range attachFileList {
+			if attachFile != nil {
+				attachFile.Close() //Only read from, so nothing is lost
+			}
+		}
+	}()
 	if err != nil {
 		return err
 	}
-	res.Attachments, err = attachmentsFromReaders(filepath.Base(articlePath), attachPathList, attachReaderList)
+	relPathList := make([]string, len(attachPathList))
+	for i, attachPath := range attachPathList {
+		relPathList[i], err = filepath.Rel(filepath.Join(articlePath, attachmentDir), attachPath)
+		if err != nil {
+			return err
+		}
+	}
+	res.Attachments, err = attachmentsFromReaders(articleURLPath, relPathList, attachReaderList)
 	if err != nil {
 		return err
 	}
-	for _, reader := range attachFileList {
-		closeErr := reader.Close()
-		if closeErr != nil {
-			err = closeErr
+	for i, attachFile := range attachFileList {
+		err = res.Attachments[i].initDetails(attachPathList[i], attachFile)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func otherItems(blogPath, articlePath string) ([]jsfItem, error) {
+	articlePaths, err := findArticlePaths(blogPath)
+	if err != nil {
+		return nil, err
+	}
+	res := make([]jsfItem, 0, len(articlePaths))
+	for _, otherPath := range articlePaths {
+		if filepath.Clean(otherPath) == filepath.Clean(articlePath) {
+			continue
 		}
+		ji, _, err := getPreviousItem(otherPath)
+		if err != nil {
+			return nil, err
+		}
+		ji.dir, err = articleURLPath(blogPath, otherPath)
+		if err != nil {
+			return nil, err
+		}
+		res = append(res, ji)
 	}
-	return err
+	return res, nil
 }
 
 func getOldData(articlePath, title, tagList string) (time.Time, string, string, error) {
 	prevItem, prevItemExists, err := getPreviousItem(articlePath)
 	if err != nil || !prevItemExists {
-		return time.Now(), title, tagList, err
+		return buildTime(), title, tagList, err
 	}
 	if len(title) < 1 {
 		title = prevItem.Title
@@ -281,35 +430,370 @@ func (exportArgs *articleExport) writeFinalWebpage(tmpl *template.Template, arti
 	return f.Close()
 }
 
-func processArticle(tmpl *template.Template, articleRelativePath, title, tagList string) (jsfItem, error) {
-	var res jsfItem
+func articleURLPath(blogPath, articlePath string) (string, error) {
+	rel, err := filepath.Rel(blogPath, articlePath)
+	if err != nil {
+		return "", err
+	}
+	rel = filepath.ToSlash(rel)
+	if rel == "." || rel == ".." || strings.HasPrefix(rel, "../") {
+		return "", fmt.Errorf("article '%s' is not inside blog '%s'", articlePath, blogPath)
+	}
+	return rel, nil
+}
+
+func findBlogPath(articleRelativePath, configPath string) (string, error) {
 	articlePath, err := filepath.Abs(articleRelativePath)
+	if err != nil {
+		return "", err
+	}
+	configDir, err := filepath.Abs(filepath.Dir(configPath))
+	if err != nil {
+		return "", err
+	}
+	for p := articlePath; filepath.Dir(p) != p; p = filepath.Dir(p) {
+		if filepath.Dir(p) == configDir && p != articlePath {
+			return p, nil //The config file sits beside the blog directory, like the templates
+		}
+	}
+	return "", fmt.Errorf("article '%s' is not in a blog beside config file '%s', use -blogdir", articleRelativePath, configPath)
+}
+
+func authorsFromList(authorList string, prevAuthors []jsfAuthor) []jsfAuthor {
+	res := make([]jsfAuthor, 0)
+	for _, name := range strings.Split(authorList, listSeperator) {
+		name = strings.TrimSpace(name)
+		if len(name) < 1 {
+			continue
+		}
+		author := jsfAuthor{Name: name}
+		for _, prev := range prevAuthors {
+			if prev.Name == name {
+				author = prev //Keeps a url or avatar added by hand
+			}
+		}
+		res = append(res, author)
+	}
+	return res
+}
+
+func (res *jsfItem) keepAuthorMetadata(prevItem jsfItem) {
+	res.Image = prevItem.Image
+	res.BannerImage = prevItem.BannerImage
+	res.ExternalURL = prevItem.ExternalURL
+	res.Language = prevItem.Language
+	res.Authors = prevItem.Authors
+	if len(res.Authors) < 1 && prevItem.Author != nil {
+		res.Authors = []jsfAuthor{*prevItem.Author}
+	}
+
+	prevAttachments := make(map[string]jsfAttachment)
+	for _, ja := range prevItem.Attachments {
+		prevAttachments[ja.URL] = ja
+	}
+	for i, ja := range res.Attachments {
+		prev := prevAttachments[ja.URL]
+		if len(ja.Title) < 1 {
+			res.Attachments[i].Title = prev.Title
+		}
+		if ja.DurationInSeconds == 0 {
+			res.Attachments[i].DurationInSeconds = prev.DurationInSeconds
+		}
+		if ja.Blom != nil && len(ja.Blom.Caption) < 1 && prev.Blom != nil {
+			res.Attachments[i].Blom.Caption = prev.Blom.Caption
+		}
+	}
+}
+
+func copyFile(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(out, in)
+	if err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
+
+func copyAttachments(articlePath, outputPath string, rules attachmentRules) (map[string][]string, error) {
+	res := make(map[string][]string) //What was stripped from each copy
+	attachPathMap, err := getAttachPaths(articlePath, rules)
+	if err != nil {
+		return nil, err
+	}
+	sourceAttachPath := filepath.Join(articlePath, attachmentDir)
+	outputAttachPath := filepath.Join(outputPath, attachmentDir)
+	err = os.MkdirAll(outputAttachPath, 0775)
+	if err != nil {
+		return nil, err
+	}
+	for attachPath := range attachPathMap {
+		relPath, err := filepath.Rel(sourceAttachPath, attachPath)
+		if err != nil {
+			return nil, err
+		}
+		dst := filepath.Join(outputAttachPath, relPath)
+		err = os.MkdirAll(filepath.Dir(dst), 0775)
+		if err != nil {
+			return nil, err
+		}
+		removed, err := copyAttachment(attachPath, dst)
+		if err != nil {
+			return nil, err
+		}
+		if len(removed) > 0 {
+			res[filepath.ToSlash(relPath)] = removed
+		}
+	}
+	return res, nil
+}
+
+type articlePlan struct {
+	dir       string
+	published time.Time
+	title     string
+	tagList   string
+	prevItem  jsfItem
+	meta      blomMeta
+	urlPath   string
+	url       string
+	id        string //Set by assignID
+	language  *string
+	authors   []jsfAuthor //Nil keeps the previous authors
+}
+
+func planArticle(blogPath, articlePath string, flags articleFlags) (articlePlan, error) {
+	var res articlePlan
+	var err error
+	res.dir, err = articleURLPath(blogPath, articlePath)
+	if err != nil {
+		return res, err
+	}
+	res.published, res.title, res.tagList, err = getOldData(articlePath, flags.title, flags.tagList)
 	if err != nil {
 		return res, err
 	}
+	res.prevItem, _, err = getPreviousItem(articlePath)
+	if err != nil {
+		return res, err
+	}
+	if res.prevItem.Blom != nil {
+		res.meta = *res.prevItem.Blom
+	}
+	if len(flags.slug) > 0 {
+		res.meta.Slug = flags.slug
+	}
+	if len(flags.summary) > 0 {
+		res.meta.Summary = flags.summary
+	}
+	if flags.season > 0 {
+		res.meta.Season = flags.season
+	}
+	if flags.episode > 0 {
+		res.meta.Episode = flags.episode
+	}
+	if flags.explicit != nil {
+		res.meta.Explicit = flags.explicit
+	}
+	if flags.toc != nil {
+		res.meta.TOC = *flags.toc
+	}
+	if flags.gallery == galleryOrderNone {
+		res.meta.Gallery = ""
+	} else if len(flags.gallery) > 0 {
+		res.meta.Gallery = flags.gallery
+	}
+	if !validGalleryOrder(res.meta.Gallery) {
+		return res, fmt.Errorf("unsupported gallery order '%s'", res.meta.Gallery)
+	}
+	res.language = flags.language
+	if flags.authors != nil {
+		res.authors = authorsFromList(*flags.authors, res.prevItem.Authors)
+	}
+	if len(flags.aliasList) > 0 {
+		for _, alias := range strings.Split(flags.aliasList, listSeperator) {
+			if _, err := resolveAlias(alias); len(alias) > 0 && err != nil {
+				return res, err
+			}
+			res.meta.Aliases = addAlias(res.meta.Aliases, alias)
+		}
+	}
+	res.urlPath, err = expandPermalink(conf.permalinkFor(res.dir), res.published, articleSlug(res.meta.Slug, res.title, res.dir), res.dir)
+	if err != nil {
+		return res, err
+	}
+	res.url, err = itemURL(res.urlPath)
+	if err != nil {
+		return res, err
+	}
+	if _, err := os.Stat(filepath.Join(articlePath, attachmentDir)); err == nil && conf.StripMetadata && strings.Trim(res.urlPath, "/") == res.dir {
+		return res, fmt.Errorf("the permalink of '%s' is its source directory, so strip_metadata would publish the originals too", res.dir)
+	}
+	return res, nil
+}
+
+func (plan articlePlan) claim() (urlClaim, error) {
+	res := urlClaim{dir: plan.dir, urlPath: "/" + strings.Trim(plan.urlPath, "/")}
+	aliases := plan.meta.Aliases
+	if len(plan.prevItem.URL) > 0 {
+		aliases = addAlias(aliases, plan.prevItem.URL) //Becomes an alias if the URL changes
+	}
+	var err error
+	res.aliases, err = aliasPaths(aliases)
+	return res, err
+}
+
+func processArticle(tmpl *template.Template, blogRelativePath, articleRelativePath string, flags articleFlags) (jsfItem, error) {
+	blogPath, err := filepath.Abs(blogRelativePath)
+	if err != nil {
+		return jsfItem{}, err
+	}
+	articlePath, err := filepath.Abs(articleRelativePath)
+	if err != nil {
+		return jsfItem{}, err
+	}
+	plan, err := planArticle(blogPath, articlePath, flags)
+	if err != nil {
+		return jsfItem{}, err
+	}
+	others, err := otherItems(blogPath, articlePath)
+	if err != nil {
+		return jsfItem{}, err
+	}
+	claims, err := itemClaims(others)
+	if err != nil {
+		return jsfItem{}, err
+	}
+	c, err := plan.claim()
+	if err != nil {
+		return jsfItem{}, err
+	}
+	err = findURLCollisions(append(claims, c)) //Before writing anything
+	if err != nil {
+		return jsfItem{}, err
+	}
+	err = plan.assignID(itemIDs(others))
+	if err != nil {
+		return jsfItem{}, err
+	}
+	res, err := processPlannedArticle(tmpl, blogPath, articlePath, plan)
+	if err != nil {
+		return res, err
+	}
+	return res, writeStripReport(blogPath, []jsfItem{res})
+}
+
+func processPlannedArticle(tmpl *template.Template, blogPath, articlePath string, plan articlePlan) (jsfItem, error) {
+	var res jsfItem
 	content, modified, err := getArticleContent(articlePath)
 	if err != nil {
 		return res, err
 	}
-	published, title, tagList, err := getOldData(articlePath, title, tagList)
+	outputPath := filepath.Join(blogPath, filepath.FromSlash(plan.urlPath))
+	err = os.MkdirAll(outputPath, 0775)
 	if err != nil {
 		return res, err
 	}
-	var exportArgs articleExport
-	exportArgs.init(published, title, content)
-	err = exportArgs.writeFinalWebpage(tmpl, articlePath)
+
+	err = res.init(plan.published, modified, plan.title, plan.urlPath, plan.tagList)
 	if err != nil {
 		return res, err
 	}
-	res.init(published, modified, title, articlePath, tagList)
+	res.dir = plan.dir
+	if len(plan.prevItem.URL) > 0 && plan.prevItem.URL != res.URL {
+		plan.meta.Aliases = addAlias(plan.meta.Aliases, plan.prevItem.URL)
+	}
+	res.ID = plan.id
+	if !reflect.DeepEqual(plan.meta, blomMeta{}) {
+		res.Blom = &plan.meta
+	}
 
 	if _, err := os.Stat(filepath.Join(articlePath, attachmentDir)); err == nil {
-		err = res.initAttachments(articlePath)
+		rules := conf.Attachments.merge(plan.meta.Attachments)
+		err = res.initAttachments(articlePath, strings.TrimSuffix(plan.urlPath, "/"), rules)
+		if err != nil {
+			return res, err
+		}
+		if outputPath != articlePath {
+			stripped, err := copyAttachments(articlePath, outputPath, rules)
+			if err != nil {
+				return res, err
+			}
+			for relPath, removed := range stripped {
+				publishedPath := path.Join(strings.Trim(plan.urlPath, "/"), attachmentDir, relPath)
+				res.stripped = append(res.stripped, publishedPath+": "+strings.Join(removed, ", "))
+			}
+		}
+		if conf.StripMetadata {
+			err = res.strippedAttachmentDetails(outputPath)
+			if err != nil {
+				return res, err
+			}
+		}
+	}
+
+	summary := articleSummary(plan.meta.Summary, content) //Sanitising drops the <!--more--> marker
+	if conf.Sanitize != nil {
+		var report []string
+		content, report = sanitizeHTML(content, conf.Sanitize.contentPolicy())
+		logSanitizeReport(fmt.Sprintf("content of '%s'", plan.dir), report)
+	}
+	var images map[string]responsiveImage
+	if conf.Images != nil {
+		images, err = responsiveImages(res.Attachments, articlePath, blogPath, *conf.Images)
+		if err != nil {
+			return res, err
+		}
+		content, err = addResponsiveImages(content, res.URL, images, conf.Images.sizes()) //After sanitising, the markup is ours
+		if err != nil {
+			return res, err
+		}
+	}
+
+	var exportArgs articleExport
+	if len(plan.meta.Gallery) > 0 {
+		exportArgs.Gallery, err = buildGallery(res.Attachments, articlePath, blogPath, res.URL, plan.meta.Gallery)
 		if err != nil {
 			return res, err
 		}
+		exportArgs.GalleryHTML = galleryHTML(exportArgs.Gallery)
+		res.Gallery = exportArgs.Gallery
+	}
+	if plan.meta.TOC {
+		content = addHeadingIDs(content) //HTML articles may not have them
+		exportArgs.TOC = buildTOC(content, conf.TOCDepth)
+		exportArgs.TOCHTML = tocHTML(exportArgs.TOC)
+	}
+	exportArgs.init(plan.published, plan.title, content)
+	exportArgs.Summary = summary
+	err = exportArgs.writeFinalWebpage(conf.templateFor(plan.dir, tmpl), outputPath)
+	if err != nil {
+		return res, err
+	}
+	err = writeGalleryPages(conf.templateFor(plan.dir, tmpl), exportArgs, res.URL, outputPath)
+	if err != nil {
+		return res, err
 	}
 	res.ContentHTML = string(content)
+	res.Summary = exportArgs.Summary
+	res.keepAuthorMetadata(plan.prevItem)
+	if plan.language != nil {
+		res.Language = *plan.language
+	}
+	if plan.authors != nil {
+		res.Authors = plan.authors
+	}
+	if conf.Images != nil {
+		res.Image = itemImage(res.Image, res.Attachments, images)
+	}
 	err = writeItemFile(res, articlePath)
 	return res, err
 }
@@ -10,6 +10,7 @@ import (
 	"io/ioutil"
 	"os"
 	"path/filepath"
+	"reflect"
 	"strings"
 	"testing"
 	"time"
@@ -259,7 +260,7 @@ func teardownArticlePath(t *testing.T, articlePath string) {
 
 func TestGetAttachPaths(t *testing.T) {
 	articlePath, _, expectedAttachPaths := setupAttachPaths(t)
-	attachPaths, err := getAttachPaths(articlePath)
+	attachPaths, err := getAttachPaths(articlePath, conf.Attachments.merge(nil))
 	if err != nil {
 		t.Errorf("Error (%s) for valid inputs.", err.Error())
 	}
@@ -328,7 +329,7 @@ func TestGetArticleContentMD(t *testing.T) {
 		t.Errorf("Error (%s) with valid inputs.", err.Error())
 	}
 
-	expectedArticleContent := "<h2>This is a heading</h2>\n"
+	expectedArticleContent := "<h2 id=\"this-is-a-heading\">This is a heading</h2>\n"
 	if string(articleContent) != expectedArticleContent {
 		t.Errorf("Wrong content, expected '%s', actual '%s'.", expectedArticleContent, string(articleContent))
 	}
@@ -424,7 +425,7 @@ func TestFilesFromAttachPathMap(t *testing.T) {
 
 	attachPathList, attachFileList, attachReaderList, err := filesFromAttachPathMap(attachPathMap)
 	if err != nil {
-		t.Errorf("Error (%s) when all parameters valid")
+		t.Errorf("Error (%s) when all parameters valid", err.Error())
 	}
 
 	attachPathListLen := len(attachPathList)
@@ -517,7 +518,7 @@ func TestWriteItemFile(t *testing.T) {
 func TestInitAttachments(t *testing.T) {
 	articlePath, attachPath, attachPathMap := setupAttachPaths(t)
 	var ji jsfItem
-	ji.initAttachments(articlePath)
+	ji.initAttachments(articlePath, filepath.Base(articlePath), conf.Attachments.merge(nil))
 
 	for _, attach := range ji.Attachments {
 		if attach.MIMEType != "image/jpeg" {
@@ -665,7 +666,7 @@ func TestProcessArticleContentNoAttachments(t *testing.T) {
 		t.Errorf("Error (%s) BEFORE STARTING TEST.", err.Error())
 	}
 
-	ji, err := processArticle(tmpl, articlePath, "Ignore Me!", "ignore,me")
+	ji, err := processArticle(tmpl, ".", articlePath, articleFlags{title: "Ignore Me!", tagList: "ignore,me"})
 	if err != nil {
 		t.Errorf("Error (%s) when all parameters valid", err.Error())
 	}
@@ -676,3 +677,130 @@ func TestProcessArticleContentNoAttachments(t *testing.T) {
 
 	teardownArticlePath(t, articlePath)
 }
+
+func TestProcessArticleNested(t *testing.T) {
+	templateStr := "{{.Title}}\n{{.Date}}\n{{.Today}}\n{{.ContentHTML}}"
+	tmpl := template.New("Whatever")
+	tmpl.Parse(templateStr)
+
+	blogPath := setupArticlePath(t)
+	articlePath := filepath.Join(blogPath, "2020", "trip")
+	err := os.MkdirAll(filepath.Join(articlePath, attachmentDir), 0777)
+	if err != nil {
+		t.Errorf("Error (%s) BEFORE STARTING TEST.", err.Error())
+	}
+	err = ioutil.WriteFile(filepath.Join(articlePath, contentFileHTML), []byte("<p>Trip</p>"), 0664)
+	if err != nil {
+		t.Errorf("Error (%s) BEFORE STARTING TEST.", err.Error())
+	}
+	err = ioutil.WriteFile(filepath.Join(articlePath, attachmentDir, "1200.jpg"), jpegBytes, 0664)
+	if err != nil {
+		t.Errorf("Error (%s) BEFORE STARTING TEST.", err.Error())
+	}
+
+	ji, err := processArticle(tmpl, blogPath, articlePath, articleFlags{title: "Trip"})
+	if err != nil {
+		t.Errorf("Error (%s) when all parameters valid", err.Error())
+	}
+
+	expectedURL := hostRawURL + "/2020/trip"
+	if ji.URL != expectedURL {
+		t.Errorf("Wrong URL, expected '%s', actual '%s'.", expectedURL, ji.URL)
+	}
+	expectedAttachURL := expectedURL + "/" + attachmentDir + "/1200.jpg"
+	if len(ji.Attachments) != 1 || ji.Attachments[0].URL != expectedAttachURL {
+		t.Errorf("Wrong attachments, expected URL '%s', actual %v.", expectedAttachURL, ji.Attachments)
+	}
+
+	_, err = processArticle(tmpl, articlePath, blogPath, articleFlags{title: "Outside"})
+	if err == nil {
+		t.Errorf("No error for article outside the blog")
+	}
+
+	teardownArticlePath(t, blogPath)
+}
+
+var findBlogPathTests = []struct {
+	articlePath string
+	configPath  string
+	expected    string
+	valid       bool
+}{
+	{filepath.Join("site", "public", "trip"), filepath.Join("site", "blom.json"), filepath.Join("site", "public"), true},
+	{filepath.Join("site", "public", "2020", "trip"), filepath.Join("site", "blom.json"), filepath.Join("site", "public"), true},
+	{filepath.Join("site", "public"), filepath.Join("site", "blom.json"), "", false},
+	{filepath.Join("other", "public", "trip"), filepath.Join("site", "blom.json"), "", false},
+}
+
+func TestFindBlogPath(t *testing.T) {
+	for _, test := range findBlogPathTests {
+		res, err := findBlogPath(test.articlePath, test.configPath)
+		if (err == nil) != test.valid {
+			t.Errorf("Wrong validity for '%s' with '%s', expected %v, actual error %v", test.articlePath, test.configPath, test.valid, err)
+			continue
+		}
+		expected, _ := filepath.Abs(test.expected)
+		if test.valid && res != expected {
+			t.Errorf("Wrong blog path for '%s', expected '%s', actual '%s'", test.articlePath, expected, res)
+		}
+	}
+}
+
+func TestProcessArticleLanguageAuthors(t *testing.T) {
+	tmpl := template.Must(template.New("Whatever").Parse("{{.Title}}"))
+	blogPath := setupArticlePath(t)
+	defer teardownArticlePath(t, blogPath)
+	articlePath := filepath.Join(blogPath, "hello")
+	err := os.Mkdir(articlePath, 0777)
+	if err != nil {
+		t.Fatalf("Error (%s) BEFORE STARTING TEST.", err.Error())
+	}
+	setupArticle(t, articlePath, []byte(`{"title": "Hello", "date_published": "2017-06-10T10:00:00Z", "authors": [{"name": "Ratan", "url": "http://ratan.blog/about"}]}`), []byte("Hi"))
+
+	language, authorList := "fr", "Ratan, Guest"
+	ji, err := processArticle(tmpl, blogPath, articlePath, articleFlags{language: &language, authors: &authorList})
+	if err != nil {
+		t.Fatalf("Error (%s) when all parameters valid.", err.Error())
+	}
+	expectedAuthors := []jsfAuthor{{Name: "Ratan", URL: "http://ratan.blog/about"}, {Name: "Guest"}}
+	if ji.Language != "fr" || !reflect.DeepEqual(ji.Authors, expectedAuthors) {
+		t.Errorf("Wrong language or authors, expected 'fr' and %v, actual '%s' and %v", expectedAuthors, ji.Language, ji.Authors)
+	}
+
+	ji, err = processArticle(tmpl, blogPath, articlePath, articleFlags{})
+	if err != nil || ji.Language != "fr" || len(ji.Authors) != 2 {
+		t.Errorf("Language or authors not kept (%v): '%s', %v", err, ji.Language, ji.Authors)
+	}
+
+	language, authorList = "", ""
+	ji, err = processArticle(tmpl, blogPath, articlePath, articleFlags{language: &language, authors: &authorList})
+	if err != nil || len(ji.Language) > 0 || len(ji.Authors) > 0 {
+		t.Errorf("Language or authors not cleared (%v): '%s', %v", err, ji.Language, ji.Authors)
+	}
+}
+
+func TestKeepAuthorMetadata(t *testing.T) {
+	oldItemJSON := `{"id":"http://ratan.blog/hello","url":"http://ratan.blog/hello","title":"Hello","content_html":"","date_published":"2017-06-10T00:00:00Z","date_modified":"2017-06-10T00:00:00Z","author":{"name":"Ratan"},"image":"http://ratan.blog/hello/attachments/1200.jpg","tags":null,"attachments":[{"url":"http://ratan.blog/hello/attachments/a.mp3","mime_type":"audio/mpeg","title":"Episode 1","duration_in_seconds":61.5}]}`
+	var prevItem jsfItem
+	err := json.Unmarshal([]byte(oldItemJSON), &prevItem)
+	if err != nil {
+		t.Errorf("Error (%s) loading JSON Feed 1.0 item.", err.Error())
+	}
+
+	var ji jsfItem
+	ji.Attachments = []jsfAttachment{{URL: "http://ratan.blog/hello/attachments/a.mp3", MIMEType: "audio/mpeg", SizeInBytes: 100}}
+	ji.keepAuthorMetadata(prevItem)
+	if len(ji.Authors) != 1 || ji.Authors[0].Name != "Ratan" {
+		t.Errorf("Legacy author not converted: %v", ji.Authors)
+	}
+	if ji.Author != nil {
+		t.Errorf("Legacy author kept: %v", ji.Author)
+	}
+	if ji.Image != prevItem.Image {
+		t.Errorf("Wrong image, expected '%s', actual '%s'", prevItem.Image, ji.Image)
+	}
+	attach := ji.Attachments[0]
+	if attach.Title != "Episode 1" || attach.DurationInSeconds != 61.5 || attach.SizeInBytes != 100 {
+		t.Errorf("Wrong attachment metadata: %v", attach)
+	}
+}
@@ -0,0 +1,207 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"fmt"
+	"image"
+	_ "image/gif" //For image.DecodeConfig
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+const sidecarExt = ".txt"
+
+var extensionMIMETypes = map[string]string{ //Sniffing can't tell SVG from XML, CSS from text, or most audio and video apart
+	".avif":  "image/avif",
+	".bmp":   "image/bmp",
+	".css":   "text/css; charset=utf-8",
+	".csv":   "text/csv; charset=utf-8",
+	".epub":  "application/epub+zip",
+	".flac":  "audio/flac",
+	".gif":   "image/gif",
+	".gpx":   "application/gpx+xml",
+	".htm":   "text/html; charset=utf-8",
+	".html":  "text/html; charset=utf-8",
+	".ico":   "image/vnd.microsoft.icon",
+	".jpeg":  "image/jpeg",
+	".jpg":   "image/jpeg",
+	".js":    "text/javascript; charset=utf-8",
+	".json":  "application/json",
+	".m4a":   "audio/mp4",
+	".m4b":   "audio/mp4",
+	".m4v":   "video/mp4",
+	".md":    "text/markdown; charset=utf-8",
+	".mov":   "video/quicktime",
+	".mp3":   "audio/mpeg",
+	".mp4":   "video/mp4",
+	".oga":   "audio/ogg",
+	".ogg":   "audio/ogg",
+	".ogv":   "video/ogg",
+	".opus":  "audio/ogg",
+	".pdf":   "application/pdf",
+	".png":   "image/png",
+	".svg":   "image/svg+xml",
+	".tif":   "image/tiff",
+	".tiff":  "image/tiff",
+	".txt":   "text/plain; charset=utf-8",
+	".wav":   "audio/wav",
+	".webm":  "video/webm",
+	".webp":  "image/webp",
+	".woff":  "font/woff",
+	".woff2": "font/woff2",
+	".xml":   "application/xml",
+	".zip":   "application/zip",
+}
+
+var defaultAttachmentExclude = []string{".*", "*~", "*.swp", "*.swo", "*.tmp", "Thumbs.db", "desktop.ini"}
+
+type attachmentRules struct {
+	Include []string `json:"include,omitempty"` //Everything if unset
+	Exclude []string `json:"exclude,omitempty"` //As well as hidden, backup and swap files
+	Publish []string `json:"publish,omitempty"` //Everything included if unset
+}
+
+type blomAttachment struct {
+	SHA256  string `json:"sha256"`
+	Width   int    `json:"width,omitempty"`
+	Height  int    `json:"height,omitempty"`
+	Caption string `json:"caption,omitempty"`
+}
+
+func (ar attachmentRules) merge(article *attachmentRules) attachmentRules {
+	res := ar
+	res.Exclude = append(append([]string(nil), defaultAttachmentExclude...), ar.Exclude...)
+	if article == nil {
+		return res
+	}
+	if article.Include != nil {
+		res.Include = article.Include
+	}
+	res.Exclude = append(res.Exclude, article.Exclude...)
+	if article.Publish != nil {
+		res.Publish = article.Publish
+	}
+	return res
+}
+
+func (ar attachmentRules) included(relPath string) bool {
+	return ar.Include == nil || matchesPattern(ar.Include, relPath, false)
+}
+
+func (ar attachmentRules) published(relPath string) bool {
+	return ar.Publish == nil || matchesPattern(ar.Publish, relPath, false)
+}
+
+func attachmentMIMEType(name, sniffed string) string {
+	if mimeType, ok := extensionMIMETypes[strings.ToLower(filepath.Ext(name))]; ok {
+		return mimeType
+	}
+	return sniffed
+}
+
+func isSidecar(attachPath string, attachPathMap map[string]bool) bool {
+	return strings.EqualFold(filepath.Ext(attachPath), sidecarExt) && attachPathMap[strings.TrimSuffix(attachPath, filepath.Ext(attachPath))]
+}
+
+func readSidecar(attachPath string) (string, string, error) {
+	content, err := ioutil.ReadFile(attachPath + sidecarExt)
+	if os.IsNotExist(err) {
+		return "", "", nil
+	} else if err != nil {
+		return "", "", err
+	}
+	lines := strings.SplitN(strings.TrimSpace(strings.Replace(string(content), "\r\n", "\n", -1)), "\n", 2)
+	title := strings.TrimSpace(lines[0])
+	caption := ""
+	if len(lines) > 1 {
+		caption = strings.TrimSpace(lines[1])
+	}
+	return title, caption, nil
+}
+
+func readerSHA256(r io.ReadSeeker) (string, error) {
+	_, err := r.Seek(0, io.SeekStart)
+	if err != nil {
+		return "", err
+	}
+	h := sha256.New()
+	_, err = io.Copy(h, r)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%x", h.Sum(nil)), nil
+}
+
+func imageDimensions(r io.ReadSeeker, mimeType string) (int, int) {
+	_, err := r.Seek(0, io.SeekStart)
+	if err != nil {
+		return 0, 0
+	}
+	content, err := ioutil.ReadAll(r)
+	if err != nil {
+		return 0, 0
+	}
+	cfg, _, err := image.DecodeConfig(bytes.NewReader(content))
+	if err != nil {
+		return 0, 0 //Not every image type has a decoder, SVG has no fixed size
+	}
+	if imageOrientation(content, mimeType) >= 5 {
+		return cfg.Height, cfg.Width
+	}
+	return cfg.Width, cfg.Height
+}
+
+func videoDuration(name string, r io.ReadSeeker, size int64) (float64, error) {
+	_, err := r.Seek(0, io.SeekStart)
+	if err != nil {
+		return 0, err
+	}
+	duration, err := mp4Duration(r, size)
+	if err != nil {
+		return 0, fmt.Errorf("reading duration of '%s': %s", name, err.Error())
+	}
+	return duration, nil
+}
+
+func (ja *jsfAttachment) initDetails(attachPath string, f *os.File) error {
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	ja.SizeInBytes = info.Size()
+	ja.Blom = &blomAttachment{}
+	ja.Blom.SHA256, err = readerSHA256(f)
+	if err != nil {
+		return err
+	}
+	_, err = f.Seek(0, io.SeekStart)
+	if err != nil {
+		return err
+	}
+	mimeType, duration, err := audioInfo(attachPath, f, info.Size())
+	if err != nil && len(mimeType) < 1 {
+		return err
+	} else if err != nil {
+		log.Printf("Warning: %s, leaving duration_in_seconds at 0", err.Error()) //The file is still worth publishing
+	}
+	if len(mimeType) > 0 {
+		ja.MIMEType = mimeType //Sniffing misses MP3 without ID3 tags, and calls M4A video
+		ja.DurationInSeconds = duration
+	}
+	switch {
+	case strings.HasPrefix(ja.MIMEType, "image/"):
+		ja.Blom.Width, ja.Blom.Height = imageDimensions(f, ja.MIMEType)
+	case ja.MIMEType == "video/mp4" || ja.MIMEType == "video/quicktime":
+		ja.DurationInSeconds, err = videoDuration(attachPath, f, info.Size())
+		if err != nil {
+			log.Printf("Warning: %s, leaving duration_in_seconds at 0", err.Error())
+		}
+	}
+	ja.Title, ja.Blom.Caption, err = readSidecar(attachPath)
+	return err
+}
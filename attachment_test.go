@@ -0,0 +1,273 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+var attachmentMIMETypeTests = []struct {
+	name     string
+	sniffed  string
+	expected string
+}{
+	{"logo.svg", "text/xml; charset=utf-8", "image/svg+xml"},
+	{"style.CSS", "text/plain; charset=utf-8", "text/css; charset=utf-8"},
+	{"episode.mp3", "application/octet-stream", "audio/mpeg"},
+	{"clip.mp4", "video/mp4", "video/mp4"},
+	{"photo.jpg", "image/jpeg", "image/jpeg"},
+	{"data.unknown", "application/octet-stream", "application/octet-stream"},
+	{"README", "text/plain; charset=utf-8", "text/plain; charset=utf-8"},
+}
+
+func TestAttachmentMIMEType(t *testing.T) {
+	for _, test := range attachmentMIMETypeTests {
+		res := attachmentMIMEType(test.name, test.sniffed)
+		if res != test.expected {
+			t.Errorf("Wrong MIME type for '%s', expected '%s', actual '%s'", test.name, test.expected, res)
+		}
+	}
+}
+
+func TestAttachmentsFromReadersSmall(t *testing.T) {
+	filenames := []string{"empty.txt", "tiny.css", "small.bin"}
+	readers := []io.Reader{bytes.NewReader(nil), bytes.NewReader([]byte("p{}")), bytes.NewReader([]byte{0, 1, 2})}
+	attachments, err := attachmentsFromReaders("demo", filenames, readers)
+	if err != nil {
+		t.Errorf("Error (%s) with small files.", err.Error())
+	}
+	expected := []string{"text/plain; charset=utf-8", "text/css; charset=utf-8", "application/octet-stream"}
+	for i, mimeType := range expected {
+		if attachments[i].MIMEType != mimeType {
+			t.Errorf("Wrong MIME type for '%s', expected '%s', actual '%s'", filenames[i], mimeType, attachments[i].MIMEType)
+		}
+	}
+}
+
+func TestReadSidecar(t *testing.T) {
+	articlePath := setupArticlePath(t)
+	defer teardownArticlePath(t, articlePath)
+	attachPath := filepath.Join(articlePath, "photo.jpg")
+	title, caption, err := readSidecar(attachPath)
+	if err != nil || len(title) > 0 || len(caption) > 0 {
+		t.Errorf("Wrong result without sidecar: '%s', '%s', %v", title, caption, err)
+	}
+	err = ioutil.WriteFile(attachPath+sidecarExt, []byte("Sunset\r\n\r\nFrom the pier,\nlooking west.\n"), 0664)
+	if err != nil {
+		t.Errorf("Error (%s) PRIOR TO RUNNING TEST.", err.Error())
+	}
+	title, caption, err = readSidecar(attachPath)
+	if err != nil {
+		t.Errorf("Error (%s) when all parameters valid.", err.Error())
+	}
+	if title != "Sunset" || caption != "From the pier,\nlooking west." {
+		t.Errorf("Wrong sidecar, title '%s', caption '%s'", title, caption)
+	}
+}
+
+func TestInitAttachmentsDetails(t *testing.T) {
+	articlePath := setupArticlePath(t)
+	defer teardownArticlePath(t, articlePath)
+	attachPath := filepath.Join(articlePath, attachmentDir)
+	err := os.Mkdir(attachPath, 0777)
+	if err != nil {
+		t.Errorf("Error (%s) PRIOR TO RUNNING TEST.", err.Error())
+	}
+	photo := testImageBytes(t, 30, 10, "png")
+	files := map[string][]byte{
+		"b.png":     photo,
+		"b.png.txt": []byte("Sunset\nFrom the pier"),
+		"a.svg":     []byte("<svg xmlns=\"http://www.w3.org/2000/svg\"/>"),
+		"c.txt":     nil,
+	}
+	for name, content := range files {
+		err = ioutil.WriteFile(filepath.Join(attachPath, name), content, 0664)
+		if err != nil {
+			t.Errorf("Error (%s) PRIOR TO RUNNING TEST.", err.Error())
+		}
+	}
+
+	var ji jsfItem
+	err = ji.initAttachments(articlePath, "demo", conf.Attachments.merge(nil))
+	if err != nil {
+		t.Errorf("Error (%s) when all parameters valid.", err.Error())
+	}
+	if len(ji.Attachments) != 3 {
+		t.Fatalf("Wrong number of attachments, expected 3, actual %d: %v", len(ji.Attachments), ji.Attachments)
+	}
+	expectedURLs := []string{hostRawURL + "/demo/attachments/a.svg", hostRawURL + "/demo/attachments/b.png", hostRawURL + "/demo/attachments/c.txt"}
+	for i, u := range expectedURLs {
+		if ji.Attachments[i].URL != u {
+			t.Errorf("Wrong URL at %d, expected '%s', actual '%s'", i, u, ji.Attachments[i].URL)
+		}
+	}
+	svg, png, empty := ji.Attachments[0], ji.Attachments[1], ji.Attachments[2]
+	if svg.MIMEType != "image/svg+xml" || svg.Blom.Width != 0 {
+		t.Errorf("Wrong SVG attachment: %v %v", svg, svg.Blom)
+	}
+	expectedHash := fmt.Sprintf("%x", sha256.Sum256(photo))
+	if png.SizeInBytes != int64(len(photo)) || png.Blom.SHA256 != expectedHash {
+		t.Errorf("Wrong size or hash: %d, '%s'", png.SizeInBytes, png.Blom.SHA256)
+	}
+	if png.Blom.Width != 30 || png.Blom.Height != 10 {
+		t.Errorf("Wrong dimensions, expected 30x10, actual %dx%d", png.Blom.Width, png.Blom.Height)
+	}
+	if png.Title != "Sunset" || png.Blom.Caption != "From the pier" {
+		t.Errorf("Wrong sidecar data, title '%s', caption '%s'", png.Title, png.Blom.Caption)
+	}
+	if empty.SizeInBytes != 0 || empty.Blom.SHA256 != fmt.Sprintf("%x", sha256.Sum256(nil)) {
+		t.Errorf("Wrong empty attachment: %v %v", empty, empty.Blom)
+	}
+}
+
+func TestInitAttachmentsCorruptDuration(t *testing.T) {
+	articlePath := setupArticlePath(t)
+	defer teardownArticlePath(t, articlePath)
+	attachPath := filepath.Join(articlePath, attachmentDir)
+	err := os.Mkdir(attachPath, 0777)
+	if err != nil {
+		t.Fatalf("Error (%s) PRIOR TO RUNNING TEST.", err.Error())
+	}
+	files := map[string][]byte{
+		"a.m4a": fakeM4A(0, 100), //Zero timescale
+		"b.mp4": []byte("\x00\x00\x00\x10ftypisom\x00\x00\x02\x00\x00\x00\x00\x04moov"),
+	}
+	for name, content := range files {
+		err = ioutil.WriteFile(filepath.Join(attachPath, name), content, 0664)
+		if err != nil {
+			t.Fatalf("Error (%s) PRIOR TO RUNNING TEST.", err.Error())
+		}
+	}
+
+	var ji jsfItem
+	err = ji.initAttachments(articlePath, "demo", conf.Attachments.merge(nil))
+	if err != nil {
+		t.Fatalf("Error (%s) for attachments with unreadable durations.", err.Error())
+	}
+	expectedTypes := []string{"audio/mp4", "video/mp4"}
+	for i, ja := range ji.Attachments {
+		if ja.MIMEType != expectedTypes[i] || ja.DurationInSeconds != 0 {
+			t.Errorf("Wrong attachment at %d, expected %s with no duration, actual %s, %v", i, expectedTypes[i], ja.MIMEType, ja.DurationInSeconds)
+		}
+	}
+}
+
+func TestKeepAuthorMetadataCaption(t *testing.T) {
+	u := hostRawURL + "/demo/attachments/b.png"
+	prev := jsfItem{Attachments: []jsfAttachment{{URL: u, Title: "Old", Blom: &blomAttachment{Caption: "Kept"}}}}
+	res := jsfItem{Attachments: []jsfAttachment{{URL: u, Blom: &blomAttachment{SHA256: "abc"}}}}
+	res.keepAuthorMetadata(prev)
+	if res.Attachments[0].Title != "Old" || res.Attachments[0].Blom.Caption != "Kept" {
+		t.Errorf("Author metadata not kept: %v %v", res.Attachments[0], res.Attachments[0].Blom)
+	}
+}
+
+func TestAttachmentRulesMerge(t *testing.T) {
+	site := attachmentRules{Publish: []string{"*.jpg"}}
+	res := site.merge(nil)
+	if !reflect.DeepEqual(res.Exclude, defaultAttachmentExclude) || !reflect.DeepEqual(res.Publish, site.Publish) {
+		t.Errorf("Wrong site rules: %v", res)
+	}
+	site.Exclude = []string{"*.bak"}
+	res = site.merge(&attachmentRules{Include: []string{"photos/*"}, Exclude: []string{"raw/"}})
+	expected := attachmentRules{
+		Include: []string{"photos/*"},
+		Exclude: append(append([]string(nil), defaultAttachmentExclude...), "*.bak", "raw/"),
+		Publish: []string{"*.jpg"},
+	}
+	if !reflect.DeepEqual(res, expected) {
+		t.Errorf("Wrong merged rules, expected %v, actual %v", expected, res)
+	}
+}
+
+func setupNestedAttachments(t *testing.T) string {
+	articlePath := setupArticlePath(t)
+	for _, name := range []string{"cover.jpg", ".DS_Store", "notes.txt~", ".cover.jpg.swp", "photos/a.jpg", "photos/day 2/b.jpg", "photos/b.gpx", "raw/a.cr2"} {
+		p := filepath.Join(articlePath, attachmentDir, filepath.FromSlash(name))
+		err := os.MkdirAll(filepath.Dir(p), 0777)
+		if err != nil {
+			t.Errorf("Error (%s) PRIOR TO RUNNING TEST.", err.Error())
+		}
+		err = ioutil.WriteFile(p, jpegBytes, 0664)
+		if err != nil {
+			t.Errorf("Error (%s) PRIOR TO RUNNING TEST.", err.Error())
+		}
+	}
+	return articlePath
+}
+
+func TestGetAttachPathsRules(t *testing.T) {
+	articlePath := setupNestedAttachments(t)
+	defer teardownArticlePath(t, articlePath)
+	rules := attachmentRules{Exclude: []string{"raw/"}, Publish: []string{"*.jpg"}}.merge(nil)
+	res, err := getAttachPaths(articlePath, rules)
+	if err != nil {
+		t.Errorf("Error (%s) when all parameters valid.", err.Error())
+	}
+	attachPath := filepath.Join(articlePath, attachmentDir)
+	expected := map[string]bool{
+		filepath.Join(attachPath, "cover.jpg"):                true,
+		filepath.Join(attachPath, "photos", "a.jpg"):          true,
+		filepath.Join(attachPath, "photos", "day 2", "b.jpg"): true,
+		filepath.Join(attachPath, "photos", "b.gpx"):          false,
+	}
+	if !reflect.DeepEqual(res, expected) {
+		t.Errorf("Wrong attachment paths, expected %v, actual %v", expected, res)
+	}
+
+	res, err = getAttachPaths(articlePath, attachmentRules{Include: []string{"photos/*"}}.merge(nil))
+	if err != nil {
+		t.Errorf("Error (%s) when all parameters valid.", err.Error())
+	}
+	if len(res) != 2 || !res[filepath.Join(attachPath, "photos", "a.jpg")] || !res[filepath.Join(attachPath, "photos", "b.gpx")] {
+		t.Errorf("Wrong included paths: %v", res)
+	}
+}
+
+func TestInitAttachmentsNested(t *testing.T) {
+	articlePath := setupNestedAttachments(t)
+	defer teardownArticlePath(t, articlePath)
+	var ji jsfItem
+	err := ji.initAttachments(articlePath, "trip", attachmentRules{Exclude: []string{"raw/"}, Publish: []string{"*.jpg"}}.merge(nil))
+	if err != nil {
+		t.Errorf("Error (%s) when all parameters valid.", err.Error())
+	}
+	expected := []string{
+		hostRawURL + "/trip/attachments/cover.jpg",
+		hostRawURL + "/trip/attachments/photos/a.jpg",
+		hostRawURL + "/trip/attachments/photos/day%202/b.jpg",
+	}
+	if len(ji.Attachments) != len(expected) {
+		t.Fatalf("Wrong attachments, expected %v, actual %v", expected, ji.Attachments)
+	}
+	for i, u := range expected {
+		if ji.Attachments[i].URL != u {
+			t.Errorf("Wrong URL at %d, expected '%s', actual '%s'", i, u, ji.Attachments[i].URL)
+		}
+	}
+}
+
+func TestCopyAttachmentsNested(t *testing.T) {
+	articlePath := setupNestedAttachments(t)
+	defer teardownArticlePath(t, articlePath)
+	outputPath := setupArticlePath(t)
+	defer teardownArticlePath(t, outputPath)
+	_, err := copyAttachments(articlePath, outputPath, attachmentRules{Publish: []string{"*.jpg"}}.merge(nil))
+	if err != nil {
+		t.Errorf("Error (%s) when all parameters valid.", err.Error())
+	}
+	for _, name := range []string{"photos/day 2/b.jpg", "photos/b.gpx", "raw/a.cr2"} {
+		if _, err := os.Stat(filepath.Join(outputPath, attachmentDir, filepath.FromSlash(name))); err != nil {
+			t.Errorf("Missing copy of '%s'", name)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(outputPath, attachmentDir, ".DS_Store")); err == nil {
+		t.Errorf("Excluded file was copied")
+	}
+}
@@ -0,0 +1,265 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"path/filepath"
+	"strings"
+)
+
+const mp3SyncSearchBytes = 64 * 1024
+const maxWAVChunks = 64 //Real files have a handful, give up on anything else
+
+var mp3Bitrates = map[bool][]int{ //Layer III, keyed by MPEG-1 or not
+	true:  {0, 32, 40, 48, 56, 64, 80, 96, 112, 128, 160, 192, 224, 256, 320},
+	false: {0, 8, 16, 24, 32, 40, 48, 56, 64, 80, 96, 112, 128, 144, 160},
+}
+
+var mp3SampleRates = []int{44100, 48000, 32000}
+
+type mp3Frame struct {
+	mpeg1       bool
+	mono        bool
+	bitrate     int //kbit/s
+	sampleRate  int
+	samples     int //Per frame
+	headerStart int64
+}
+
+func parseMP3Header(b []byte) (mp3Frame, bool) {
+	var res mp3Frame
+	if len(b) < 4 || b[0] != 0xFF || b[1]&0xE0 != 0xE0 {
+		return res, false
+	}
+	version := (b[1] >> 3) & 3
+	layer := (b[1] >> 1) & 3
+	bitrateIndex := int(b[2] >> 4)
+	sampleRateIndex := int((b[2] >> 2) & 3)
+	if version == 1 || layer != 1 || bitrateIndex == 0 || bitrateIndex == 15 || sampleRateIndex == 3 {
+		return res, false //Reserved values, free format or not Layer III
+	}
+	res.mpeg1 = version == 3
+	res.mono = b[3]>>6 == 3
+	res.bitrate = mp3Bitrates[res.mpeg1][bitrateIndex]
+	res.sampleRate = mp3SampleRates[sampleRateIndex]
+	res.samples = 1152
+	if !res.mpeg1 {
+		res.samples = 576
+		res.sampleRate /= 2
+		if version == 0 {
+			res.sampleRate /= 2 //MPEG-2.5
+		}
+	}
+	return res, true
+}
+
+func id3v2Size(header []byte) int64 {
+	if len(header) < 10 || string(header[:3]) != "ID3" {
+		return 0
+	}
+	size := int64(header[6])<<21 | int64(header[7])<<14 | int64(header[8])<<7 | int64(header[9])
+	if header[5]&0x10 != 0 {
+		size += 10 //Footer
+	}
+	return 10 + size
+}
+
+func mp3VBRFrames(frame []byte, f mp3Frame) (int64, bool) {
+	sideInfo := 17
+	if f.mpeg1 && !f.mono {
+		sideInfo = 32
+	} else if !f.mpeg1 && f.mono {
+		sideInfo = 9
+	}
+	xing := 4 + sideInfo
+	if len(frame) >= xing+12 {
+		tag := string(frame[xing : xing+4])
+		flags := binary.BigEndian.Uint32(frame[xing+4 : xing+8])
+		if (tag == "Xing" || tag == "Info") && flags&1 != 0 {
+			return int64(binary.BigEndian.Uint32(frame[xing+8 : xing+12])), true
+		}
+	}
+	const vbri = 4 + 32
+	if len(frame) >= vbri+18 && string(frame[vbri:vbri+4]) == "VBRI" {
+		return int64(binary.BigEndian.Uint32(frame[vbri+14 : vbri+18])), true
+	}
+	return 0, false
+}
+
+func mp3Duration(r io.ReadSeeker, size int64) (float64, error) {
+	header := make([]byte, 10)
+	_, err := io.ReadFull(r, header)
+	if err != nil {
+		return 0, err
+	}
+	start := id3v2Size(header)
+	_, err = r.Seek(start, io.SeekStart)
+	if err != nil {
+		return 0, err
+	}
+	b := make([]byte, mp3SyncSearchBytes)
+	n, err := io.ReadFull(r, b)
+	if err != nil && err != io.ErrUnexpectedEOF {
+		return 0, err
+	}
+	b = b[:n]
+	for i := 0; i+4 <= len(b); i++ {
+		frame, ok := parseMP3Header(b[i:])
+		if !ok {
+			continue
+		}
+		if frames, ok := mp3VBRFrames(b[i:], frame); ok {
+			return float64(frames) * float64(frame.samples) / float64(frame.sampleRate), nil
+		}
+		audioBytes := size - start - int64(i) //Constant bitrate
+		return float64(audioBytes) * 8 / float64(frame.bitrate*1000), nil
+	}
+	return 0, errors.New("no MP3 frame found")
+}
+
+func mp4Duration(r io.ReadSeeker, size int64) (float64, error) {
+	end := size
+	offset := int64(0)
+	header := make([]byte, 8)
+	for offset+8 <= end {
+		_, err := r.Seek(offset, io.SeekStart)
+		if err != nil {
+			return 0, err
+		}
+		_, err = io.ReadFull(r, header)
+		if err != nil {
+			return 0, err
+		}
+		boxSize := int64(binary.BigEndian.Uint32(header[:4]))
+		boxType := string(header[4:])
+		headerLen := int64(8)
+		if boxSize == 1 {
+			_, err = io.ReadFull(r, header)
+			if err != nil {
+				return 0, err
+			}
+			boxSize = int64(binary.BigEndian.Uint64(header))
+			headerLen = 16
+		} else if boxSize == 0 {
+			boxSize = end - offset
+		}
+		if boxSize < headerLen {
+			return 0, fmt.Errorf("bad MP4 box size at offset %d", offset)
+		}
+		switch boxType {
+		case "moov":
+			end = offset + boxSize //Descend
+			offset += headerLen
+			continue
+		case "mvhd":
+			body := make([]byte, 32)
+			_, err = io.ReadFull(r, body)
+			if err != nil {
+				return 0, err
+			}
+			var timescale uint32
+			var duration uint64
+			if body[0] == 1 {
+				timescale = binary.BigEndian.Uint32(body[20:24])
+				duration = binary.BigEndian.Uint64(body[24:32])
+			} else {
+				timescale = binary.BigEndian.Uint32(body[12:16])
+				duration = uint64(binary.BigEndian.Uint32(body[16:20]))
+			}
+			if timescale == 0 {
+				return 0, errors.New("MP4 timescale is zero")
+			}
+			return float64(duration) / float64(timescale), nil
+		}
+		offset += boxSize
+	}
+	return 0, errors.New("no MP4 movie header found")
+}
+
+func wavDuration(r io.ReadSeeker) (float64, error) {
+	_, err := r.Seek(12, io.SeekStart)
+	if err != nil {
+		return 0, err
+	}
+	var byteRate uint32
+	header := make([]byte, 8)
+	for i := 0; i < maxWAVChunks; i++ {
+		_, err = io.ReadFull(r, header)
+		if err != nil {
+			return 0, errors.New("no WAV data chunk found")
+		}
+		chunkSize := int64(binary.LittleEndian.Uint32(header[4:]))
+		switch string(header[:4]) {
+		case "fmt ":
+			if chunkSize < 12 {
+				return 0, errors.New("WAV format chunk too short")
+			}
+			body := make([]byte, 12)
+			_, err = io.ReadFull(r, body)
+			if err != nil {
+				return 0, err
+			}
+			byteRate = binary.LittleEndian.Uint32(body[8:12])
+			chunkSize -= 12
+		case "data":
+			if byteRate == 0 {
+				return 0, errors.New("WAV data chunk before format chunk")
+			}
+			return float64(chunkSize) / float64(byteRate), nil
+		}
+		_, err = r.Seek(chunkSize+chunkSize%2, io.SeekCurrent) //Chunks are padded to an even size
+		if err != nil {
+			return 0, err
+		}
+	}
+	return 0, errors.New("no WAV data chunk found")
+}
+
+func audioInfo(name string, r io.ReadSeeker, size int64) (string, float64, error) {
+	magic := make([]byte, 12)
+	_, err := io.ReadFull(r, magic)
+	if err == io.ErrUnexpectedEOF || err == io.EOF {
+		return "", 0, nil
+	} else if err != nil {
+		return "", 0, err
+	}
+	_, err = r.Seek(0, io.SeekStart)
+	if err != nil {
+		return "", 0, err
+	}
+
+	var mimeType string
+	var duration float64
+	ext := strings.ToLower(filepath.Ext(name))
+	switch {
+	case bytes.HasPrefix(magic, []byte("ID3")):
+		mimeType = "audio/mpeg"
+		duration, err = mp3Duration(r, size)
+	case ext == ".mp3":
+		if _, ok := parseMP3Header(magic); !ok {
+			return "", 0, nil
+		}
+		mimeType = "audio/mpeg"
+		duration, err = mp3Duration(r, size)
+	case string(magic[4:8]) == "ftyp":
+		brand := string(magic[8:12])
+		if brand != "M4A " && brand != "M4B " && ext != ".m4a" && ext != ".m4b" {
+			return "", 0, nil //Probably video
+		}
+		mimeType = "audio/mp4"
+		duration, err = mp4Duration(r, size)
+	case string(magic[:4]) == "RIFF" && string(magic[8:12]) == "WAVE":
+		mimeType = "audio/wav"
+		duration, err = wavDuration(r)
+	default:
+		return "", 0, nil
+	}
+	if err != nil {
+		return mimeType, 0, fmt.Errorf("reading duration of '%s': %s", name, err.Error())
+	}
+	return mimeType, math.Round(duration*1000) / 1000, nil
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+)
+
+func fakeMP3(xingFrames uint32, audioBytes int) []byte {
+	var b bytes.Buffer
+	b.Write([]byte{'I', 'D', '3', 3, 0, 0, 0, 0, 0, 0}) //Empty ID3v2 tag
+	frame := make([]byte, audioBytes)
+	copy(frame, []byte{0xFF, 0xFB, 0x90, 0x64}) //MPEG-1 Layer III, 128 kbit/s, 44100 Hz, joint stereo
+	if xingFrames > 0 {
+		copy(frame[36:], "Xing")
+		binary.BigEndian.PutUint32(frame[40:], 1)
+		binary.BigEndian.PutUint32(frame[44:], xingFrames)
+	}
+	b.Write(frame)
+	return b.Bytes()
+}
+
+func fakeWAV(byteRate, dataBytes uint32) []byte {
+	var b bytes.Buffer
+	b.WriteString("RIFF")
+	binary.Write(&b, binary.LittleEndian, uint32(36+dataBytes))
+	b.WriteString("WAVEfmt ")
+	binary.Write(&b, binary.LittleEndian, []uint32{16, 0x00010001, 44100, byteRate, 0x00100002})
+	b.WriteString("data")
+	binary.Write(&b, binary.LittleEndian, dataBytes)
+	return b.Bytes()
+}
+
+func fakeM4A(timescale, duration uint32) []byte {
+	var b bytes.Buffer
+	b.Write([]byte{0, 0, 0, 16})
+	b.WriteString("ftypM4A ")
+	b.Write([]byte{0, 0, 0, 0})
+	b.Write([]byte{0, 0, 0, 8 + 8 + 100})
+	b.WriteString("moov")
+	b.Write([]byte{0, 0, 0, 8 + 100})
+	b.WriteString("mvhd")
+	body := make([]byte, 100)
+	binary.BigEndian.PutUint32(body[12:], timescale)
+	binary.BigEndian.PutUint32(body[16:], duration)
+	b.Write(body)
+	return b.Bytes()
+}
+
+var audioInfoTests = []struct {
+	name     string
+	content  []byte
+	mimeType string
+	duration float64
+}{
+	{"cbr.mp3", fakeMP3(0, 16000), "audio/mpeg", 1},
+	{"vbr.mp3", fakeMP3(100, 417), "audio/mpeg", 2.612},
+	{"raw.mp3", fakeMP3(0, 16010)[10:], "audio/mpeg", 1.001},
+	{"a.wav", fakeWAV(88200, 176400), "audio/wav", 2},
+	{"a.m4a", fakeM4A(1000, 90500), "audio/mp4", 90.5},
+	{"a.txt", []byte("Just some text, not audio at all"), "", 0},
+	{"tiny.mp3", []byte("ab"), "", 0},
+}
+
+func TestAudioInfo(t *testing.T) {
+	for _, test := range audioInfoTests {
+		mimeType, duration, err := audioInfo(test.name, bytes.NewReader(test.content), int64(len(test.content)))
+		if err != nil {
+			t.Errorf("Error (%s) for '%s' when all parameters valid.", err.Error(), test.name)
+		}
+		if mimeType != test.mimeType || duration != test.duration {
+			t.Errorf("Wrong info for '%s', expected (%s, %v), actual (%s, %v)", test.name, test.mimeType, test.duration, mimeType, duration)
+		}
+	}
+}
+
+func TestAudioInfoCorrupt(t *testing.T) {
+	content := fakeM4A(0, 100)
+	_, _, err := audioInfo("zero.m4a", bytes.NewReader(content), int64(len(content)))
+	if err == nil {
+		t.Errorf("No error for zero MP4 timescale.")
+	}
+
+	content = fakeWAV(88200, 176400)
+	binary.LittleEndian.PutUint32(content[16:], 4) //Shorter than the fields that get read
+	_, _, err = audioInfo("short.wav", bytes.NewReader(content), int64(len(content)))
+	if err == nil {
+		t.Errorf("No error for short WAV format chunk.")
+	}
+
+	var b bytes.Buffer
+	b.Write(fakeWAV(88200, 176400)[:12])
+	for i := 0; i < maxWAVChunks+1; i++ {
+		b.WriteString("junk")
+		binary.Write(&b, binary.LittleEndian, uint32(0))
+	}
+	content = b.Bytes()
+	_, _, err = audioInfo("junk.wav", bytes.NewReader(content), int64(len(content)))
+	if err == nil {
+		t.Errorf("No error for WAV without data chunk.")
+	}
+}
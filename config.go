@@ -0,0 +1,228 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+const ignoreFile = ".blomignore"
+
+type sectionConfig struct {
+	Path      string `json:"path"`
+	Title     string `json:"title"`
+	Template  string `json:"template"`
+	Permalink string `json:"permalink"`
+	tmpl      *template.Template
+}
+
+type podcastConfig struct {
+	Title       string `json:"title"`
+	Description string `json:"description"`
+	Author      string `json:"author"`
+	OwnerName   string `json:"owner_name"`
+	OwnerEmail  string `json:"owner_email"`
+	Image       string `json:"image"`
+	Category    string `json:"category"`
+	Subcategory string `json:"subcategory"`
+	Explicit    bool   `json:"explicit"`
+	Type        string `json:"type"` //episodic or serial
+	Copyright   string `json:"copyright"`
+	GUID        string `json:"guid"`
+	Locked      bool   `json:"locked"`
+}
+
+type blogConfig struct {
+	Sections       []sectionConfig  `json:"sections"`
+	Permalink      string           `json:"permalink"`
+	SlugFrom       string           `json:"slug_from"`
+	RedirectFormat string           `json:"redirect_format"`
+	IDScheme       string           `json:"id_scheme"`
+	SummaryWords   int              `json:"summary_words"`
+	FeedContent    string           `json:"feed_content"`
+	Description    string           `json:"description"`
+	UserComment    string           `json:"user_comment"`
+	Icon           string           `json:"icon"`
+	Favicon        string           `json:"favicon"`
+	Authors        []jsfAuthor      `json:"authors"`
+	Language       string           `json:"language"`
+	Expired        bool             `json:"expired"`
+	FeedPageItems  int              `json:"feed_page_items"` //Negative for no limit
+	FeedPageBytes  int              `json:"feed_page_bytes"`
+	Podcast        *podcastConfig   `json:"podcast"` //No podcast feed if unset
+	TodayMode      string           `json:"today_mode"`
+	Highlight      *highlightConfig `json:"highlight"` //No syntax highlighting if unset
+	Markdown       markdownConfig   `json:"markdown"`
+	TOCDepth       int              `json:"toc_depth"`
+	Sanitize       *sanitizeConfig  `json:"sanitize"` //No sanitising if unset
+	Images         *imageConfig     `json:"images"`   //No image variants if unset
+	StripMetadata  bool             `json:"strip_metadata"`
+	Attachments    attachmentRules  `json:"attachments"`
+	Gemini         *geminiConfig    `json:"gemini"` //No Gemini capsule if unset
+	Gopher         gopherConfig     `json:"gopher"`
+	EPUB           epubConfig       `json:"epub"`
+	Text           textConfig       `json:"text"`
+}
+
+var conf blogConfig //The zero value behaves like blom did before config files existed
+
+func loadConfig(configPath string) (blogConfig, error) {
+	var res blogConfig
+	fileContent, err := ioutil.ReadFile(configPath)
+	if os.IsNotExist(err) {
+		return res, nil
+	} else if err != nil {
+		return res, err
+	}
+	err = json.Unmarshal(fileContent, &res)
+	if err != nil {
+		return res, err
+	}
+
+	if !validTodayMode(res.TodayMode) {
+		return res, fmt.Errorf("unsupported today mode '%s'", res.TodayMode)
+	}
+	_, err = res.Markdown.renderer()
+	if err != nil {
+		return res, err
+	}
+	if res.Highlight != nil {
+		err = res.Highlight.validate()
+		if err != nil {
+			return res, err
+		}
+		if res.Sanitize != nil && len(res.Highlight.CSSFile) < 1 {
+			return res, fmt.Errorf("highlight needs a css_file when sanitize is set, inline styles are removed")
+		}
+	}
+	if res.Images != nil {
+		err = res.Images.validate()
+		if err != nil {
+			return res, err
+		}
+	}
+	if res.Podcast != nil {
+		err = res.Podcast.validate()
+		if err != nil {
+			return res, err
+		}
+	}
+	if res.Gemini != nil {
+		err = res.Gemini.validate()
+		if err != nil {
+			return res, err
+		}
+	}
+	err = res.Gopher.validate()
+	if err != nil {
+		return res, err
+	}
+	err = res.EPUB.validate()
+	if err != nil {
+		return res, err
+	}
+	err = res.Text.validate()
+	if err != nil {
+		return res, err
+	}
+	if res.StripMetadata && !publishesElsewhere(res.Permalink) {
+		return res, fmt.Errorf("strip_metadata needs a permalink that publishes articles outside their source directories, or the originals are published too")
+	}
+	for _, sc := range res.Sections {
+		if res.StripMetadata && len(sc.Permalink) > 0 && !publishesElsewhere(sc.Permalink) {
+			return res, fmt.Errorf("strip_metadata needs the permalink of section '%s' to publish articles outside their source directories", sc.Path)
+		}
+	}
+
+	configDir := filepath.Dir(configPath)
+	res.EPUB.coverPath = res.EPUB.Cover
+	if len(res.EPUB.Cover) > 0 && !filepath.IsAbs(res.EPUB.Cover) {
+		res.EPUB.coverPath = filepath.Join(configDir, res.EPUB.Cover)
+	}
+	for i, sc := range res.Sections {
+		res.Sections[i].Path = strings.Trim(path.Clean("/"+filepath.ToSlash(sc.Path)), "/")
+		if len(sc.Title) < 1 {
+			res.Sections[i].Title = res.Sections[i].Path
+		}
+		if len(sc.Template) < 1 {
+			continue
+		}
+		templatePath := sc.Template
+		if !filepath.IsAbs(templatePath) {
+			templatePath = filepath.Join(configDir, templatePath)
+		}
+		res.Sections[i].tmpl, err = template.ParseFiles(templatePath)
+		if err != nil {
+			return res, err
+		}
+	}
+	return res, nil
+}
+
+func inSection(sectionPath, articleURLPath string) bool {
+	return strings.HasPrefix(articleURLPath+"/", sectionPath+"/")
+}
+
+func (bc blogConfig) sectionFor(articleURLPath string) (sectionConfig, bool) {
+	var res sectionConfig
+	found := false
+	for _, sc := range bc.Sections {
+		//The deepest section wins, so 'notes/2020/foo' can belong to 'notes/2020' rather than 'notes'
+		if inSection(sc.Path, articleURLPath) && len(sc.Path) >= len(res.Path) {
+			res = sc
+			found = true
+		}
+	}
+	return res, found
+}
+
+func (bc blogConfig) templateFor(articleURLPath string, fallback *template.Template) *template.Template {
+	if sc, ok := bc.sectionFor(articleURLPath); ok && sc.tmpl != nil {
+		return sc.tmpl
+	}
+	return fallback
+}
+
+func readIgnorePatterns(blogPath string) ([]string, error) {
+	fileContent, err := ioutil.ReadFile(filepath.Join(blogPath, ignoreFile))
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	patterns := make([]string, 0)
+	for _, line := range strings.Split(string(fileContent), "\n") {
+		line = strings.TrimSpace(line)
+		if len(line) < 1 || strings.HasPrefix(line, "#") {
+			continue
+		}
+		patterns = append(patterns, line)
+	}
+	return patterns, nil
+}
+
+func matchesPattern(patterns []string, relPath string, isDir bool) bool {
+	relPath = filepath.ToSlash(relPath)
+	for _, pattern := range patterns {
+		//Like .gitignore: a trailing slash only matches directories, any other slash anchors the pattern to the top directory
+		if strings.HasSuffix(pattern, "/") {
+			if !isDir {
+				continue
+			}
+			pattern = strings.TrimSuffix(pattern, "/")
+		}
+		if strings.Contains(pattern, "/") {
+			if ok, _ := path.Match(strings.TrimPrefix(pattern, "/"), relPath); ok {
+				return true
+			}
+		} else if ok, _ := path.Match(pattern, path.Base(relPath)); ok {
+			return true
+		}
+	}
+	return false
+}
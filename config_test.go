@@ -0,0 +1,162 @@
+package main
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+)
+
+func TestLoadConfigNonexistent(t *testing.T) {
+	res, err := loadConfig(filepath.Join("nonexistent", "blom.json"))
+	if err != nil {
+		t.Errorf("Error (%s) for missing config file.", err.Error())
+	}
+	if len(res.Sections) > 0 {
+		t.Errorf("Sections %v when no config file.", res.Sections)
+	}
+}
+
+func TestLoadConfig(t *testing.T) {
+	configDir := setupArticlePath(t)
+	err := ioutil.WriteFile(filepath.Join(configDir, "notes.html"), []byte("{{.Title}}"), 0664)
+	if err != nil {
+		t.Errorf("Error (%s) PRIOR TO RUNNING TEST.", err.Error())
+	}
+	configContent := `{"sections": [{"path": "/notes/", "template": "notes.html"}, {"path": "2020", "title": "Year 2020"}], "epub": {"cover": "cover.png"}}`
+	configPath := filepath.Join(configDir, "blom.json")
+	err = ioutil.WriteFile(configPath, []byte(configContent), 0664)
+	if err != nil {
+		t.Errorf("Error (%s) PRIOR TO RUNNING TEST.", err.Error())
+	}
+
+	res, err := loadConfig(configPath)
+	if err != nil {
+		t.Errorf("Error (%s) for valid config file.", err.Error())
+	}
+	if len(res.Sections) != 2 {
+		t.Fatalf("Wrong section count, expected 2, actual %v", len(res.Sections))
+	}
+	if res.Sections[0].Path != "notes" || res.Sections[0].Title != "notes" || res.Sections[0].tmpl == nil {
+		t.Errorf("Wrong first section: %v", res.Sections[0])
+	}
+	if res.Sections[1].Path != "2020" || res.Sections[1].Title != "Year 2020" || res.Sections[1].tmpl != nil {
+		t.Errorf("Wrong second section: %v", res.Sections[1])
+	}
+	if res.EPUB.coverPath != filepath.Join(configDir, "cover.png") {
+		t.Errorf("EPUB cover not relative to config file: '%s'", res.EPUB.coverPath)
+	}
+	teardownArticlePath(t, configDir)
+}
+
+func TestLoadConfigTodayMode(t *testing.T) {
+	configDir := setupArticlePath(t)
+	configPath := filepath.Join(configDir, "blom.json")
+	err := ioutil.WriteFile(configPath, []byte(`{"today_mode": "telepathy"}`), 0664)
+	if err != nil {
+		t.Errorf("Error (%s) PRIOR TO RUNNING TEST.", err.Error())
+	}
+	_, err = loadConfig(configPath)
+	if err == nil {
+		t.Errorf("No error for unsupported today mode.")
+	}
+	teardownArticlePath(t, configDir)
+}
+
+func TestLoadConfigInlineHighlight(t *testing.T) {
+	configDir := setupArticlePath(t)
+	defer teardownArticlePath(t, configDir)
+	configPath := filepath.Join(configDir, "blom.json")
+	err := ioutil.WriteFile(configPath, []byte(`{"sanitize": {}, "highlight": {}}`), 0664)
+	if err != nil {
+		t.Fatalf("Error (%s) PRIOR TO RUNNING TEST.", err.Error())
+	}
+	_, err = loadConfig(configPath)
+	if err == nil {
+		t.Errorf("No error for inline highlighting with sanitising.")
+	}
+
+	err = ioutil.WriteFile(configPath, []byte(`{"sanitize": {}, "highlight": {"css_file": "css/code.css"}}`), 0664)
+	if err != nil {
+		t.Fatalf("Error (%s) PRIOR TO RUNNING TEST.", err.Error())
+	}
+	_, err = loadConfig(configPath)
+	if err != nil {
+		t.Errorf("Error (%s) when all parameters valid.", err.Error())
+	}
+}
+
+var loadConfigStripTests = []struct {
+	config string
+	valid  bool
+}{
+	{`{"strip_metadata": true}`, false},
+	{`{"strip_metadata": true, "permalink": "/:path/"}`, false},
+	{`{"strip_metadata": true, "permalink": "/:slug/"}`, true},
+	{`{"strip_metadata": true, "permalink": "/posts/:path/", "sections": [{"path": "notes", "permalink": ":path"}]}`, false},
+	{`{"strip_metadata": true, "permalink": "/posts/:path/", "sections": [{"path": "notes"}]}`, true},
+	{`{"permalink": ":path"}`, true},
+}
+
+func TestLoadConfigStripMetadata(t *testing.T) {
+	configDir := setupArticlePath(t)
+	defer teardownArticlePath(t, configDir)
+	configPath := filepath.Join(configDir, "blom.json")
+	for _, test := range loadConfigStripTests {
+		err := ioutil.WriteFile(configPath, []byte(test.config), 0664)
+		if err != nil {
+			t.Fatalf("Error (%s) PRIOR TO RUNNING TEST.", err.Error())
+		}
+		_, err = loadConfig(configPath)
+		if test.valid && err != nil {
+			t.Errorf("Error (%s) for valid config %s", err.Error(), test.config)
+		} else if !test.valid && err == nil {
+			t.Errorf("No error for invalid config %s", test.config)
+		}
+	}
+}
+
+func TestSectionFor(t *testing.T) {
+	bc := blogConfig{Sections: []sectionConfig{{Path: "notes"}, {Path: "notes/2020"}, {Path: "trips"}}}
+	sectionTests := []struct {
+		articleURLPath string
+		found          bool
+		sectionPath    string
+	}{
+		{"notes/foo", true, "notes"},
+		{"notes/2020/foo", true, "notes/2020"},
+		{"notesy/foo", false, ""},
+		{"trips/2019/rome", true, "trips"},
+		{"hello", false, ""},
+	}
+	for _, s := range sectionTests {
+		sc, found := bc.sectionFor(s.articleURLPath)
+		if found != s.found || sc.Path != s.sectionPath {
+			t.Errorf("Wrong section for '%s', expected (%v, '%s'), actual (%v, '%s')", s.articleURLPath, s.found, s.sectionPath, found, sc.Path)
+		}
+	}
+}
+
+var isIgnoredTests = []struct {
+	relPath string
+	isDir   bool
+	ignored bool
+}{
+	{"drafts", true, true},
+	{"notes/drafts", true, true},
+	{"drafts", false, false},
+	{"assets", true, true},
+	{"notes/old-post", true, true},
+	{"old-post", true, false},
+	{"notes/new-post", true, false},
+	{"backup.bak", false, true},
+}
+
+func TestMatchesPattern(t *testing.T) {
+	patterns := []string{"drafts/", "assets/", "/notes/old-*", "*.bak"}
+	for _, s := range isIgnoredTests {
+		ignored := matchesPattern(patterns, s.relPath, s.isDir)
+		if ignored != s.ignored {
+			t.Errorf("Wrong ignore status for '%s', expected %v, actual %v", s.relPath, s.ignored, ignored)
+		}
+	}
+}
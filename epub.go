@@ -0,0 +1,445 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"crypto/sha256"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+	"io/ioutil"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+const epubFormat = "epub"
+const epubMIMEType = "application/epub+zip"
+const opfNS = "http://www.idpf.org/2007/opf"
+const dcNSEPUB = "http://purl.org/dc/elements/1.1/"
+const epubContentDir = "OEBPS"
+const epubImageDir = "images"
+const epubNavFile = "nav.xhtml"
+const epubCoverFile = "cover.xhtml"
+const defaultEPUBLanguage = "en"
+
+const epubContainer = `<?xml version="1.0" encoding="UTF-8"?>
+<container version="1.0" xmlns="urn:oasis:names:tc:opendocument:xmlns:container">
+	<rootfiles>
+		<rootfile full-path="` + epubContentDir + `/content.opf" media-type="application/oebps-package+xml"/>
+	</rootfiles>
+</container>
+`
+
+var epubImageTypes = map[string]bool{"image/gif": true, "image/jpeg": true, "image/png": true, "image/svg+xml": true, "image/webp": true}
+
+type epubConfig struct {
+	Title       string `json:"title"`       //Defaults to the blog title, and the tag if there is one
+	Description string `json:"description"` //Defaults to the blog description
+	Publisher   string `json:"publisher"`
+	Rights      string `json:"rights"`
+	Cover       string `json:"cover"`      //Image file, relative to the config file
+	Identifier  string `json:"identifier"` //Made from the article IDs if unset
+	coverPath   string
+}
+
+type opfIdentifier struct {
+	ID    string `xml:"id,attr"`
+	Value string `xml:",chardata"`
+}
+
+type opfMeta struct {
+	Property string `xml:"property,attr,omitempty"`
+	Name     string `xml:"name,attr,omitempty"`
+	Content  string `xml:"content,attr,omitempty"`
+	Value    string `xml:",chardata"`
+}
+
+type opfMetadata struct {
+	DCNS        string        `xml:"xmlns:dc,attr"`
+	Identifier  opfIdentifier `xml:"dc:identifier"`
+	Title       string        `xml:"dc:title"`
+	Language    string        `xml:"dc:language"`
+	Creators    []string      `xml:"dc:creator"`
+	Description string        `xml:"dc:description,omitempty"`
+	Publisher   string        `xml:"dc:publisher,omitempty"`
+	Rights      string        `xml:"dc:rights,omitempty"`
+	Date        string        `xml:"dc:date,omitempty"`
+	Meta        []opfMeta     `xml:"meta"`
+}
+
+type opfItem struct {
+	ID         string `xml:"id,attr"`
+	Href       string `xml:"href,attr"`
+	MediaType  string `xml:"media-type,attr"`
+	Properties string `xml:"properties,attr,omitempty"`
+}
+
+type opfItemRef struct {
+	IDRef string `xml:"idref,attr"`
+}
+
+type opfPackage struct {
+	XMLName  xml.Name     `xml:"package"`
+	Xmlns    string       `xml:"xmlns,attr"`
+	Version  string       `xml:"version,attr"`
+	UniqueID string       `xml:"unique-identifier,attr"`
+	Lang     string       `xml:"xml:lang,attr"`
+	Metadata opfMetadata  `xml:"metadata"`
+	Manifest []opfItem    `xml:"manifest>item"`
+	Spine    []opfItemRef `xml:"spine>itemref"`
+}
+
+type epubFile struct {
+	name    string //Relative to epubContentDir
+	content []byte
+}
+
+type epubBook struct {
+	blogPath string
+	files    []epubFile
+	manifest []opfItem
+	spine    []opfItemRef
+	images   map[string]string //Local path to the name in the book
+	chapters map[string]string //Article URL to the name in the book
+}
+
+func (ec epubConfig) validate() error {
+	if len(ec.Cover) > 0 && !epubImageTypes[attachmentMIMEType(ec.Cover, "")] {
+		return fmt.Errorf("unsupported EPUB cover '%s', use GIF, JPEG, PNG, SVG or WebP", ec.Cover)
+	}
+	return nil
+}
+
+func (ec epubConfig) title(filter exportFilter) string {
+	if len(ec.Title) > 0 {
+		return ec.Title
+	}
+	if len(filter.tag) > 0 {
+		return blogTitle + ": " + strings.Title(filter.tag)
+	}
+	return blogTitle
+}
+
+func (ec epubConfig) identifier(itemList []jsfItem) string {
+	if len(ec.Identifier) > 0 {
+		return ec.Identifier
+	}
+	h := sha256.New()
+	for _, ji := range itemList {
+		h.Write([]byte(ji.ID + "\n"))
+	}
+	b := h.Sum(nil)
+	b[6] = (b[6] & 0x0f) | 0x50 //Version 5, name based, so the same articles give the same book
+	b[8] = (b[8] & 0x3f) | 0x80
+	return fmt.Sprintf("urn:uuid:%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:16])
+}
+
+func epubLanguage() string {
+	if len(conf.Language) > 0 {
+		return conf.Language
+	}
+	return defaultEPUBLanguage
+}
+
+func (eb *epubBook) add(id, name, mediaType, properties string, content []byte, inSpine bool) {
+	eb.files = append(eb.files, epubFile{name, content})
+	eb.manifest = append(eb.manifest, opfItem{id, name, mediaType, properties})
+	if inSpine {
+		eb.spine = append(eb.spine, opfItemRef{id})
+	}
+}
+
+func (eb *epubBook) localFile(src string) (string, bool) {
+	localPath, ok := publishedFile(eb.blogPath, src)
+	if !ok {
+		return "", false
+	}
+	return localPath, epubImageTypes[attachmentMIMEType(localPath, "")]
+}
+
+func (eb *epubBook) addImage(localPath string) (string, error) {
+	if name, ok := eb.images[localPath]; ok {
+		return name, nil
+	}
+	content, err := ioutil.ReadFile(localPath)
+	if err != nil {
+		return "", err
+	}
+	id := fmt.Sprintf("image-%d", len(eb.images)+1)
+	name := path.Join(epubImageDir, id+strings.ToLower(filepath.Ext(localPath)))
+	eb.add(id, name, attachmentMIMEType(localPath, ""), "", content, false)
+	eb.images[localPath] = name
+	return name, nil
+}
+
+func setAttr(n *html.Node, key, val string) {
+	for i, attr := range n.Attr {
+		if attr.Key == key {
+			n.Attr[i].Val = val
+			return
+		}
+	}
+	n.Attr = append(n.Attr, html.Attribute{Key: key, Val: val})
+}
+
+func removeAttrs(n *html.Node, keys ...string) {
+	attrs := n.Attr[:0]
+	for _, attr := range n.Attr {
+		keep := true
+		for _, key := range keys {
+			keep = keep && attr.Key != key
+		}
+		if keep {
+			attrs = append(attrs, attr)
+		}
+	}
+	n.Attr = attrs
+}
+
+func nodeAttr(n *html.Node, key string) string {
+	for _, attr := range n.Attr {
+		if attr.Key == key {
+			return attr.Val
+		}
+	}
+	return ""
+}
+
+func (eb *epubBook) rewriteNode(n *html.Node, embedded map[string]bool) error {
+	for c := n.FirstChild; c != nil; {
+		next := c.NextSibling
+		if c.Type == html.ElementNode && dropContentTags[c.Data] {
+			n.RemoveChild(c) //Readers don't have to run scripts or show frames
+			c = next
+			continue
+		}
+		err := eb.rewriteNode(c, embedded)
+		if err != nil {
+			return err
+		}
+		c = next
+	}
+	if n.Type != html.ElementNode {
+		return nil
+	}
+	switch n.DataAtom {
+	case atom.A:
+		parts := strings.SplitN(nodeAttr(n, "href"), "#", 2)
+		if name, ok := eb.chapters[strings.TrimSuffix(parts[0], "/")]; ok {
+			if len(parts) > 1 {
+				name += "#" + parts[1] //Heading IDs are kept in the chapters
+			}
+			setAttr(n, "href", name) //Other chapters, rather than the web site
+		}
+	case atom.Img:
+		removeAttrs(n, "srcset", "sizes", "loading")
+		src := nodeAttr(n, "src")
+		localPath, ok := eb.localFile(src)
+		if !ok {
+			alt := nodeAttr(n, "alt")
+			if len(alt) < 1 {
+				alt = "Image"
+			}
+			n.Data, n.DataAtom = "a", atom.A //Books can't load images from the web
+			n.Attr = []html.Attribute{{Key: "href", Val: src}}
+			n.AppendChild(&html.Node{Type: html.TextNode, Data: alt})
+			return nil
+		}
+		name, err := eb.addImage(localPath)
+		if err != nil {
+			return err
+		}
+		setAttr(n, "src", name)
+		if len(nodeAttr(n, "alt")) < 1 {
+			setAttr(n, "alt", "") //Required in XHTML
+		}
+		embedded[localPath] = true
+	}
+	return nil
+}
+
+func (eb *epubBook) chapterContent(ji jsfItem) ([]byte, error) {
+	body := &html.Node{Type: html.ElementNode, Data: "body", DataAtom: atom.Body}
+	nodes, err := html.ParseFragment(strings.NewReader(ji.ContentHTML), body)
+	if err != nil {
+		return nil, err
+	}
+	for _, n := range nodes {
+		body.AppendChild(n)
+	}
+	embedded := make(map[string]bool)
+	err = eb.rewriteNode(body, embedded)
+	if err != nil {
+		return nil, err
+	}
+	var b bytes.Buffer
+	for n := body.FirstChild; n != nil; n = n.NextSibling {
+		err = html.Render(&b, n) //Well-formed, with void elements closed, as XHTML needs
+		if err != nil {
+			return nil, err
+		}
+	}
+	for _, ja := range ji.Attachments {
+		localPath, ok := eb.localFile(ja.URL)
+		if !ok || embedded[localPath] {
+			continue
+		}
+		name, err := eb.addImage(localPath)
+		if err != nil {
+			return nil, err
+		}
+		embedded[localPath] = true
+		fmt.Fprintf(&b, "\n<figure><img src=\"%s\" alt=\"%s\" />", name, html.EscapeString(ja.Title))
+		if ja.Blom != nil && len(ja.Blom.Caption) > 0 {
+			fmt.Fprintf(&b, "<figcaption>%s</figcaption>", html.EscapeString(ja.Blom.Caption))
+		}
+		b.WriteString("</figure>")
+	}
+	return b.Bytes(), nil
+}
+
+func xhtmlPage(title, body string) []byte {
+	var b bytes.Buffer
+	b.WriteString(xml.Header)
+	b.WriteString("<!DOCTYPE html>\n")
+	fmt.Fprintf(&b, "<html xmlns=\"http://www.w3.org/1999/xhtml\" xmlns:epub=\"http://www.idpf.org/2007/ops\" lang=\"%[1]s\" xml:lang=\"%[1]s\">\n", html.EscapeString(epubLanguage()))
+	fmt.Fprintf(&b, "<head>\n<meta charset=\"UTF-8\" />\n<title>%s</title>\n</head>\n", html.EscapeString(title))
+	b.WriteString("<body>\n" + body + "\n</body>\n</html>\n")
+	return b.Bytes()
+}
+
+func epubChapter(ji jsfItem, content []byte) []byte {
+	published, _ := time.Parse(time.RFC3339, ji.DatePublished)
+	var b bytes.Buffer
+	b.WriteString("<section epub:type=\"chapter\">\n")
+	fmt.Fprintf(&b, "<h1>%s</h1>\n", html.EscapeString(ji.Title))
+	fmt.Fprintf(&b, "<p class=\"date\">%s</p>\n", dualDateStr(published))
+	b.Write(content)
+	b.WriteString("\n</section>")
+	return xhtmlPage(ji.Title, b.String())
+}
+
+func epubNav(title string, itemList []jsfItem, chapterNames []string) []byte {
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "<nav epub:type=\"toc\" id=\"toc\">\n<h1>%s</h1>\n<ol>\n", html.EscapeString(title))
+	for i, ji := range itemList {
+		fmt.Fprintf(&b, "<li><a href=\"%s\">%s</a></li>\n", chapterNames[i], html.EscapeString(ji.Title))
+	}
+	b.WriteString("</ol>\n</nav>")
+	return xhtmlPage(title, b.String())
+}
+
+func (eb *epubBook) opf(job exportJob, chapters []jsfItem) opfPackage {
+	ec := conf.EPUB
+	var pkg opfPackage
+	pkg.Xmlns = opfNS
+	pkg.Version = "3.0"
+	pkg.UniqueID = "book-id"
+	pkg.Lang = epubLanguage()
+	md := &pkg.Metadata
+	md.DCNS = dcNSEPUB
+	md.Identifier = opfIdentifier{"book-id", ec.identifier(chapters)}
+	md.Title = ec.title(job.filter)
+	md.Language = epubLanguage()
+	for _, author := range feedAuthors(rootScope) {
+		md.Creators = append(md.Creators, author.Name)
+	}
+	md.Description = ec.Description
+	if len(md.Description) < 1 {
+		md.Description = conf.Description
+	}
+	md.Publisher = ec.Publisher
+	md.Rights = ec.Rights
+	md.Date = chapters[len(chapters)-1].DatePublished
+	md.Meta = []opfMeta{{Property: "dcterms:modified", Value: newestModified(chapters).UTC().Format("2006-01-02T15:04:05Z")}}
+	if len(ec.coverPath) > 0 {
+		md.Meta = append(md.Meta, opfMeta{Name: "cover", Content: "cover-image"}) //For EPUB 2 readers
+	}
+	pkg.Manifest = eb.manifest
+	pkg.Spine = eb.spine
+	return pkg
+}
+
+func msDOSTime(t time.Time) (uint16, uint16) {
+	t = t.UTC()
+	if t.Year() < 1980 {
+		t = time.Date(1980, 1, 1, 0, 0, 0, 0, time.UTC) //The earliest MS-DOS date
+	}
+	return uint16(t.Hour()<<11 | t.Minute()<<5 | t.Second()/2), uint16((t.Year()-1980)<<9 | int(t.Month())<<5 | t.Day())
+}
+
+func (eb *epubBook) writeZip(outputPath string, pkg opfPackage) error {
+	opf, err := xml.MarshalIndent(pkg, "", "\t")
+	if err != nil {
+		return err
+	}
+	var b bytes.Buffer
+	zw := zip.NewWriter(&b)
+	files := append([]epubFile{{"../META-INF/container.xml", []byte(epubContainer)}, {"content.opf", append([]byte(xml.Header), opf...)}}, eb.files...)
+	mimetypeHeader := zip.FileHeader{Name: "mimetype", Method: zip.Store}             //First and uncompressed, so it works as a magic number
+	mimetypeHeader.ModifiedTime, mimetypeHeader.ModifiedDate = msDOSTime(buildTime()) //Modified would add an extra field, which OCF forbids here
+	w, err := zw.CreateHeader(&mimetypeHeader)
+	if err != nil {
+		return err
+	}
+	_, err = w.Write([]byte(epubMIMEType))
+	if err != nil {
+		return err
+	}
+	for _, f := range files {
+		w, err := zw.CreateHeader(&zip.FileHeader{Name: path.Clean(path.Join(epubContentDir, f.name)), Method: zip.Deflate, Modified: buildTime()})
+		if err != nil {
+			return err
+		}
+		_, err = w.Write(f.content)
+		if err != nil {
+			return err
+		}
+	}
+	err = zw.Close()
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(outputPath, b.Bytes(), 0664)
+}
+
+func writeEPUB(job exportJob) error {
+	if len(job.itemList) < 1 {
+		return errors.New("no articles to put in the EPUB")
+	}
+	chapters := make([]jsfItem, len(job.itemList))
+	for i, ji := range job.itemList {
+		chapters[len(chapters)-1-i] = ji //Oldest first, like a book
+	}
+	eb := epubBook{blogPath: job.blogPath, images: make(map[string]string), chapters: make(map[string]string)}
+	chapterNames := make([]string, len(chapters))
+	for i, ji := range chapters {
+		chapterNames[i] = fmt.Sprintf("chapter-%d.xhtml", i+1)
+		eb.chapters[strings.TrimSuffix(ji.URL, "/")] = chapterNames[i]
+	}
+	title := conf.EPUB.title(job.filter)
+
+	if len(conf.EPUB.coverPath) > 0 {
+		content, err := ioutil.ReadFile(conf.EPUB.coverPath)
+		if err != nil {
+			return err
+		}
+		name := path.Join(epubImageDir, "cover"+strings.ToLower(filepath.Ext(conf.EPUB.coverPath)))
+		eb.add("cover-image", name, attachmentMIMEType(name, ""), "cover-image", content, false)
+		cover := fmt.Sprintf("<section epub:type=\"cover\">\n<img src=\"%s\" alt=\"%s\" />\n</section>", name, html.EscapeString(title))
+		eb.add("cover", epubCoverFile, "application/xhtml+xml", "", xhtmlPage(title, cover), true)
+	}
+	eb.add("nav", epubNavFile, "application/xhtml+xml", "nav", epubNav(title, chapters, chapterNames), true)
+	for i, ji := range chapters {
+		content, err := eb.chapterContent(ji)
+		if err != nil {
+			return fmt.Errorf("article '%s': %s", ji.URL, err.Error())
+		}
+		eb.add(fmt.Sprintf("chapter-%d", i+1), chapterNames[i], "application/xhtml+xml", "", epubChapter(ji, content), true)
+	}
+	return eb.writeZip(filepath.Join(job.outputPath, slugify(title)+".epub"), eb.opf(job, chapters))
+}
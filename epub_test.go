@@ -0,0 +1,196 @@
+package main
+
+import (
+	"archive/zip"
+	"encoding/xml"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+var epubConfigTests = []struct {
+	ec    epubConfig
+	valid bool
+}{
+	{epubConfig{}, true},
+	{epubConfig{Cover: "cover.jpg"}, true},
+	{epubConfig{Cover: "cover.SVG"}, true},
+	{epubConfig{Cover: "cover.pdf"}, false},
+}
+
+func TestEPUBConfigValidate(t *testing.T) {
+	for _, test := range epubConfigTests {
+		err := test.ec.validate()
+		if (err == nil) != test.valid {
+			t.Errorf("Wrong validity for %v, expected %v, actual error %v", test.ec, test.valid, err)
+		}
+	}
+}
+
+func TestEPUBTitle(t *testing.T) {
+	if res := (epubConfig{}).title(exportFilter{}); res != blogTitle {
+		t.Errorf("Wrong default title, expected '%s', actual '%s'", blogTitle, res)
+	}
+	if res := (epubConfig{}).title(exportFilter{tag: "sea shanties"}); res != blogTitle+": Sea Shanties" {
+		t.Errorf("Wrong tag title '%s'", res)
+	}
+	if res := (epubConfig{Title: "Collected"}).title(exportFilter{tag: "sea"}); res != "Collected" {
+		t.Errorf("Config title ignored, actual '%s'", res)
+	}
+}
+
+func TestEPUBIdentifier(t *testing.T) {
+	itemList := []jsfItem{{ID: "a"}, {ID: "b"}}
+	first := (epubConfig{}).identifier(itemList)
+	if first != (epubConfig{}).identifier(itemList) {
+		t.Errorf("Identifier changes between runs")
+	}
+	if first == (epubConfig{}).identifier(itemList[:1]) {
+		t.Errorf("Same identifier for different articles")
+	}
+	if !strings.HasPrefix(first, "urn:uuid:") || len(first) != len("urn:uuid:")+36 || first[len("urn:uuid:")+14] != '5' {
+		t.Errorf("Not a version 5 UUID URN: '%s'", first)
+	}
+	if res := (epubConfig{Identifier: "isbn:123"}).identifier(itemList); res != "isbn:123" {
+		t.Errorf("Config identifier ignored, actual '%s'", res)
+	}
+}
+
+func readEPUB(t *testing.T, epubPath string) ([]string, map[string]string) {
+	zr, err := zip.OpenReader(epubPath)
+	if err != nil {
+		t.Fatalf("Error (%s) opening EPUB", err.Error())
+	}
+	defer zr.Close()
+	names := make([]string, len(zr.File))
+	contents := make(map[string]string)
+	for i, f := range zr.File {
+		names[i] = f.Name
+		r, err := f.Open()
+		if err != nil {
+			t.Fatalf("Error (%s) opening '%s'", err.Error(), f.Name)
+		}
+		content, err := ioutil.ReadAll(r)
+		r.Close()
+		if err != nil {
+			t.Fatalf("Error (%s) reading '%s'", err.Error(), f.Name)
+		}
+		contents[f.Name] = string(content)
+	}
+	if len(zr.File) < 1 || zr.File[0].Name != "mimetype" || zr.File[0].Method != zip.Store || contents["mimetype"] != epubMIMEType {
+		t.Errorf("EPUB does not start with an uncompressed mimetype file")
+	}
+	return names, contents
+}
+
+func wellFormed(content string) error {
+	d := xml.NewDecoder(strings.NewReader(content))
+	d.Strict = true
+	d.Entity = xml.HTMLEntity
+	for {
+		_, err := d.Token()
+		if err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+	}
+}
+
+func TestProcessExportEPUB(t *testing.T) {
+	defer func() { conf = blogConfig{} }()
+	itemContent := []byte(`{"title": "Old & gold", "date_published": "2017-06-10T10:00:00Z", "tags": ["sea"]}`)
+	blogPath, subdirPaths := setupBlog(t, itemContent, []byte("![Gull](attachments/a.png)\n\n<script>alert(1)</script>\n\n![Remote](http://example.com/r.png)"), 3, 3)
+	defer teardownArticlePath(t, blogPath)
+	oldDir := filepath.Base(subdirPaths[0])
+	setupArticle(t, subdirPaths[1], []byte(`{"title": "New", "date_published": "2018-06-10T10:00:00Z", "tags": ["sea"]}`), []byte("Back to [the old one](/"+oldDir+"/#top)."))
+	setupArticle(t, subdirPaths[2], []byte(`{"title": "Land", "date_published": "2018-07-10T10:00:00Z", "tags": ["land"]}`), []byte("Dry."))
+	for _, name := range []string{"a.png", "b.png"} {
+		attachmentPath := filepath.Join(subdirPaths[0], attachmentDir)
+		err := os.MkdirAll(attachmentPath, 0775)
+		if err != nil {
+			t.Fatalf("Error (%s) PRIOR TO RUNNING TEST.", err.Error())
+		}
+		err = ioutil.WriteFile(filepath.Join(attachmentPath, name), testImageBytes(t, 4, 4, "png"), 0664)
+		if err != nil {
+			t.Fatalf("Error (%s) PRIOR TO RUNNING TEST.", err.Error())
+		}
+	}
+	conf.EPUB = epubConfig{Cover: "c.png", coverPath: filepath.Join(subdirPaths[0], attachmentDir, "b.png")}
+	outputPath := filepath.Join(blogPath, "out")
+	buildTestBlog(t, blogPath)
+	err := processExport(blogPath, epubFormat, outputPath, exportFilter{tag: "sea"})
+	if err != nil {
+		t.Fatalf("Error (%s) when all parameters valid.", err.Error())
+	}
+
+	epubPath := filepath.Join(outputPath, slugify(blogTitle+": Sea")+".epub")
+	names, contents := readEPUB(t, epubPath)
+	raw, err := ioutil.ReadFile(epubPath)
+	if err != nil || len(raw) < 30 || raw[28] != 0 || raw[29] != 0 {
+		t.Errorf("Extra field on the mimetype file, which OCF forbids: %v", err)
+	}
+	expectedNames := "mimetype META-INF/container.xml OEBPS/content.opf OEBPS/images/cover.png OEBPS/cover.xhtml OEBPS/nav.xhtml OEBPS/images/image-1.png OEBPS/images/image-2.png OEBPS/chapter-1.xhtml OEBPS/chapter-2.xhtml"
+	if strings.Join(names, " ") != expectedNames {
+		t.Errorf("Wrong files, expected '%s', actual '%s'", expectedNames, strings.Join(names, " "))
+	}
+	for name, content := range contents {
+		if strings.HasSuffix(name, ".xml") || strings.HasSuffix(name, ".opf") || strings.HasSuffix(name, ".xhtml") {
+			if err := wellFormed(content); err != nil {
+				t.Errorf("Error (%s) in '%s':\n%s", err.Error(), name, content)
+			}
+		}
+	}
+
+	opf := contents["OEBPS/content.opf"]
+	for _, expected := range []string{
+		`<dc:title>` + blogTitle + `: Sea</dc:title>`,
+		`<dc:language>en</dc:language>`,
+		`<item id="nav" href="nav.xhtml" media-type="application/xhtml+xml" properties="nav"></item>`,
+		`<item id="image-1" href="images/image-1.png" media-type="image/png"></item>`,
+		`<itemref idref="chapter-1"></itemref>`,
+		`<meta property="dcterms:modified">`,
+		`<meta name="cover" content="cover-image"></meta>`,
+		`<item id="cover-image" href="images/cover.png" media-type="image/png" properties="cover-image"></item>`,
+	} {
+		if !strings.Contains(opf, expected) {
+			t.Errorf("No '%s' in package document:\n%s", expected, opf)
+		}
+	}
+	nav := contents["OEBPS/nav.xhtml"]
+	if !strings.Contains(nav, `<li><a href="chapter-1.xhtml">Old &amp; gold</a></li>`) || strings.Contains(nav, "Land") {
+		t.Errorf("Wrong navigation document:\n%s", nav)
+	}
+
+	first := contents["OEBPS/chapter-1.xhtml"]
+	for _, expected := range []string{
+		`<h1>Old &amp; gold</h1>`,
+		`<p class="date">Sunday, 17 Lavoisier, 48 AT<br />[Gregorian: Saturday, 10 June, 2017 CE]</p>`,
+		`<img src="images/image-1.png" alt="Gull"/>`,
+		`<a href="http://example.com/r.png">Remote</a>`,
+		`<figure><img src="images/image-2.png" alt="" /></figure>`,
+	} {
+		if !strings.Contains(first, expected) {
+			t.Errorf("No '%s' in first chapter:\n%s", expected, first)
+		}
+	}
+	if strings.Contains(first, "script") || strings.Contains(first, "image-1.png\" alt=\"a.png") {
+		t.Errorf("Script or duplicate image in first chapter:\n%s", first)
+	}
+	if second := contents["OEBPS/chapter-2.xhtml"]; !strings.Contains(second, `<a href="chapter-1.xhtml#top">the old one</a>`) {
+		t.Errorf("Link to other article not rewritten:\n%s", second)
+	}
+}
+
+func TestProcessExportEPUBEmpty(t *testing.T) {
+	blogPath, _ := setupBlog(t, []byte(`{"title": "Trip", "date_published": "2017-06-10T10:00:00Z", "tags": ["sea"]}`), []byte("Text"), 1, 1)
+	defer teardownArticlePath(t, blogPath)
+	buildTestBlog(t, blogPath)
+	err := processExport(blogPath, epubFormat, filepath.Join(blogPath, "out"), exportFilter{tag: "land"})
+	if err == nil {
+		t.Errorf("No error for EPUB without articles")
+	}
+}
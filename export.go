@@ -0,0 +1,172 @@
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+const exportMode = "export"
+const exportDateFormat = "2006-01-02"
+
+type exportFilter struct {
+	tag  string
+	from string //Inclusive, as exportDateFormat
+	to   string //Inclusive, as exportDateFormat
+}
+
+type exportJob struct {
+	itemList   []jsfItem //Newest first
+	blogPath   string
+	outputPath string
+	filter     exportFilter
+}
+
+type exportFormat func(job exportJob) error
+
+var exportFormats = map[string]exportFormat{
+	gopherFormat: writeGopherHole,
+	epubFormat:   writeEPUB,
+	textFormat:   writeTextArchive,
+}
+
+func exportFormatNames() string {
+	names := make([]string, 0, len(exportFormats))
+	for name := range exportFormats {
+		names = append(names, "'"+name+"'")
+	}
+	sort.Strings(names)
+	return strings.Join(names, ", ")
+}
+
+func newExportFilter(tag, from, to string) (exportFilter, error) {
+	for _, date := range []string{from, to} {
+		if _, err := time.Parse(exportDateFormat, date); len(date) > 0 && err != nil {
+			return exportFilter{}, fmt.Errorf("invalid date '%s', use YYYY-MM-DD", date)
+		}
+	}
+	if len(from) > 0 && len(to) > 0 && from > to {
+		return exportFilter{}, fmt.Errorf("date range starts (%s) after it ends (%s)", from, to)
+	}
+	return exportFilter{tag, from, to}, nil
+}
+
+func (ef exportFilter) matches(ji jsfItem) bool {
+	published, _ := time.Parse(time.RFC3339, ji.DatePublished)
+	date := published.Format(exportDateFormat) //The date where it was published
+	if (len(ef.from) > 0 && date < ef.from) || (len(ef.to) > 0 && date > ef.to) {
+		return false
+	}
+	if len(ef.tag) < 1 {
+		return true
+	}
+	for _, tag := range ji.Tags {
+		if strings.EqualFold(tag, ef.tag) {
+			return true
+		}
+	}
+	return false
+}
+
+func (ef exportFilter) apply(itemList []jsfItem) []jsfItem {
+	res := make([]jsfItem, 0, len(itemList))
+	for _, ji := range itemList {
+		if ef.matches(ji) {
+			res = append(res, ji)
+		}
+	}
+	return res
+}
+
+func publishedPath(blogPath, rawURL string) (string, bool) {
+	u, err := url.Parse(rawURL)
+	host, _ := url.Parse(hostRawURL)
+	if err != nil || u.Host != host.Host || (u.Scheme != "http" && u.Scheme != "https") {
+		return "", false
+	}
+	return filepath.Join(blogPath, filepath.FromSlash(path.Clean("/"+u.Path))), true
+}
+
+func publishedFile(blogPath, rawURL string) (string, bool) {
+	localPath, ok := publishedPath(blogPath, rawURL)
+	if !ok {
+		return "", false
+	}
+	info, err := os.Stat(localPath)
+	if err != nil || info.IsDir() {
+		return "", false
+	}
+	return localPath, true
+}
+
+func attachmentPathFromURL(itemURL, attachmentURL string) string {
+	prefix := strings.TrimSuffix(itemURL, "/") + "/" + attachmentDir + "/"
+	if !strings.HasPrefix(attachmentURL, prefix) {
+		return ""
+	}
+	res, err := url.PathUnescape(strings.TrimPrefix(attachmentURL, prefix))
+	if err != nil {
+		return ""
+	}
+	return res
+}
+
+func readItemList(blogPath string) ([]jsfItem, error) {
+	articlePaths, err := findArticlePaths(blogPath)
+	if err != nil {
+		return nil, err
+	}
+	itemList := make([]jsfItem, 0, len(articlePaths))
+	for _, articlePath := range articlePaths {
+		ji, _, err := getPreviousItem(articlePath)
+		if err != nil {
+			return nil, err
+		}
+		if len(ji.URL) < 1 {
+			return nil, fmt.Errorf("article '%s' was never built, run %s first", articlePath, updateMode)
+		}
+		ji.dir, err = articleURLPath(blogPath, articlePath)
+		if err != nil {
+			return nil, err
+		}
+		for i, ja := range ji.Attachments {
+			ji.Attachments[i].path = attachmentPathFromURL(ji.URL, ja.URL)
+		}
+		itemList = append(itemList, ji)
+	}
+	return itemList, nil
+}
+
+func exportItemList(blogPath string) ([]jsfItem, error) {
+	itemList, err := readItemList(blogPath) //Never processArticle, an export leaves the blog as it is
+	if err != nil {
+		return nil, err
+	}
+	sort.Sort(byPublishedDescend(itemList))
+	return absoluteItemList(itemList) //Exports are read away from the article page
+}
+
+func processExport(blogRelativePath, format, outputPath string, filter exportFilter) error {
+	export, ok := exportFormats[format]
+	if !ok {
+		return fmt.Errorf("unsupported export format '%s', use %s", format, exportFormatNames())
+	}
+	blogPath, err := filepath.Abs(blogRelativePath)
+	if err != nil {
+		return err
+	}
+	itemList, err := exportItemList(blogPath)
+	if err != nil {
+		return err
+	}
+	err = os.MkdirAll(outputPath, 0775)
+	if err != nil {
+		return err
+	}
+	return export(exportJob{filter.apply(itemList), blogPath, outputPath, filter})
+}
@@ -0,0 +1,155 @@
+package main
+
+import (
+	"html/template"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func buildTestBlog(t *testing.T, blogPath string) {
+	tmpl := template.Must(template.New("Whatever").Parse("{{.ContentHTML}}"))
+	_, err := buildItemList(tmpl, blogPath)
+	if err != nil {
+		t.Fatalf("Error (%s) PRIOR TO RUNNING TEST.", err.Error())
+	}
+}
+
+func TestProcessExportUnsupported(t *testing.T) {
+	blogPath := setupArticlePath(t)
+	defer teardownArticlePath(t, blogPath)
+	outputPath := filepath.Join(blogPath, "out")
+	err := processExport(blogPath, "pdf", outputPath, exportFilter{})
+	if err == nil {
+		t.Errorf("No error for unsupported export format")
+	}
+	if _, err := os.Stat(outputPath); err == nil {
+		t.Errorf("Output directory made for unsupported export format")
+	}
+}
+
+func TestExportItemList(t *testing.T) {
+	blogPath, subdirPaths := setupBlog(t, []byte(`{"title": "Old", "date_published": "2017-06-10T10:00:00Z"}`), []byte("[Link](attachments/a.txt)"), 2, 2)
+	defer teardownArticlePath(t, blogPath)
+	setupArticle(t, subdirPaths[1], []byte(`{"title": "New", "date_published": "2018-06-10T10:00:00Z"}`), []byte("[Link](attachments/a.txt)"))
+	buildTestBlog(t, blogPath)
+	itemList, err := exportItemList(blogPath)
+	if err != nil {
+		t.Fatalf("Error (%s) when all parameters valid.", err.Error())
+	}
+	if len(itemList) != 2 || itemList[0].Title != "New" || itemList[1].Title != "Old" {
+		t.Fatalf("Wrong items or order: %v", itemList)
+	}
+	if itemList[0].dir != filepath.Base(subdirPaths[1]) {
+		t.Errorf("Wrong article directory '%s'", itemList[0].dir)
+	}
+	expected := "<p><a href=\"" + hostRawURL + "/" + filepath.Base(subdirPaths[1]) + "/attachments/a.txt\">Link</a></p>\n"
+	if itemList[0].ContentHTML != expected {
+		t.Errorf("Links not absolute, expected '%s', actual '%s'", expected, itemList[0].ContentHTML)
+	}
+}
+
+func TestExportItemListReadOnly(t *testing.T) {
+	blogPath, subdirPaths := setupBlog(t, []byte(`{"title": "Old", "date_published": "2017-06-10T10:00:00Z"}`), []byte("Old text"), 2, 2)
+	defer teardownArticlePath(t, blogPath)
+	buildTestBlog(t, blogPath)
+	itemPath := filepath.Join(subdirPaths[0], itemFile)
+	before, err := ioutil.ReadFile(itemPath)
+	if err != nil {
+		t.Fatalf("Error (%s) PRIOR TO RUNNING TEST.", err.Error())
+	}
+	err = ioutil.WriteFile(filepath.Join(subdirPaths[0], contentFileMD), []byte("New text"), 0664)
+	if err != nil {
+		t.Fatalf("Error (%s) PRIOR TO RUNNING TEST.", err.Error())
+	}
+	err = os.Remove(filepath.Join(subdirPaths[0], finalWebpageFile))
+	if err != nil {
+		t.Fatalf("Error (%s) PRIOR TO RUNNING TEST.", err.Error())
+	}
+
+	_, err = exportItemList(blogPath)
+	if err != nil {
+		t.Fatalf("Error (%s) when all parameters valid.", err.Error())
+	}
+	after, err := ioutil.ReadFile(itemPath)
+	if err != nil || string(after) != string(before) {
+		t.Errorf("Item file changed by export: '%s', %v", after, err)
+	}
+	if _, err := os.Stat(filepath.Join(subdirPaths[0], finalWebpageFile)); err == nil {
+		t.Errorf("Page rebuilt by export")
+	}
+
+	err = ioutil.WriteFile(filepath.Join(subdirPaths[1], itemFile), []byte(`{"title": "Draft"}`), 0664)
+	if err != nil {
+		t.Fatalf("Error (%s) PRIOR TO RUNNING TEST.", err.Error())
+	}
+	_, err = exportItemList(blogPath)
+	if err == nil {
+		t.Errorf("No error for article that was never built")
+	}
+}
+
+func TestAttachmentPathFromURL(t *testing.T) {
+	itemURL := hostRawURL + "/2017/trip/"
+	for attachmentURL, expected := range map[string]string{
+		itemURL + "attachments/a%20b.jpg":  "a b.jpg",
+		itemURL + "attachments/raw/c.png":  "raw/c.png",
+		hostRawURL + "/images/abc/d.jpg":   "",
+		hostRawURL + "/2017/trip/other.js": "",
+	} {
+		if res := attachmentPathFromURL(itemURL, attachmentURL); res != expected {
+			t.Errorf("Wrong path for '%s', expected '%s', actual '%s'", attachmentURL, expected, res)
+		}
+	}
+}
+
+var newExportFilterTests = []struct {
+	from  string
+	to    string
+	valid bool
+}{
+	{"", "", true},
+	{"2017-01-01", "", true},
+	{"", "2017-01-01", true},
+	{"2017-01-01", "2017-01-01", true},
+	{"2017-01-02", "2017-01-01", false},
+	{"2017-1-1", "", false},
+	{"", "yesterday", false},
+}
+
+func TestNewExportFilter(t *testing.T) {
+	for _, test := range newExportFilterTests {
+		_, err := newExportFilter("", test.from, test.to)
+		if (err == nil) != test.valid {
+			t.Errorf("Wrong validity for '%s' to '%s', expected %v, actual error %v", test.from, test.to, test.valid, err)
+		}
+	}
+}
+
+var exportFilterTests = []struct {
+	filter   exportFilter
+	expected bool
+}{
+	{exportFilter{}, true},
+	{exportFilter{tag: "Sea"}, true},
+	{exportFilter{tag: "land"}, false},
+	{exportFilter{from: "2017-06-10", to: "2017-06-10"}, true},
+	{exportFilter{from: "2017-06-11"}, false},
+	{exportFilter{to: "2017-06-09"}, false},
+	{exportFilter{tag: "sea", from: "2017-01-01", to: "2017-12-31"}, true},
+}
+
+func TestExportFilterMatches(t *testing.T) {
+	ji := jsfItem{DatePublished: "2017-06-10T23:30:00-05:00", Tags: []string{"sea"}} //11 June in UTC
+	for _, test := range exportFilterTests {
+		res := test.filter.matches(ji)
+		if res != test.expected {
+			t.Errorf("Wrong match for %v, expected %v, actual %v", test.filter, test.expected, res)
+		}
+	}
+	filtered := exportFilter{tag: "sea"}.apply([]jsfItem{ji, {DatePublished: ji.DatePublished}})
+	if len(filtered) != 1 {
+		t.Errorf("Wrong filtered list: %v", filtered)
+	}
+}
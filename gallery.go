@@ -0,0 +1,239 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"html/template"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+const galleryDir = "gallery"
+const galleryOrderName = "name"
+const galleryOrderDate = "date"
+const galleryOrderNone = "none" //Only as a flag, to turn the gallery off
+const exifDateFormat = "2006:01:02 15:04:05"
+const exifSubIFDTag = 0x8769
+const exifDateTimeOriginalTag = 0x9003
+const exifDateTimeTag = 0x0132
+
+type galleryImage struct {
+	URL          string `json:"url"`
+	PageURL      string `json:"page_url"`
+	ThumbnailURL string `json:"thumbnail_url"`
+	Width        int    `json:"width,omitempty"`
+	Height       int    `json:"height,omitempty"`
+	Title        string `json:"title,omitempty"`
+	Caption      string `json:"caption,omitempty"`
+	DateTaken    string `json:"date_taken,omitempty"`
+	PrevURL      string `json:"-"` //Empty for the first image
+	NextURL      string `json:"-"` //Empty for the last image
+	name         string
+	slug         string
+	taken        time.Time
+}
+
+func validGalleryOrder(order string) bool {
+	return order == "" || order == galleryOrderName || order == galleryOrderDate
+}
+
+func exifDate(content []byte, mimeType string) (time.Time, bool) {
+	tiff := findEXIF(content, mimeType)
+	order := tiffOrder(tiff)
+	if order == nil {
+		return time.Time{}, false
+	}
+	ifd0 := ifdEntries(tiff, order, int(order.Uint32(tiff[4:8])))
+	candidates := make([]string, 0, 2)
+	if pointer, ok := ifd0[exifSubIFDTag]; ok {
+		if entry, ok := ifdEntries(tiff, order, int(entryValue(order, pointer)))[exifDateTimeOriginalTag]; ok {
+			candidates = append(candidates, entryString(tiff, order, entry))
+		}
+	}
+	if entry, ok := ifd0[exifDateTimeTag]; ok {
+		candidates = append(candidates, entryString(tiff, order, entry)) //When the file was changed, a worse guess
+	}
+	for _, candidate := range candidates {
+		t, err := time.Parse(exifDateFormat, candidate)
+		if err == nil {
+			return t, true
+		}
+	}
+	return time.Time{}, false
+}
+
+func sortGallery(images []galleryImage, order string) {
+	sort.SliceStable(images, func(i, j int) bool {
+		if order == galleryOrderDate && !images[i].taken.Equal(images[j].taken) {
+			if images[i].taken.IsZero() || images[j].taken.IsZero() {
+				return images[j].taken.IsZero() //Undated images go last
+			}
+			return images[i].taken.Before(images[j].taken)
+		}
+		return images[i].name < images[j].name
+	})
+}
+
+func buildGallery(attachments []jsfAttachment, articlePath, blogPath, pageURL, order string) ([]galleryImage, error) {
+	ic := imageConfig{Widths: []int{}} //Only the thumbnail
+	if conf.Images != nil {
+		ic = *conf.Images
+	}
+	res := make([]galleryImage, 0)
+	for _, ja := range attachments {
+		if _, ok := imageFormats[ja.MIMEType]; !ok {
+			continue
+		}
+		attachPath, err := attachmentFilePath(articlePath, ja)
+		if err != nil {
+			return nil, err
+		}
+		content, err := ioutil.ReadFile(attachPath)
+		if err != nil {
+			return nil, err
+		}
+		ri, err := makeResponsiveImage(content, ja.MIMEType, blogPath, ic)
+		if err != nil {
+			return nil, fmt.Errorf("image '%s': %s", attachPath, err.Error())
+		}
+		gi := galleryImage{URL: ja.URL, ThumbnailURL: ri.Thumbnail.URL, Width: ri.Width, Height: ri.Height, Title: ja.Title, name: ja.path}
+		if ja.Blom != nil {
+			gi.Caption = ja.Blom.Caption
+		}
+		if taken, ok := exifDate(content, ja.MIMEType); ok {
+			gi.taken = taken
+			gi.DateTaken = taken.Format("2006-01-02T15:04:05") //EXIF has no time zone
+		}
+		res = append(res, gi)
+	}
+	sortGallery(res, order)
+
+	seen := make(map[string]bool)
+	base := strings.TrimSuffix(pageURL, "/")
+	for i := range res {
+		name := path.Base(res[i].name)
+		res[i].slug = uniqueID(slugify(strings.TrimSuffix(name, path.Ext(name))), seen)
+		res[i].PageURL = base + "/" + galleryDir + "/" + res[i].slug + "/"
+	}
+	for i := range res {
+		if i > 0 {
+			res[i].PrevURL = res[i-1].PageURL
+		}
+		if i+1 < len(res) {
+			res[i].NextURL = res[i+1].PageURL
+		}
+	}
+	return res, nil
+}
+
+func (gi galleryImage) displayTitle() string {
+	if len(gi.Title) > 0 {
+		return gi.Title
+	}
+	return path.Base(gi.name)
+}
+
+func galleryHTML(images []galleryImage) template.HTML {
+	if len(images) < 1 {
+		return ""
+	}
+	var b bytes.Buffer
+	b.WriteString("<div class=\"gallery\">\n")
+	for _, gi := range images {
+		fmt.Fprintf(&b, "<figure><a href=\"%s\"><img src=\"%s\" alt=\"%s\" loading=\"lazy\" /></a>", template.HTMLEscapeString(gi.PageURL), template.HTMLEscapeString(gi.ThumbnailURL), template.HTMLEscapeString(gi.displayTitle()))
+		if len(gi.Caption) > 0 {
+			b.WriteString("<figcaption>" + template.HTMLEscapeString(gi.Caption) + "</figcaption>")
+		}
+		b.WriteString("</figure>\n")
+	}
+	b.WriteString("</div>")
+	return template.HTML(b.String())
+}
+
+func galleryPageHTML(gi galleryImage, articleURL string) []byte {
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "<figure class=\"gallery-image\"><img src=\"%s\" alt=\"%s\"", template.HTMLEscapeString(gi.URL), template.HTMLEscapeString(gi.displayTitle()))
+	if gi.Width > 0 {
+		fmt.Fprintf(&b, " width=\"%d\" height=\"%d\"", gi.Width, gi.Height)
+	}
+	b.WriteString(" />")
+	if len(gi.Caption) > 0 {
+		b.WriteString("<figcaption>" + template.HTMLEscapeString(gi.Caption) + "</figcaption>")
+	}
+	b.WriteString("</figure>\n<nav class=\"gallery-nav\">")
+	if len(gi.PrevURL) > 0 {
+		fmt.Fprintf(&b, "<a rel=\"prev\" href=\"%s\">Previous</a> ", template.HTMLEscapeString(gi.PrevURL))
+	}
+	fmt.Fprintf(&b, "<a rel=\"up\" href=\"%s\">Gallery</a>", template.HTMLEscapeString(articleURL))
+	if len(gi.NextURL) > 0 {
+		fmt.Fprintf(&b, " <a rel=\"next\" href=\"%s\">Next</a>", template.HTMLEscapeString(gi.NextURL))
+	}
+	b.WriteString("</nav>")
+	return b.Bytes()
+}
+
+func removeStaleGalleryPages(outputPath string, gallery []galleryImage) error {
+	galleryPath := filepath.Join(outputPath, galleryDir)
+	entries, err := ioutil.ReadDir(galleryPath)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	current := make(map[string]bool)
+	for _, gi := range gallery {
+		current[gi.slug] = true
+	}
+	for _, entry := range entries {
+		if !entry.IsDir() || current[entry.Name()] {
+			continue
+		}
+		pagePath := filepath.Join(galleryPath, entry.Name())
+		files, err := ioutil.ReadDir(pagePath)
+		if err != nil {
+			return err
+		}
+		if len(files) != 1 || files[0].Name() != finalWebpageFile {
+			continue //Not a page blom wrote
+		}
+		err = os.RemoveAll(pagePath)
+		if err != nil {
+			return err
+		}
+	}
+	if len(gallery) < 1 {
+		os.Remove(galleryPath) //Fails, harmlessly, if anything else is in it
+	}
+	return nil
+}
+
+func writeGalleryPages(tmpl *template.Template, articleE articleExport, articleURL, outputPath string) error {
+	err := removeStaleGalleryPages(outputPath, articleE.Gallery) //Images removed, renamed, or the whole gallery turned off
+	if err != nil {
+		return err
+	}
+	for i, gi := range articleE.Gallery {
+		exportArgs := articleE
+		exportArgs.Title = gi.displayTitle()
+		exportArgs.ContentHTML = template.HTML(galleryPageHTML(gi, articleURL))
+		exportArgs.Summary = gi.Caption
+		exportArgs.TOC = nil
+		exportArgs.TOCHTML = ""
+		exportArgs.GalleryImage = &articleE.Gallery[i]
+		pagePath := filepath.Join(outputPath, galleryDir, gi.slug)
+		err := os.MkdirAll(pagePath, 0775)
+		if err != nil {
+			return err
+		}
+		err = exportArgs.writeFinalWebpage(tmpl, pagePath)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
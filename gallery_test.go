@@ -0,0 +1,220 @@
+package main
+
+import (
+	"bytes"
+	"html/template"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func exifWithDate(date string) []byte {
+	b := []byte("II*\x00\x08\x00\x00\x00\x01\x00")
+	b = append(b, 0x69, 0x87, 0x04, 0x00, 0x01, 0x00, 0x00, 0x00, 0x1a, 0x00, 0x00, 0x00)
+	b = append(b, 0x00, 0x00, 0x00, 0x00, 0x01, 0x00)
+	b = append(b, 0x03, 0x90, 0x02, 0x00, 0x14, 0x00, 0x00, 0x00, 0x2c, 0x00, 0x00, 0x00)
+	b = append(b, 0x00, 0x00, 0x00, 0x00)
+	return append(b, []byte(date+"\x00")...)
+}
+
+func jpegWithDate(t *testing.T, date string) []byte {
+	plain := testImageBytes(t, 40, 20, "jpeg")
+	var b bytes.Buffer
+	b.Write(plain[:2])
+	b.Write(jpegAppSegment(0xe1, append([]byte(exifHeader), exifWithDate(date)...)))
+	b.Write(plain[2:])
+	return b.Bytes()
+}
+
+func TestExifDate(t *testing.T) {
+	res, ok := exifDate(jpegWithDate(t, "2019:07:04 18:30:00"), "image/jpeg")
+	expected := time.Date(2019, 7, 4, 18, 30, 0, 0, time.UTC)
+	if !ok || !res.Equal(expected) {
+		t.Errorf("Wrong date, expected %v, actual %v (%v)", expected, res, ok)
+	}
+	_, ok = exifDate(testImageBytes(t, 4, 4, "jpeg"), "image/jpeg")
+	if ok {
+		t.Errorf("Date found in JPEG without EXIF")
+	}
+	_, ok = exifDate(jpegWithDate(t, "0000:00:00 00:00:00"), "image/jpeg")
+	if ok {
+		t.Errorf("Date found in JPEG with blank EXIF date")
+	}
+}
+
+func TestSortGallery(t *testing.T) {
+	day := func(d int) time.Time { return time.Date(2020, 1, d, 0, 0, 0, 0, time.UTC) }
+	images := []galleryImage{{name: "c.jpg", taken: day(1)}, {name: "a.jpg"}, {name: "b.jpg", taken: day(2)}, {name: "d.jpg", taken: day(1)}}
+	sortGallery(images, galleryOrderName)
+	names := make([]string, len(images))
+	for i, gi := range images {
+		names[i] = gi.name
+	}
+	if !reflect.DeepEqual(names, []string{"a.jpg", "b.jpg", "c.jpg", "d.jpg"}) {
+		t.Errorf("Wrong order by name: %v", names)
+	}
+	sortGallery(images, galleryOrderDate)
+	for i, gi := range images {
+		names[i] = gi.name
+	}
+	if !reflect.DeepEqual(names, []string{"c.jpg", "d.jpg", "b.jpg", "a.jpg"}) {
+		t.Errorf("Wrong order by date: %v", names)
+	}
+}
+
+func setupGalleryArticle(t *testing.T) (string, string) {
+	blogPath := setupArticlePath(t)
+	articlePath := filepath.Join(blogPath, "trip")
+	attachPath := filepath.Join(articlePath, attachmentDir)
+	err := os.MkdirAll(filepath.Join(attachPath, "day 2"), 0777)
+	if err != nil {
+		t.Errorf("Error (%s) BEFORE STARTING TEST.", err.Error())
+	}
+	files := map[string][]byte{
+		"beach.jpg":       jpegWithDate(t, "2019:07:05 09:00:00"),
+		"beach.jpg.txt":   []byte("Beach\nLow tide, <early>"),
+		"day 2/beach.jpg": jpegWithDate(t, "2019:07:04 18:30:00"),
+		"map.png":         testImageBytes(t, 20, 20, "png"),
+		"route.gpx":       []byte("<gpx/>"),
+	}
+	for name, content := range files {
+		err = ioutil.WriteFile(filepath.Join(attachPath, filepath.FromSlash(name)), content, 0664)
+		if err != nil {
+			t.Errorf("Error (%s) BEFORE STARTING TEST.", err.Error())
+		}
+	}
+	err = ioutil.WriteFile(filepath.Join(articlePath, contentFileHTML), []byte("<p>Holiday</p>"), 0664)
+	if err != nil {
+		t.Errorf("Error (%s) BEFORE STARTING TEST.", err.Error())
+	}
+	return blogPath, articlePath
+}
+
+func TestBuildGallery(t *testing.T) {
+	blogPath, articlePath := setupGalleryArticle(t)
+	defer teardownArticlePath(t, blogPath)
+	var ji jsfItem
+	err := ji.initAttachments(articlePath, "trip", conf.Attachments.merge(nil))
+	if err != nil {
+		t.Errorf("Error (%s) BEFORE STARTING TEST.", err.Error())
+	}
+	pageURL := hostRawURL + "/trip"
+	res, err := buildGallery(ji.Attachments, articlePath, blogPath, pageURL, galleryOrderDate)
+	if err != nil {
+		t.Errorf("Error (%s) when all parameters valid.", err.Error())
+	}
+	if len(res) != 3 {
+		t.Fatalf("Wrong number of images, expected 3, actual %d: %v", len(res), res)
+	}
+	expectedPages := []string{pageURL + "/gallery/beach/", pageURL + "/gallery/beach-2/", pageURL + "/gallery/map/"}
+	for i, u := range expectedPages {
+		if res[i].PageURL != u {
+			t.Errorf("Wrong page URL at %d, expected '%s', actual '%s'", i, u, res[i].PageURL)
+		}
+	}
+	if res[0].URL != hostRawURL+"/trip/attachments/day%202/beach.jpg" || res[0].DateTaken != "2019-07-04T18:30:00" {
+		t.Errorf("Wrong first image: %v", res[0])
+	}
+	if res[1].Title != "Beach" || res[1].Caption != "Low tide, <early>" || res[1].Width != 40 || res[1].Height != 20 {
+		t.Errorf("Wrong second image: %v", res[1])
+	}
+	if res[0].PrevURL != "" || res[0].NextURL != res[1].PageURL || res[2].PrevURL != res[1].PageURL || res[2].NextURL != "" {
+		t.Errorf("Wrong previous and next links: %v", res)
+	}
+	for _, gi := range res {
+		if !strings.HasPrefix(gi.ThumbnailURL, hostRawURL+"/"+imageDir+"/") {
+			t.Errorf("Wrong thumbnail URL '%s'", gi.ThumbnailURL)
+		}
+		if _, err := os.Stat(filepath.Join(blogPath, imageDir, filepath.Base(gi.ThumbnailURL))); err != nil {
+			t.Errorf("Missing thumbnail '%s'", gi.ThumbnailURL)
+		}
+	}
+}
+
+func TestGalleryHTML(t *testing.T) {
+	images := []galleryImage{
+		{URL: "a.jpg", PageURL: "gallery/a/", ThumbnailURL: "a-320.jpg", Caption: "Low tide, <early>", name: "a.jpg", NextURL: "gallery/b/"},
+		{URL: "b.jpg", PageURL: "gallery/b/", ThumbnailURL: "b-320.jpg", Title: "Pier", Width: 40, Height: 20, name: "b.jpg", PrevURL: "gallery/a/"},
+	}
+	expected := "<div class=\"gallery\">\n" +
+		"<figure><a href=\"gallery/a/\"><img src=\"a-320.jpg\" alt=\"a.jpg\" loading=\"lazy\" /></a><figcaption>Low tide, &lt;early&gt;</figcaption></figure>\n" +
+		"<figure><a href=\"gallery/b/\"><img src=\"b-320.jpg\" alt=\"Pier\" loading=\"lazy\" /></a></figure>\n" +
+		"</div>"
+	res := galleryHTML(images)
+	if string(res) != expected {
+		t.Errorf("Wrong gallery, expected '%s', actual '%s'", expected, res)
+	}
+	if galleryHTML(nil) != "" {
+		t.Errorf("Empty gallery has markup")
+	}
+
+	expectedPage := "<figure class=\"gallery-image\"><img src=\"b.jpg\" alt=\"Pier\" width=\"40\" height=\"20\" /></figure>\n" +
+		"<nav class=\"gallery-nav\"><a rel=\"prev\" href=\"gallery/a/\">Previous</a> <a rel=\"up\" href=\"trip\">Gallery</a></nav>"
+	page := galleryPageHTML(images[1], "trip")
+	if string(page) != expectedPage {
+		t.Errorf("Wrong gallery page, expected '%s', actual '%s'", expectedPage, page)
+	}
+}
+
+func TestProcessArticleGallery(t *testing.T) {
+	tmpl := template.Must(template.New("Whatever").Parse("{{.Title}}|{{len .Gallery}}|{{if .GalleryImage}}{{.GalleryImage.PageURL}}{{else}}{{.GalleryHTML}}{{end}}"))
+	blogPath, articlePath := setupGalleryArticle(t)
+	defer teardownArticlePath(t, blogPath)
+
+	_, err := processArticle(tmpl, blogPath, articlePath, articleFlags{title: "Trip", gallery: "size"})
+	if err == nil {
+		t.Errorf("No error for unsupported gallery order")
+	}
+	ji, err := processArticle(tmpl, blogPath, articlePath, articleFlags{title: "Trip", gallery: galleryOrderName})
+	if err != nil {
+		t.Errorf("Error (%s) when all parameters valid.", err.Error())
+	}
+	if len(ji.Gallery) != 3 || ji.Gallery[0].Title != "Beach" {
+		t.Fatalf("Wrong gallery in item: %v", ji.Gallery)
+	}
+	page, err := ioutil.ReadFile(filepath.Join(articlePath, finalWebpageFile))
+	if err != nil {
+		t.Errorf("Error (%s) reading article page", err.Error())
+	}
+	if !strings.HasPrefix(string(page), "Trip|3|<div class=\"gallery\">") {
+		t.Errorf("Wrong article page '%s'", page)
+	}
+	imagePage, err := ioutil.ReadFile(filepath.Join(articlePath, galleryDir, "beach-2", "index.html"))
+	if err != nil {
+		t.Errorf("Error (%s) reading gallery page", err.Error())
+	}
+	expected := "beach.jpg|3|" + ji.Gallery[1].PageURL
+	if string(imagePage) != expected {
+		t.Errorf("Wrong gallery page, expected '%s', actual '%s'", expected, imagePage)
+	}
+
+	err = os.Remove(filepath.Join(articlePath, attachmentDir, "beach.jpg"))
+	if err != nil {
+		t.Fatalf("Error (%s) PRIOR TO RUNNING TEST.", err.Error())
+	}
+	ji, err = processArticle(tmpl, blogPath, articlePath, articleFlags{})
+	if err != nil {
+		t.Errorf("Error (%s) when all parameters valid.", err.Error())
+	}
+	if len(ji.Gallery) != 2 {
+		t.Errorf("Wrong gallery after removing an image: %v", ji.Gallery)
+	}
+	if _, err := os.Stat(filepath.Join(articlePath, galleryDir, "beach-2")); err == nil {
+		t.Errorf("Page of removed image left behind")
+	}
+
+	ji, err = processArticle(tmpl, blogPath, articlePath, articleFlags{gallery: galleryOrderNone})
+	if err != nil {
+		t.Errorf("Error (%s) when all parameters valid.", err.Error())
+	}
+	if len(ji.Gallery) > 0 || (ji.Blom != nil && len(ji.Blom.Gallery) > 0) {
+		t.Errorf("Gallery not turned off: %v %v", ji.Gallery, ji.Blom)
+	}
+	if _, err := os.Stat(filepath.Join(articlePath, galleryDir)); err == nil {
+		t.Errorf("Gallery pages left behind after turning it off")
+	}
+}
@@ -0,0 +1,519 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/gorilla/feeds"
+	"github.com/russross/blackfriday"
+	"html"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+const defaultGeminiPath = "gemini"
+const geminiIndexFile = "index.gmi"
+const geminiGemlogPath = "feeds/gemlog.gmi"
+const geminiAtomPath = "feeds/atom.xml"
+const geminiDateFormat = "2006-01-02"
+
+type geminiConfig struct {
+	URL  string `json:"url"`  //Defaults to gemini:// on the blog's host
+	Path string `json:"path"` //Relative to the blog root, defaults to "gemini"
+}
+
+type gemtextLink struct {
+	url   string
+	label string
+}
+
+type gemtextRenderer struct {
+	link      func(string) string
+	links     []gemtextLink //Gemtext has no inline links, so they go after the block
+	footnotes int
+}
+
+func (gc geminiConfig) rootURL() string {
+	if len(gc.URL) > 0 {
+		return strings.TrimSuffix(gc.URL, "/")
+	}
+	u, _ := url.Parse(hostRawURL)
+	return "gemini://" + u.Host
+}
+
+func (gc geminiConfig) outputPath(blogPath string) string {
+	p := gc.Path
+	if len(p) < 1 {
+		p = defaultGeminiPath
+	}
+	return filepath.Join(blogPath, filepath.FromSlash(p))
+}
+
+func (gc geminiConfig) validate() error {
+	if len(gc.URL) > 0 {
+		u, err := url.Parse(gc.URL)
+		if err != nil || u.Scheme != "gemini" || len(u.Host) < 1 {
+			return fmt.Errorf("invalid gemini url '%s'", gc.URL)
+		}
+	}
+	clean := path.Clean(filepath.ToSlash(gc.Path))
+	if len(gc.Path) > 0 && (clean == "." || path.IsAbs(clean) || strings.HasPrefix(clean, "..")) {
+		return fmt.Errorf("gemini path '%s' must be a directory inside the blog", gc.Path)
+	}
+	return nil
+}
+
+func (gc geminiConfig) itemURL(ji jsfItem) string {
+	urlPath := strings.Trim(strings.TrimPrefix(ji.URL, hostRawURL), "/")
+	if len(urlPath) < 1 {
+		return gc.rootURL() + "/"
+	}
+	return gc.rootURL() + "/" + urlPath + "/"
+}
+
+func (gr *gemtextRenderer) addLink(link, label string) {
+	label = strings.Join(strings.Fields(label), " ")
+	if label == link {
+		label = ""
+	}
+	gr.links = append(gr.links, gemtextLink{gr.link(link), label})
+}
+
+func (gr *gemtextRenderer) flushLinks(out *bytes.Buffer) {
+	for _, l := range gr.links {
+		out.WriteString("=> " + l.url)
+		if len(l.label) > 0 {
+			out.WriteString(" " + l.label)
+		}
+		out.WriteString("\n")
+	}
+	gr.links = nil
+}
+
+func prefixLines(text []byte, prefix string) []byte {
+	var b bytes.Buffer
+	for _, line := range strings.Split(strings.TrimSpace(string(text)), "\n") {
+		line = strings.TrimSpace(line)
+		if len(line) < 1 {
+			continue
+		}
+		if strings.HasPrefix(line, "=>") || strings.HasPrefix(line, prefix) {
+			b.WriteString(line + "\n") //Link lines only work at the start, nested list items stay flat
+		} else {
+			b.WriteString(prefix + line + "\n")
+		}
+	}
+	return b.Bytes()
+}
+
+func (gr *gemtextRenderer) BlockCode(out *bytes.Buffer, text []byte, infoString string) {
+	out.WriteString("```" + strings.TrimSpace(infoString) + "\n")
+	out.Write(text)
+	if !bytes.HasSuffix(text, []byte("\n")) {
+		out.WriteString("\n")
+	}
+	out.WriteString("```\n\n")
+}
+
+func (gr *gemtextRenderer) BlockQuote(out *bytes.Buffer, text []byte) {
+	out.Write(prefixLines(text, "> "))
+	out.WriteString("\n")
+}
+
+func (gr *gemtextRenderer) BlockHtml(out *bytes.Buffer, text []byte) {
+	plain := strings.TrimSpace(htmlToText(string(text)))
+	if len(plain) > 0 {
+		out.WriteString(plain + "\n\n")
+	}
+}
+
+func (gr *gemtextRenderer) Header(out *bytes.Buffer, text func() bool, level int, id string) {
+	marker := out.Len()
+	if level > 3 {
+		level = 3 //Gemtext only has three levels
+	}
+	out.WriteString(strings.Repeat("#", level) + " ")
+	if !text() {
+		out.Truncate(marker)
+		return
+	}
+	out.WriteString("\n")
+	gr.flushLinks(out)
+	out.WriteString("\n")
+}
+
+func (gr *gemtextRenderer) HRule(out *bytes.Buffer) {
+	out.WriteString("---\n\n")
+}
+
+func (gr *gemtextRenderer) List(out *bytes.Buffer, text func() bool, flags int) {
+	marker := out.Len()
+	if marker > 0 && out.Bytes()[marker-1] != '\n' {
+		out.WriteString("\n") //Nested lists follow the text of their item
+	}
+	if !text() {
+		out.Truncate(marker)
+		return
+	}
+	gr.flushLinks(out)
+	out.WriteString("\n")
+}
+
+func (gr *gemtextRenderer) ListItem(out *bytes.Buffer, text []byte, flags int) {
+	if flags&blackfriday.LIST_TYPE_TERM != 0 {
+		out.WriteString(strings.TrimSpace(string(text)) + "\n")
+		return
+	}
+	out.Write(prefixLines(text, "* "))
+	gr.flushLinks(out)
+}
+
+func (gr *gemtextRenderer) Paragraph(out *bytes.Buffer, text func() bool) {
+	marker := out.Len()
+	if !text() {
+		out.Truncate(marker)
+		return
+	}
+	if len(bytes.TrimSpace(out.Bytes()[marker:])) < 1 {
+		out.Truncate(marker) //Only an image, which becomes a link line
+	} else {
+		out.WriteString("\n")
+	}
+	gr.flushLinks(out)
+	out.WriteString("\n")
+}
+
+func (gr *gemtextRenderer) Table(out *bytes.Buffer, header []byte, body []byte, columnData []int) {
+	out.WriteString("```\n")
+	out.Write(header)
+	out.Write(body)
+	out.WriteString("```\n")
+	gr.flushLinks(out)
+	out.WriteString("\n")
+}
+
+func (gr *gemtextRenderer) TableRow(out *bytes.Buffer, text []byte) {
+	out.WriteString(strings.TrimSuffix(string(text), " | ") + "\n")
+}
+
+func (gr *gemtextRenderer) TableHeaderCell(out *bytes.Buffer, text []byte, flags int) {
+	gr.TableCell(out, text, flags)
+}
+
+func (gr *gemtextRenderer) TableCell(out *bytes.Buffer, text []byte, flags int) {
+	out.Write(text)
+	out.WriteString(" | ")
+}
+
+func (gr *gemtextRenderer) Footnotes(out *bytes.Buffer, text func() bool) {
+	marker := out.Len()
+	out.WriteString("---\n\n")
+	if !text() {
+		out.Truncate(marker)
+		return
+	}
+	out.WriteString("\n")
+}
+
+func (gr *gemtextRenderer) FootnoteItem(out *bytes.Buffer, name, text []byte, flags int) {
+	gr.footnotes++
+	fmt.Fprintf(out, "[%d] %s\n", gr.footnotes, strings.Join(strings.Fields(string(text)), " "))
+	gr.flushLinks(out)
+}
+
+func (gr *gemtextRenderer) TitleBlock(out *bytes.Buffer, text []byte) {
+	out.WriteString("# " + strings.TrimSpace(strings.TrimPrefix(string(text), "%")) + "\n\n")
+}
+
+func (gr *gemtextRenderer) AutoLink(out *bytes.Buffer, link []byte, kind int) {
+	out.Write(link)
+	if kind == blackfriday.LINK_TYPE_EMAIL && !bytes.HasPrefix(link, []byte("mailto:")) {
+		gr.addLink("mailto:"+string(link), string(link))
+		return
+	}
+	gr.addLink(string(link), string(link))
+}
+
+func (gr *gemtextRenderer) CodeSpan(out *bytes.Buffer, text []byte) {
+	out.WriteString("`" + strings.Replace(string(text), "\n", " ", -1) + "`")
+}
+
+func (gr *gemtextRenderer) DoubleEmphasis(out *bytes.Buffer, text []byte) {
+	out.Write(text)
+}
+
+func (gr *gemtextRenderer) Emphasis(out *bytes.Buffer, text []byte) {
+	out.Write(text)
+}
+
+func (gr *gemtextRenderer) Image(out *bytes.Buffer, link []byte, title []byte, alt []byte) {
+	label := string(alt)
+	if len(label) < 1 {
+		label = string(title)
+	}
+	if len(label) < 1 {
+		label = "Image"
+	}
+	gr.addLink(string(link), label)
+}
+
+func (gr *gemtextRenderer) LineBreak(out *bytes.Buffer) {
+	out.WriteString("\n")
+}
+
+func (gr *gemtextRenderer) Link(out *bytes.Buffer, link []byte, title []byte, content []byte) {
+	out.Write(content)
+	gr.addLink(string(link), string(content))
+}
+
+func (gr *gemtextRenderer) RawHtmlTag(out *bytes.Buffer, tag []byte) {
+}
+
+func (gr *gemtextRenderer) TripleEmphasis(out *bytes.Buffer, text []byte) {
+	out.Write(text)
+}
+
+func (gr *gemtextRenderer) StrikeThrough(out *bytes.Buffer, text []byte) {
+	out.WriteString("~~")
+	out.Write(text)
+	out.WriteString("~~")
+}
+
+func (gr *gemtextRenderer) FootnoteRef(out *bytes.Buffer, ref []byte, id int) {
+	fmt.Fprintf(out, "[%d]", id)
+}
+
+func (gr *gemtextRenderer) Entity(out *bytes.Buffer, entity []byte) {
+	out.WriteString(html.UnescapeString(string(entity)))
+}
+
+func (gr *gemtextRenderer) NormalText(out *bytes.Buffer, text []byte) {
+	out.WriteString(strings.Replace(string(text), "\n", " ", -1)) //Every gemtext line is a paragraph
+}
+
+func (gr *gemtextRenderer) DocumentHeader(out *bytes.Buffer) {
+}
+
+func (gr *gemtextRenderer) DocumentFooter(out *bytes.Buffer) {
+}
+
+func (gr *gemtextRenderer) GetFlags() int {
+	return 0
+}
+
+func renderGemtext(input []byte, link func(string) string) ([]byte, error) {
+	renderer, err := newBlackfridayRenderer(conf.Markdown.extensions()) //Gemtext always comes from blackfriday
+	if err != nil {
+		return nil, err
+	}
+	gr := &gemtextRenderer{link: link}
+	extensions := renderer.(blackfridayRenderer).flags.extensions
+	res := blackfriday.MarkdownOptions(input, gr, blackfriday.Options{Extensions: extensions})
+	return append(bytes.TrimRight(res, "\n"), '\n'), nil
+}
+
+func geminiLinkMapper(ji jsfItem, pages map[string]string) (func(string) string, error) {
+	base, err := pageBase(ji.URL)
+	if err != nil {
+		return nil, err
+	}
+	return func(link string) string {
+		resolved := resolveLink(base, link)
+		page := resolved
+		fragment := ""
+		if i := strings.Index(page, "#"); i >= 0 {
+			page, fragment = page[:i], page[i:]
+		}
+		if geminiURL, ok := pages[strings.TrimSuffix(page, "/")]; ok {
+			return geminiURL + fragment //Other articles are in the capsule too
+		}
+		return resolved
+	}, nil
+}
+
+func geminiDate(ji jsfItem) string {
+	t, _ := time.Parse(time.RFC3339, ji.DatePublished)
+	return t.Format(geminiDateFormat)
+}
+
+func geminiLinkLine(gc geminiConfig, ji jsfItem) string {
+	return fmt.Sprintf("=> %s %s %s\n", gc.itemURL(ji), geminiDate(ji), strings.Join(strings.Fields(ji.Title), " "))
+}
+
+func geminiBody(gc geminiConfig, ji jsfItem, blogPath string, pages map[string]string) ([]byte, error) {
+	markdown, err := ioutil.ReadFile(filepath.Join(blogPath, filepath.FromSlash(ji.dir), contentFileMD))
+	if os.IsNotExist(err) {
+		if len(ji.Summary) < 1 {
+			return nil, nil //HTML articles are only linked
+		}
+		return []byte(strings.Join(strings.Fields(ji.Summary), " ") + "\n"), nil
+	} else if err != nil {
+		return nil, err
+	}
+	link, err := geminiLinkMapper(ji, pages)
+	if err != nil {
+		return nil, err
+	}
+	return renderGemtext(markdown, link)
+}
+
+func geminiArticle(ji jsfItem, body []byte) []byte {
+	var b bytes.Buffer
+	b.WriteString("# " + strings.Join(strings.Fields(ji.Title), " ") + "\n\n")
+	b.WriteString(geminiDate(ji) + "\n\n")
+	if len(body) > 0 {
+		b.Write(body)
+		b.WriteString("\n")
+	}
+	for _, ja := range ji.Attachments {
+		label := ja.Title
+		if len(label) < 1 {
+			label = path.Base(ja.URL)
+		}
+		b.WriteString("=> " + ja.URL + " " + label + "\n")
+	}
+	b.WriteString("=> " + ji.URL + " View on the web\n")
+	return b.Bytes()
+}
+
+func geminiHomepage(gc geminiConfig, itemList []jsfItem, latestBody []byte) []byte {
+	root := gc.rootURL()
+	var b bytes.Buffer
+	b.WriteString("# " + blogTitle + "\n\n")
+	if len(conf.Description) > 0 {
+		b.WriteString(conf.Description + "\n\n")
+	}
+	if len(itemList) > 0 {
+		latest := itemList[0]
+		b.WriteString("## " + strings.Join(strings.Fields(latest.Title), " ") + "\n\n")
+		if len(latestBody) > 0 {
+			b.Write(latestBody)
+			b.WriteString("\n")
+		}
+		b.WriteString("=> " + gc.itemURL(latest) + " Permalink\n\n")
+	}
+	b.WriteString("=> " + root + "/" + geminiGemlogPath + " All articles\n")
+	b.WriteString("=> " + root + "/archive/ Archive\n")
+	b.WriteString("=> " + root + "/tags/ Tags\n")
+	b.WriteString("=> " + root + "/" + geminiAtomPath + " Atom feed\n")
+	b.WriteString("=> " + hostRawURL + " On the web\n")
+	return b.Bytes()
+}
+
+func geminiGemlog(gc geminiConfig, itemList []jsfItem) []byte {
+	var b bytes.Buffer
+	b.WriteString("# " + blogTitle + "\n\n")
+	if len(conf.Description) > 0 {
+		b.WriteString("## " + conf.Description + "\n\n")
+	}
+	for _, ji := range itemList {
+		b.WriteString(geminiLinkLine(gc, ji))
+	}
+	return b.Bytes()
+}
+
+func geminiArchive(gc geminiConfig, itemList []jsfItem) []byte {
+	var b bytes.Buffer
+	b.WriteString("# Archive\n")
+	for _, group := range archiveGroups(itemList) {
+		b.WriteString("\n## " + group.heading + "\n")
+		for _, ji := range group.items {
+			b.WriteString(geminiLinkLine(gc, ji))
+		}
+	}
+	return b.Bytes()
+}
+
+func geminiTags(gc geminiConfig, itemList []jsfItem) []byte {
+	var b bytes.Buffer
+	b.WriteString("# Tags\n")
+	tagMap, tagList := tagSort(itemList)
+	for _, tag := range tagList {
+		b.WriteString("\n## " + strings.Title(tag) + "\n")
+		for _, ji := range tagMap[tag] {
+			b.WriteString(geminiLinkLine(gc, ji))
+		}
+	}
+	return b.Bytes()
+}
+
+func makeGeminiAtomFeed(gc geminiConfig, itemList []jsfItem) atomFeed {
+	root := gc.rootURL() + "/"
+	var gf feeds.Feed
+	gf.Title = blogTitle
+	gf.Link = &feeds.Link{Href: root}
+	gf.Updated = newestModified(itemList)
+	gf.Items = make([]*feeds.Item, len(itemList))
+	for i, ji := range itemList {
+		gi := new(feeds.Item)
+		fromJsfItem(gi, ji)
+		gi.Link = &feeds.Link{Href: gc.itemURL(ji), Type: "text/gemini"}
+		gi.Content = "" //The content is HTML, readers can follow the link
+		if len(ji.Summary) < 1 {
+			gi.Description = ""
+		}
+		gf.Items[i] = gi
+	}
+	af := wrapAtomFeed(&gf, itemList, rootScope)
+	af.Links = []feeds.AtomLink{{Href: root, Rel: "alternate", Type: "text/gemini"}, {Href: root + geminiAtomPath, Rel: "self", Type: "application/atom+xml"}}
+	return af
+}
+
+func writeOutputFile(content []byte, outputPath string) error {
+	err := os.MkdirAll(filepath.Dir(outputPath), 0775)
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(outputPath, content, 0664)
+}
+
+func processGemini(wg *sync.WaitGroup, itemList []jsfItem, blogPath string, ch chan<- error) {
+	defer wg.Done()
+	gc := *conf.Gemini
+	capsulePath := gc.outputPath(blogPath)
+	pages := make(map[string]string)
+	for _, ji := range itemList {
+		pages[strings.TrimSuffix(ji.URL, "/")] = gc.itemURL(ji)
+	}
+
+	var latestBody []byte
+	for i, ji := range itemList {
+		body, err := geminiBody(gc, ji, blogPath, pages)
+		if err != nil {
+			ch <- err
+			return
+		}
+		if i == 0 {
+			latestBody = body
+		}
+		articlePath := filepath.Join(capsulePath, filepath.FromSlash(strings.TrimPrefix(gc.itemURL(ji), gc.rootURL())), geminiIndexFile)
+		err = writeOutputFile(geminiArticle(ji, body), articlePath)
+		if err != nil {
+			ch <- err
+			return
+		}
+	}
+
+	files := map[string][]byte{
+		geminiIndexFile:                       geminiHomepage(gc, itemList, latestBody),
+		geminiGemlogPath:                      geminiGemlog(gc, itemList),
+		path.Join("archive", geminiIndexFile): geminiArchive(gc, itemList),
+		path.Join("tags", geminiIndexFile):    geminiTags(gc, itemList),
+	}
+	for name, content := range files {
+		err := writeOutputFile(content, filepath.Join(capsulePath, filepath.FromSlash(name)))
+		if err != nil {
+			ch <- err
+			return
+		}
+	}
+	err := writeXML(makeGeminiAtomFeed(gc, itemList), filepath.Join(capsulePath, filepath.FromSlash(geminiAtomPath)))
+	if err != nil {
+		ch <- err
+	}
+}
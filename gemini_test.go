@@ -0,0 +1,166 @@
+package main
+
+import (
+	"html/template"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+const gemtextInput = `# Trip
+
+We went to [the beach](beach/) and saw
+*birds* &amp; ` + "`boats`" + `.\
+Then home.
+
+![A gull](attachments/gull.jpg)
+
+* one [a](a.html)
+* two
+    * nested
+
+> Quoted [b](b.html)
+> more
+
+` + "```go\nfmt.Println(1)\n```" + `
+
+#### Deep
+
+| A | B |
+|---|---|
+| 1 | [c](c.html) |
+
+See https://example.com now.
+`
+
+const gemtextExpected = "# Trip\n\n" +
+	"We went to the beach and saw birds & `boats`.\nThen home.\n=> /beach/ the beach\n\n" +
+	"=> /attachments/gull.jpg A gull\n\n" +
+	"* one a\n=> /a.html a\n* two\n* nested\n\n" +
+	"> Quoted b more\n=> /b.html b\n\n" +
+	"```go\nfmt.Println(1)\n```\n\n" +
+	"### Deep\n\n" +
+	"```\nA | B\n1 | c\n```\n=> /c.html c\n\n" +
+	"See https://example.com now.\n=> /https://example.com\n"
+
+func TestRenderGemtext(t *testing.T) {
+	res, err := renderGemtext([]byte(gemtextInput), func(link string) string { return "/" + link })
+	if err != nil {
+		t.Errorf("Error (%s) when all parameters valid.", err.Error())
+	}
+	if string(res) != gemtextExpected {
+		t.Errorf("Wrong gemtext, expected:\n%s\nactual:\n%s", gemtextExpected, res)
+	}
+}
+
+var geminiConfigTests = []struct {
+	gc    geminiConfig
+	valid bool
+}{
+	{geminiConfig{}, true},
+	{geminiConfig{URL: "gemini://example.org/blog/", Path: "public/gemini"}, true},
+	{geminiConfig{URL: "https://example.org"}, false},
+	{geminiConfig{URL: "gemini:///blog"}, false},
+	{geminiConfig{Path: "."}, false},
+	{geminiConfig{Path: "../gemini"}, false},
+	{geminiConfig{Path: "/srv/gemini"}, false},
+}
+
+func TestGeminiConfigValidate(t *testing.T) {
+	for _, test := range geminiConfigTests {
+		err := test.gc.validate()
+		if (err == nil) != test.valid {
+			t.Errorf("Wrong validity for %v, expected %v, actual error %v", test.gc, test.valid, err)
+		}
+	}
+	gc := geminiConfig{URL: "gemini://example.org/blog/"}
+	if u := gc.itemURL(jsfItem{URL: hostRawURL + "/2020/trip"}); u != "gemini://example.org/blog/2020/trip/" {
+		t.Errorf("Wrong item URL '%s'", u)
+	}
+	if u := (geminiConfig{}).rootURL(); u != "gemini://ratan.blog" {
+		t.Errorf("Wrong default root URL '%s'", u)
+	}
+}
+
+func TestGeminiLinkMapper(t *testing.T) {
+	pages := map[string]string{hostRawURL + "/home": "gemini://ratan.blog/home/"}
+	link, err := geminiLinkMapper(jsfItem{URL: hostRawURL + "/trip"}, pages)
+	if err != nil {
+		t.Errorf("Error (%s) when all parameters valid.", err.Error())
+	}
+	tests := map[string]string{
+		"attachments/a.jpg":       hostRawURL + "/trip/attachments/a.jpg",
+		"../home/#end":            "gemini://ratan.blog/home/#end",
+		hostRawURL + "/home":      "gemini://ratan.blog/home/",
+		"https://example.com/x":   "https://example.com/x",
+		"gemini://example.org/y/": "gemini://example.org/y/",
+	}
+	for input, expected := range tests {
+		if res := link(input); res != expected {
+			t.Errorf("Wrong link for '%s', expected '%s', actual '%s'", input, expected, res)
+		}
+	}
+}
+
+func TestGeminiListPages(t *testing.T) {
+	gc := geminiConfig{}
+	itemList := []jsfItem{
+		{URL: hostRawURL + "/b", Title: "Second", DatePublished: "1972-03-02T10:00:00Z", Tags: []string{"sea"}},
+		{URL: hostRawURL + "/a", Title: "First", DatePublished: "1972-03-01T10:00:00Z", Tags: []string{"sea", "home"}},
+	}
+	expectedArchive := "# Archive\n\n## Imhotep, 3 AT\n=> gemini://ratan.blog/b/ 1972-03-02 Second\n\n## Hippocrates & Aldrin Day, 3 AT\n=> gemini://ratan.blog/a/ 1972-03-01 First\n"
+	if res := string(geminiArchive(gc, itemList)); res != expectedArchive {
+		t.Errorf("Wrong archive, expected:\n%s\nactual:\n%s", expectedArchive, res)
+	}
+	expectedTags := "# Tags\n\n## Home\n=> gemini://ratan.blog/a/ 1972-03-01 First\n\n## Sea\n=> gemini://ratan.blog/b/ 1972-03-02 Second\n=> gemini://ratan.blog/a/ 1972-03-01 First\n"
+	if res := string(geminiTags(gc, itemList)); res != expectedTags {
+		t.Errorf("Wrong tags, expected:\n%s\nactual:\n%s", expectedTags, res)
+	}
+	expectedGemlog := "# " + blogTitle + "\n\n=> gemini://ratan.blog/b/ 1972-03-02 Second\n=> gemini://ratan.blog/a/ 1972-03-01 First\n"
+	if res := string(geminiGemlog(gc, itemList)); res != expectedGemlog {
+		t.Errorf("Wrong gemlog, expected:\n%s\nactual:\n%s", expectedGemlog, res)
+	}
+}
+
+func TestProcessBlogGemini(t *testing.T) {
+	conf.Gemini = &geminiConfig{}
+	fixedNow = time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	defer func() {
+		conf = blogConfig{}
+		fixedNow = time.Time{}
+	}()
+	itemContent := []byte(`{"title": "Trip", "date_published": "2017-06-10T10:00:00Z", "tags": ["sea"]}`)
+	blogPath, subdirPaths := setupBlog(t, itemContent, []byte("A [photo](attachments/a.jpg)."), 1, 1)
+	defer os.RemoveAll(blogPath)
+	for _, dir := range []string{"feeds", "tags", "archive"} {
+		os.Mkdir(filepath.Join(blogPath, dir), 0775)
+	}
+	tmpl := template.Must(template.New("Whatever").Parse("{{.ContentHTML}}"))
+	err := processBlog(tmpl, tmpl, blogPath)
+	if err != nil {
+		t.Fatalf("Error (%s) when all parameters valid.", err.Error())
+	}
+
+	dir := filepath.Base(subdirPaths[0])
+	capsulePath := filepath.Join(blogPath, defaultGeminiPath)
+	article, err := ioutil.ReadFile(filepath.Join(capsulePath, dir, geminiIndexFile))
+	if err != nil {
+		t.Fatalf("Error (%s) reading article", err.Error())
+	}
+	expected := "# Trip\n\n2017-06-10\n\nA photo.\n=> " + hostRawURL + "/" + dir + "/attachments/a.jpg photo\n\n=> " + hostRawURL + "/" + dir + " View on the web\n"
+	if string(article) != expected {
+		t.Errorf("Wrong article, expected:\n%s\nactual:\n%s", expected, article)
+	}
+	for _, name := range []string{geminiIndexFile, geminiGemlogPath, "archive/" + geminiIndexFile, "tags/" + geminiIndexFile, geminiAtomPath} {
+		content, err := ioutil.ReadFile(filepath.Join(capsulePath, filepath.FromSlash(name)))
+		if err != nil {
+			t.Errorf("Error (%s) reading '%s'", err.Error(), name)
+		}
+		if !strings.Contains(string(content), "gemini://ratan.blog/"+dir+"/") {
+			t.Errorf("No link to the article in '%s':\n%s", name, content)
+		}
+	}
+}
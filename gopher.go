@@ -0,0 +1,475 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"golang.org/x/net/html"
+	"net/url"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+const gopherFormat = "gopher"
+const gophermapFile = "gophermap"
+const gopherTextExt = ".txt"
+const defaultGopherPort = 70
+const defaultGopherWidth = 70
+const minGopherWidth = 20
+const gopherRecentItems = 15
+
+type gopherConfig struct {
+	Host     string `json:"host"`     //Defaults to the blog's host
+	Port     int    `json:"port"`     //Defaults to 70
+	Selector string `json:"selector"` //Where the hole is served from, the root if unset
+	Width    int    `json:"width"`    //Defaults to 70
+}
+
+type textWriter struct {
+	width    int
+	out      bytes.Buffer
+	cur      strings.Builder
+	pre      strings.Builder
+	links    []string
+	href     string
+	skip     int
+	inPre    int
+	quote    int
+	lists    int
+	heading  int
+	bullet   bool //The next block starts a list item
+	lastItem bool //List items are not separated by blank lines
+}
+
+func (gc gopherConfig) host() string {
+	if len(gc.Host) > 0 {
+		return gc.Host
+	}
+	u, _ := url.Parse(hostRawURL)
+	return u.Hostname()
+}
+
+func (gc gopherConfig) port() int {
+	if gc.Port == 0 {
+		return defaultGopherPort
+	}
+	return gc.Port
+}
+
+func (gc gopherConfig) width() int {
+	if gc.Width == 0 {
+		return defaultGopherWidth
+	}
+	return gc.Width
+}
+
+func (gc gopherConfig) validate() error {
+	if gc.Port < 0 || gc.Port > 65535 {
+		return fmt.Errorf("invalid gopher port %d", gc.Port)
+	}
+	if gc.Width != 0 && gc.Width < minGopherWidth {
+		return fmt.Errorf("gopher width %d is less than %d", gc.Width, minGopherWidth)
+	}
+	return nil
+}
+
+func (gc gopherConfig) selector(p string) string {
+	return path.Join("/", gc.Selector, p)
+}
+
+func gopherItemPath(ji jsfItem) string {
+	return strings.Trim(strings.TrimPrefix(ji.URL, hostRawURL), "/") + gopherTextExt
+}
+
+func gopherDisplay(s string) string {
+	return strings.Join(strings.Fields(s), " ") //Tabs and line breaks would end the menu line
+}
+
+func (gc gopherConfig) menuLine(b *bytes.Buffer, itemType byte, display, selector string) {
+	fmt.Fprintf(b, "%c%s\t%s\t%s\t%d\n", itemType, gopherDisplay(display), selector, gc.host(), gc.port())
+}
+
+func (gc gopherConfig) infoLines(b *bytes.Buffer, text string) {
+	lines := wrapText(text, gc.width(), "", "")
+	if len(lines) < 1 {
+		lines = []string{""}
+	}
+	for _, line := range lines {
+		fmt.Fprintf(b, "i%s\t\tnull.host\t1\n", line)
+	}
+}
+
+func (gc gopherConfig) itemLine(b *bytes.Buffer, ji jsfItem) {
+	t, _ := time.Parse(time.RFC3339, ji.DatePublished)
+	gc.menuLine(b, '0', t.Format("2006-01-02")+" "+ji.Title, gc.selector(gopherItemPath(ji)))
+}
+
+func wrapText(text string, width int, first, rest string) []string {
+	lines := make([]string, 0)
+	line := first
+	empty := true
+	for _, word := range strings.Fields(text) {
+		if !empty && utf8.RuneCountInString(line)+1+utf8.RuneCountInString(word) > width {
+			lines = append(lines, line)
+			line = rest
+			empty = true
+		}
+		if !empty {
+			line += " "
+		}
+		line += word
+		empty = false
+	}
+	if !empty {
+		lines = append(lines, line)
+	}
+	return lines
+}
+
+func (tw *textWriter) prefixes() (string, string) {
+	first := strings.Repeat("> ", tw.quote)
+	if tw.lists > 0 {
+		first += strings.Repeat("  ", tw.lists-1)
+	}
+	rest := first
+	if tw.lists > 0 {
+		rest += "  "
+		if tw.bullet {
+			first += "* "
+		} else {
+			first += "  "
+		}
+	}
+	return first, rest
+}
+
+func (tw *textWriter) writeBlock(lines []string) {
+	isItem := tw.lists > 0
+	if tw.out.Len() > 0 && !(isItem && tw.lastItem && tw.bullet) {
+		tw.out.WriteString("\n")
+	}
+	for _, line := range lines {
+		tw.out.WriteString(strings.TrimRight(line, " ") + "\n")
+	}
+	tw.bullet = false
+	tw.lastItem = isItem
+}
+
+func (tw *textWriter) flush() {
+	text := tw.cur.String()
+	tw.cur.Reset()
+	if len(strings.TrimSpace(text)) < 1 {
+		return
+	}
+	first, rest := tw.prefixes()
+	lines := make([]string, 0)
+	for _, segment := range strings.Split(text, "\n") {
+		segmentLines := wrapText(segment, tw.width, first, rest)
+		if len(segmentLines) > 0 {
+			lines = append(lines, segmentLines...)
+			first = rest
+		}
+	}
+	if tw.heading > 0 && len(lines) > 0 {
+		underline := "-"
+		if tw.heading == 1 {
+			underline = "="
+		}
+		longest := 0
+		for _, line := range lines {
+			if n := utf8.RuneCountInString(strings.TrimPrefix(line, rest)); n > longest {
+				longest = n
+			}
+		}
+		lines = append(lines, rest+strings.Repeat(underline, longest))
+	}
+	tw.writeBlock(lines)
+}
+
+func (tw *textWriter) addLink(link string) int {
+	for i, existing := range tw.links {
+		if existing == link {
+			return i + 1
+		}
+	}
+	tw.links = append(tw.links, link)
+	return len(tw.links)
+}
+
+func attrVal(tok html.Token, key string) string {
+	for _, attr := range tok.Attr {
+		if attr.Key == key {
+			return attr.Val
+		}
+	}
+	return ""
+}
+
+func (tw *textWriter) startTag(tok html.Token) {
+	switch tok.Data {
+	case "script", "style":
+		tw.skip++
+	case "pre":
+		tw.flush()
+		tw.inPre++
+	case "h1", "h2", "h3", "h4", "h5", "h6":
+		tw.flush()
+		tw.heading = int(tok.Data[1] - '0')
+	case "ul", "ol", "dl":
+		tw.flush()
+		tw.lists++
+	case "li", "dt":
+		tw.flush()
+		tw.bullet = true
+	case "blockquote":
+		tw.flush()
+		tw.quote++
+	case "p", "div", "figure", "figcaption", "table", "tr", "dd", "section", "article", "header", "footer", "nav", "aside", "hr":
+		tw.flush()
+	case "br":
+		if tw.inPre > 0 {
+			tw.pre.WriteString("\n")
+		} else {
+			tw.cur.WriteString("\n")
+		}
+	case "td", "th":
+		tw.cur.WriteString(" ")
+	case "a":
+		tw.href = attrVal(tok, "href")
+	case "img":
+		alt := attrVal(tok, "alt")
+		if len(alt) < 1 {
+			alt = "Image"
+		}
+		src := attrVal(tok, "src")
+		if len(src) > 0 {
+			tw.cur.WriteString(fmt.Sprintf("[%s] [%d]", alt, tw.addLink(src)))
+		} else {
+			tw.cur.WriteString("[" + alt + "]")
+		}
+	}
+}
+
+func (tw *textWriter) endTag(tok html.Token) {
+	switch tok.Data {
+	case "script", "style":
+		if tw.skip > 0 {
+			tw.skip--
+		}
+	case "pre":
+		if tw.inPre < 1 {
+			return
+		}
+		tw.inPre--
+		first, _ := tw.prefixes()
+		lines := strings.Split(strings.Trim(tw.pre.String(), "\n"), "\n")
+		tw.pre.Reset()
+		for i := range lines {
+			lines[i] = first + lines[i] //Preformatted text is never wrapped
+		}
+		tw.writeBlock(lines)
+	case "h1", "h2", "h3", "h4", "h5", "h6":
+		tw.flush()
+		tw.heading = 0
+	case "ul", "ol", "dl":
+		tw.flush()
+		if tw.lists > 0 {
+			tw.lists--
+		}
+	case "blockquote":
+		tw.flush()
+		if tw.quote > 0 {
+			tw.quote--
+		}
+	case "li", "dt", "p", "div", "figure", "figcaption", "table", "tr", "dd", "section", "article", "header", "footer", "nav", "aside":
+		tw.flush()
+	case "a":
+		if len(tw.href) > 0 && !strings.HasPrefix(tw.href, "#") {
+			tw.cur.WriteString(fmt.Sprintf(" [%d]", tw.addLink(tw.href)))
+		}
+		tw.href = ""
+	}
+}
+
+func htmlToWrappedText(content string, width int) string {
+	tw := textWriter{width: width}
+	z := html.NewTokenizer(strings.NewReader(content))
+	for tt := z.Next(); tt != html.ErrorToken; tt = z.Next() {
+		tok := z.Token()
+		switch {
+		case tt == html.TextToken && tw.skip > 0:
+		case tt == html.TextToken && tw.inPre > 0:
+			tw.pre.WriteString(tok.Data)
+		case tt == html.TextToken:
+			tw.cur.WriteString(strings.NewReplacer("\n", " ", "\r", " ", "\t", " ").Replace(tok.Data))
+		case tt == html.StartTagToken || tt == html.SelfClosingTagToken:
+			tw.startTag(tok)
+		case tt == html.EndTagToken:
+			tw.endTag(tok)
+		}
+	}
+	tw.flush()
+	if len(tw.links) > 0 {
+		tw.out.WriteString("\n")
+		for i, link := range tw.links {
+			fmt.Fprintf(&tw.out, "[%d] %s\n", i+1, link)
+		}
+	}
+	return tw.out.String()
+}
+
+func gopherArticle(gc gopherConfig, ji jsfItem) []byte {
+	var b bytes.Buffer
+	title := wrapText(ji.Title, gc.width(), "", "")
+	longest := 0
+	for _, line := range title {
+		b.WriteString(line + "\n")
+		if n := utf8.RuneCountInString(line); n > longest {
+			longest = n
+		}
+	}
+	b.WriteString(strings.Repeat("=", longest) + "\n\n")
+	published, _ := time.Parse(time.RFC3339, ji.DatePublished)
+	b.WriteString(dualDateSeperated(published, "\n") + "\n")
+	if len(ji.Tags) > 0 {
+		b.WriteString("Tags: " + strings.Join(ji.Tags, ", ") + "\n")
+	}
+	b.WriteString("\n")
+	b.WriteString(htmlToWrappedText(ji.ContentHTML, gc.width()))
+	if len(ji.Attachments) > 0 {
+		b.WriteString("\nAttachments:\n")
+		for _, ja := range ji.Attachments {
+			if len(ja.Title) > 0 {
+				b.WriteString("* " + ja.Title + ": " + ja.URL + "\n")
+			} else {
+				b.WriteString("* " + ja.URL + "\n")
+			}
+		}
+	}
+	b.WriteString("\nOn the web: " + ji.URL + "\n")
+	return b.Bytes()
+}
+
+func gopherHomeMap(gc gopherConfig, itemList []jsfItem) []byte {
+	var b bytes.Buffer
+	gc.infoLines(&b, blogTitle)
+	if len(conf.Description) > 0 {
+		gc.infoLines(&b, conf.Description)
+	}
+	gc.infoLines(&b, "")
+	gc.infoLines(&b, "Recent posts")
+	for i, ji := range itemList {
+		if i >= gopherRecentItems {
+			break
+		}
+		gc.itemLine(&b, ji)
+	}
+	gc.infoLines(&b, "")
+	gc.menuLine(&b, '1', "Archive", gc.selector("archive"))
+	gc.menuLine(&b, '1', "Tags", gc.selector("tags"))
+	gc.menuLine(&b, 'h', "On the web", "URL:"+hostRawURL)
+	return b.Bytes()
+}
+
+func gregorianGroups(itemList []jsfItem) []archiveGroup {
+	res := make([]archiveGroup, 0)
+	last := ""
+	for _, ji := range itemList {
+		t, _ := time.Parse(time.RFC3339, ji.DatePublished)
+		heading := t.Format("January 2006")
+		if heading != last {
+			res = append(res, archiveGroup{heading: heading})
+			last = heading
+		}
+		res[len(res)-1].items = append(res[len(res)-1].items, ji)
+	}
+	return res
+}
+
+func gregorianSlug(group archiveGroup) string {
+	t, _ := time.Parse(time.RFC3339, group.items[0].DatePublished)
+	return t.Format("2006-01")
+}
+
+func (gc gopherConfig) listMap(title string, items []jsfItem, up, upSelector string) []byte {
+	var b bytes.Buffer
+	gc.infoLines(&b, title)
+	gc.infoLines(&b, "")
+	for _, ji := range items {
+		gc.itemLine(&b, ji)
+	}
+	gc.infoLines(&b, "")
+	gc.menuLine(&b, '1', up, upSelector)
+	return b.Bytes()
+}
+
+func gopherArchiveMaps(gc gopherConfig, itemList []jsfItem) map[string][]byte {
+	res := make(map[string][]byte)
+	var b bytes.Buffer
+	gc.infoLines(&b, "Archive")
+	gc.infoLines(&b, "")
+	gc.infoLines(&b, "By Tranquility month")
+	for _, group := range archiveGroups(itemList) {
+		slug := slugify(group.heading)
+		gc.menuLine(&b, '1', group.heading, gc.selector(path.Join("archive", slug)))
+		res[path.Join("archive", slug)] = gc.listMap(group.heading, group.items, "Archive", gc.selector("archive"))
+	}
+	gc.infoLines(&b, "")
+	gc.infoLines(&b, "By Gregorian month")
+	for _, group := range gregorianGroups(itemList) {
+		slug := gregorianSlug(group)
+		gc.menuLine(&b, '1', group.heading, gc.selector(path.Join("archive", slug)))
+		res[path.Join("archive", slug)] = gc.listMap(group.heading, group.items, "Archive", gc.selector("archive"))
+	}
+	gc.infoLines(&b, "")
+	gc.menuLine(&b, '1', blogTitle, gc.selector(""))
+	res["archive"] = b.Bytes()
+	return res
+}
+
+func gopherTagMaps(gc gopherConfig, itemList []jsfItem) map[string][]byte {
+	res := make(map[string][]byte)
+	var b bytes.Buffer
+	gc.infoLines(&b, "Tags")
+	gc.infoLines(&b, "")
+	tagMap, tagList := tagSort(itemList)
+	seen := make(map[string]bool)
+	for _, tag := range tagList {
+		slug := uniqueID(slugify(tag), seen)
+		title := strings.Title(tag)
+		gc.menuLine(&b, '1', title+" ("+strconv.Itoa(len(tagMap[tag]))+")", gc.selector(path.Join("tags", slug)))
+		res[path.Join("tags", slug)] = gc.listMap(title, tagMap[tag], "Tags", gc.selector("tags"))
+	}
+	gc.infoLines(&b, "")
+	gc.menuLine(&b, '1', blogTitle, gc.selector(""))
+	res["tags"] = b.Bytes()
+	return res
+}
+
+func writeGopherHole(job exportJob) error {
+	gc := conf.Gopher
+	itemList, outputPath := job.itemList, job.outputPath
+	for _, ji := range itemList {
+		err := writeOutputFile(gopherArticle(gc, ji), filepath.Join(outputPath, filepath.FromSlash(gopherItemPath(ji))))
+		if err != nil {
+			return err
+		}
+	}
+	maps := map[string][]byte{"": gopherHomeMap(gc, itemList)}
+	for dir, content := range gopherArchiveMaps(gc, itemList) {
+		maps[dir] = content
+	}
+	for dir, content := range gopherTagMaps(gc, itemList) {
+		maps[dir] = content
+	}
+	for dir, content := range maps {
+		err := writeOutputFile(content, filepath.Join(outputPath, filepath.FromSlash(dir), gophermapFile))
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
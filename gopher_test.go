@@ -0,0 +1,143 @@
+package main
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+var wrapTextTests = []struct {
+	text     string
+	width    int
+	expected []string
+}{
+	{"", 10, []string{}},
+	{"one two three four", 9, []string{"> one two", ">   three", ">   four"}},
+	{"unbreakablewordhere ok", 9, []string{"> unbreakablewordhere", ">   ok"}},
+	{"café crème brûlée", 12, []string{"> café crème", ">   brûlée"}}, //Runes, not bytes
+}
+
+func TestWrapText(t *testing.T) {
+	for _, test := range wrapTextTests {
+		res := wrapText(test.text, test.width, "> ", ">   ")
+		if !reflect.DeepEqual(res, test.expected) {
+			t.Errorf("Wrong wrapping of '%s' at %d, expected %q, actual %q", test.text, test.width, test.expected, res)
+		}
+	}
+}
+
+func TestHTMLToWrappedText(t *testing.T) {
+	content := "<h2 id=\"x\">A heading</h2>\n<p>Some text with a <a href=\"http://e.com/a\">link</a> and more words to make it wrap around the limit.<br />New line.</p>\n" +
+		"<ul>\n<li>one</li>\n<li>two\n<ul><li>nested</li></ul></li>\n</ul>\n<blockquote><p>Quoted text</p></blockquote>\n" +
+		"<pre><code>a  b\n  c\n</code></pre>\n<script>var x;</script>\n<p><img src=\"http://e.com/i.jpg\" alt=\"Gull\" /> <a href=\"#top\">top</a> <a href=\"http://e.com/a\">again</a></p>"
+	expected := "A heading\n---------\n\n" +
+		"Some text with a link [1] and\nmore words to make it wrap\naround the limit.\nNew line.\n\n" +
+		"* one\n* two\n  * nested\n\n" +
+		"> Quoted text\n\n" +
+		"a  b\n  c\n\n" +
+		"[Gull] [2] top again [1]\n\n" +
+		"[1] http://e.com/a\n[2] http://e.com/i.jpg\n"
+	res := htmlToWrappedText(content, 30)
+	if res != expected {
+		t.Errorf("Wrong text, expected:\n%s\nactual:\n%s", expected, res)
+	}
+}
+
+var gopherConfigTests = []struct {
+	gc    gopherConfig
+	valid bool
+}{
+	{gopherConfig{}, true},
+	{gopherConfig{Host: "example.org", Port: 7070, Selector: "/blog", Width: 80}, true},
+	{gopherConfig{Port: 70000}, false},
+	{gopherConfig{Width: 5}, false},
+}
+
+func TestGopherConfigValidate(t *testing.T) {
+	for _, test := range gopherConfigTests {
+		err := test.gc.validate()
+		if (err == nil) != test.valid {
+			t.Errorf("Wrong validity for %v, expected %v, actual error %v", test.gc, test.valid, err)
+		}
+	}
+}
+
+func TestGopherMenus(t *testing.T) {
+	gc := gopherConfig{Selector: "blog"}
+	itemList := []jsfItem{
+		{URL: hostRawURL + "/b", Title: "Second\tpost", DatePublished: "1972-03-02T10:00:00Z", Tags: []string{"sea"}},
+		{URL: hostRawURL + "/a", Title: "First", DatePublished: "1972-03-01T10:00:00Z", Tags: []string{"sea", "home"}},
+	}
+	home := string(gopherHomeMap(gc, itemList))
+	expectedHome := "iratan.blog\t\tnull.host\t1\n" +
+		"i\t\tnull.host\t1\n" +
+		"iRecent posts\t\tnull.host\t1\n" +
+		"01972-03-02 Second post\t/blog/b.txt\tratan.blog\t70\n" +
+		"01972-03-01 First\t/blog/a.txt\tratan.blog\t70\n" +
+		"i\t\tnull.host\t1\n" +
+		"1Archive\t/blog/archive\tratan.blog\t70\n" +
+		"1Tags\t/blog/tags\tratan.blog\t70\n" +
+		"hOn the web\tURL:" + hostRawURL + "\tratan.blog\t70\n"
+	if home != expectedHome {
+		t.Errorf("Wrong home gophermap, expected:\n%s\nactual:\n%s", expectedHome, home)
+	}
+
+	archive := gopherArchiveMaps(gc, itemList)
+	for _, dir := range []string{"archive", "archive/imhotep-3-at", "archive/hippocrates-aldrin-day-3-at", "archive/1972-03"} {
+		if _, ok := archive[dir]; !ok {
+			t.Errorf("Missing archive gophermap '%s' in %v", dir, reflect.ValueOf(archive).MapKeys())
+		}
+	}
+	if !strings.Contains(string(archive["archive"]), "1March 1972\t/blog/archive/1972-03\tratan.blog\t70\n") {
+		t.Errorf("No Gregorian month in archive:\n%s", archive["archive"])
+	}
+	if strings.Count(string(archive["archive/1972-03"]), "\n0") != 2 {
+		t.Errorf("Wrong Gregorian month gophermap:\n%s", archive["archive/1972-03"])
+	}
+
+	tags := gopherTagMaps(gc, itemList)
+	if !strings.Contains(string(tags["tags"]), "1Sea (2)\t/blog/tags/sea\tratan.blog\t70\n") {
+		t.Errorf("Wrong tags gophermap:\n%s", tags["tags"])
+	}
+	if !strings.Contains(string(tags["tags/home"]), "01972-03-01 First\t/blog/a.txt") {
+		t.Errorf("Wrong tag gophermap:\n%s", tags["tags/home"])
+	}
+}
+
+func TestProcessExportGopher(t *testing.T) {
+	conf.Gopher = gopherConfig{Width: 40}
+	defer func() { conf = blogConfig{} }()
+	itemContent := []byte(`{"title": "Trip", "date_published": "2017-06-10T10:00:00Z", "tags": ["sea"]}`)
+	blogPath, subdirPaths := setupBlog(t, itemContent, []byte("See the [photo](attachments/a.jpg)."), 1, 1)
+	defer teardownArticlePath(t, blogPath)
+	outputPath := filepath.Join(blogPath, "gopher")
+	buildTestBlog(t, blogPath)
+	err := processExport(blogPath, gopherFormat, outputPath, exportFilter{})
+	if err != nil {
+		t.Fatalf("Error (%s) when all parameters valid.", err.Error())
+	}
+
+	dir := filepath.Base(subdirPaths[0])
+	article, err := ioutil.ReadFile(filepath.Join(outputPath, dir+gopherTextExt))
+	if err != nil {
+		t.Fatalf("Error (%s) reading article", err.Error())
+	}
+	expected := "Trip\n====\n\n" +
+		"Sunday, 17 Lavoisier, 48 AT\n[Gregorian: Saturday, 10 June, 2017 CE]\nTags: sea\n\n" +
+		"See the photo [1].\n\n[1] " + hostRawURL + "/" + dir + "/attachments/a.jpg\n\n" +
+		"On the web: " + hostRawURL + "/" + dir + "\n"
+	if string(article) != expected {
+		t.Errorf("Wrong article, expected:\n%s\nactual:\n%s", expected, article)
+	}
+	for _, name := range []string{gophermapFile, "archive/" + gophermapFile, "tags/" + gophermapFile, "tags/sea/" + gophermapFile, "archive/2017-06/" + gophermapFile} {
+		content, err := ioutil.ReadFile(filepath.Join(outputPath, filepath.FromSlash(name)))
+		if err != nil {
+			t.Errorf("Error (%s) reading '%s'", err.Error(), name)
+		}
+		if len(content) < 1 {
+			t.Errorf("Empty gophermap '%s'", name)
+		}
+	}
+}
@@ -0,0 +1,147 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/alecthomas/chroma/v2"
+	chromahtml "github.com/alecthomas/chroma/v2/formatters/html"
+	"github.com/alecthomas/chroma/v2/lexers"
+	"github.com/alecthomas/chroma/v2/styles"
+	"github.com/russross/blackfriday"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+const defaultHighlightStyle = "github"
+
+type highlightConfig struct {
+	Style       string `json:"style"`
+	LineNumbers bool   `json:"line_numbers"`
+	CSSFile     string `json:"css_file"` //Relative to the blog root, inline styles if empty
+}
+
+type highlightRenderer struct {
+	blackfriday.Renderer
+	hc highlightConfig
+}
+
+type codeBlockInfo struct {
+	lang        string
+	lineNumbers bool
+	lines       [][2]int
+}
+
+func (hc highlightConfig) style() *chroma.Style {
+	if len(hc.Style) < 1 {
+		return styles.Get(defaultHighlightStyle)
+	}
+	return styles.Get(hc.Style)
+}
+
+func (hc highlightConfig) validate() error {
+	if _, ok := styles.Registry[hc.Style]; len(hc.Style) > 0 && !ok {
+		return fmt.Errorf("unknown highlight style '%s'", hc.Style)
+	}
+	return nil
+}
+
+func parseLineRanges(s string) ([][2]int, error) {
+	res := make([][2]int, 0)
+	for _, part := range strings.Split(s, ",") {
+		bounds := strings.SplitN(part, "-", 2)
+		first, err := strconv.Atoi(bounds[0])
+		if err != nil {
+			return nil, err
+		}
+		last := first
+		if len(bounds) > 1 {
+			last, err = strconv.Atoi(bounds[1])
+			if err != nil {
+				return nil, err
+			}
+		}
+		res = append(res, [2]int{first, last})
+	}
+	return res, nil
+}
+
+func parseCodeBlockInfo(info string, lineNumbers bool) codeBlockInfo {
+	res := codeBlockInfo{lineNumbers: lineNumbers}
+	fields := strings.Fields(info)
+	if len(fields) < 1 {
+		return res
+	}
+	res.lang = fields[0]
+	for _, field := range fields[1:] {
+		switch {
+		case field == "linenos":
+			res.lineNumbers = true
+		case field == "nolinenos":
+			res.lineNumbers = false
+		case strings.HasPrefix(field, "hl="):
+			lines, err := parseLineRanges(strings.TrimPrefix(field, "hl="))
+			if err == nil {
+				res.lines = lines
+			}
+		}
+	}
+	return res
+}
+
+func (r *highlightRenderer) BlockCode(out *bytes.Buffer, text []byte, info string) {
+	cbi := parseCodeBlockInfo(info, r.hc.LineNumbers)
+	lexer := lexers.Get(cbi.lang)
+	if len(cbi.lang) < 1 || lexer == nil {
+		r.Renderer.BlockCode(out, text, info)
+		return
+	}
+	iterator, err := chroma.Coalesce(lexer).Tokenise(nil, string(text))
+	if err != nil {
+		r.Renderer.BlockCode(out, text, info)
+		return
+	}
+	formatter := chromahtml.New(
+		chromahtml.WithClasses(len(r.hc.CSSFile) > 0),
+		chromahtml.WithLineNumbers(cbi.lineNumbers),
+		chromahtml.HighlightLines(cbi.lines),
+	)
+	var b bytes.Buffer
+	err = formatter.Format(&b, r.hc.style(), iterator)
+	if err != nil {
+		r.Renderer.BlockCode(out, text, info)
+		return
+	}
+	if out.Len() > 0 {
+		out.WriteByte('\n') //Like blackfriday's own code blocks
+	}
+	out.Write(b.Bytes())
+	out.WriteByte('\n')
+}
+
+func writeHighlightCSS(hc highlightConfig, outputPath string) error {
+	err := os.MkdirAll(filepath.Dir(outputPath), 0775)
+	if err != nil {
+		return err
+	}
+	f, err := os.Create(outputPath)
+	if err != nil {
+		return err
+	}
+	err = chromahtml.New(chromahtml.WithClasses(true), chromahtml.WithLineNumbers(true)).WriteCSS(f, hc.style())
+	if err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+func processHighlightCSS(wg *sync.WaitGroup, blogPath string, ch chan<- error) {
+	defer wg.Done()
+	err := writeHighlightCSS(*conf.Highlight, filepath.Join(blogPath, filepath.FromSlash(conf.Highlight.CSSFile)))
+	if err != nil {
+		ch <- err
+	}
+}
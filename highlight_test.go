@@ -0,0 +1,88 @@
+package main
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+const codeMarkdown = "Some code:\n\n```go hl=2\npackage main\nfunc main() {}\n```\n"
+
+var parseCodeBlockInfoTests = []struct {
+	info        string
+	lineNumbers bool
+	output      codeBlockInfo
+}{
+	{"", false, codeBlockInfo{}},
+	{"go", true, codeBlockInfo{lang: "go", lineNumbers: true}},
+	{"go linenos hl=1,3-5", false, codeBlockInfo{"go", true, [][2]int{{1, 1}, {3, 5}}}},
+	{"go nolinenos hl=x", true, codeBlockInfo{lang: "go"}},
+}
+
+func TestParseCodeBlockInfo(t *testing.T) {
+	for _, test := range parseCodeBlockInfoTests {
+		res := parseCodeBlockInfo(test.info, test.lineNumbers)
+		if !reflect.DeepEqual(res, test.output) {
+			t.Errorf("Wrong info for '%s', expected %v, actual %v", test.info, test.output, res)
+		}
+	}
+}
+
+func TestRenderMarkdownPlain(t *testing.T) {
+	b, _ := renderMarkdown([]byte(codeMarkdown))
+	res := string(b)
+	if !strings.Contains(res, "<pre><code class=\"language-go\">package main") {
+		t.Errorf("Code highlighted without config:\n%s", res)
+	}
+}
+
+func TestRenderMarkdownHighlight(t *testing.T) {
+	conf.Highlight = &highlightConfig{}
+	b, _ := renderMarkdown([]byte(codeMarkdown))
+	res := string(b)
+	if !strings.Contains(res, "<p>Some code:</p>") || !strings.Contains(res, "style=\"color:") || strings.Contains(res, "language-go") {
+		t.Errorf("Wrong inline highlighting:\n%s", res)
+	}
+	if !strings.Contains(res, "background-color:") {
+		t.Errorf("Line 2 not highlighted:\n%s", res)
+	}
+
+	conf.Highlight = &highlightConfig{LineNumbers: true, CSSFile: "css/code.css"}
+	b, _ = renderMarkdown([]byte(codeMarkdown))
+	res = string(b)
+	if !strings.Contains(res, "class=\"chroma\"") || strings.Contains(res, "style=") || !strings.Contains(res, "class=\"line hl\"") || !strings.Contains(res, "class=\"ln\"") {
+		t.Errorf("Wrong class highlighting:\n%s", res)
+	}
+
+	b, _ = renderMarkdown([]byte("```notalanguage\nx\n```\n"))
+	res = string(b)
+	if !strings.Contains(res, "<pre><code class=\"language-notalanguage\">x") {
+		t.Errorf("Unknown language not left alone:\n%s", res)
+	}
+	conf = blogConfig{}
+}
+
+func TestHighlightConfigValidate(t *testing.T) {
+	if err := (highlightConfig{Style: "monokai"}).validate(); err != nil {
+		t.Errorf("Error (%s) for valid style.", err.Error())
+	}
+	if err := (highlightConfig{Style: "no-such-style"}).validate(); err == nil {
+		t.Errorf("No error for unknown style.")
+	}
+}
+
+func TestWriteHighlightCSS(t *testing.T) {
+	blogPath := setupArticlePath(t)
+	defer teardownArticlePath(t, blogPath)
+	outputPath := filepath.Join(blogPath, "css", "code.css")
+	err := writeHighlightCSS(highlightConfig{Style: "monokai"}, outputPath)
+	if err != nil {
+		t.Errorf("Error (%s) when all parameters valid.", err.Error())
+	}
+	b, _ := ioutil.ReadFile(outputPath)
+	if !strings.Contains(string(b), ".chroma") {
+		t.Errorf("No chroma classes in stylesheet:\n%s", b)
+	}
+}
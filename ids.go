@@ -0,0 +1,149 @@
+package main
+
+import (
+	"crypto/rand"
+	"fmt"
+	"log"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+const migrateIDsMode = "migrate-ids"
+const idSchemeTag = "tag"
+const idSchemeUUID = "uuid"
+const idSchemeURL = "url"
+
+func newUUID() (string, error) {
+	b := make([]byte, 16)
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", err
+	}
+	b[6] = (b[6] & 0x0f) | 0x40 //Version 4
+	b[8] = (b[8] & 0x3f) | 0x80 //RFC 4122 variant
+	return fmt.Sprintf("urn:uuid:%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:]), nil
+}
+
+func tagURI(published time.Time, slug string) (string, error) {
+	hostURL, err := url.Parse(hostRawURL)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("tag:%s,%s:%s", hostURL.Hostname(), published.Format("2006-01-02"), slug), nil
+}
+
+func newItemID(scheme string, published time.Time, slug, itemURL string) (string, error) {
+	switch scheme {
+	case idSchemeTag, "":
+		return tagURI(published, slug)
+	case idSchemeUUID:
+		return newUUID()
+	case idSchemeURL:
+		return itemURL, nil
+	default:
+		return "", fmt.Errorf("unsupported id scheme '%s'", scheme)
+	}
+}
+
+func itemIDs(itemList []jsfItem) map[string]bool {
+	res := make(map[string]bool)
+	for _, ji := range itemList {
+		if len(ji.ID) > 0 {
+			res[ji.ID] = true
+		}
+	}
+	return res
+}
+
+func uniqueItemID(id string, taken map[string]bool) string {
+	res := id
+	for i := 2; taken[res]; i++ {
+		res = id + "-" + strconv.Itoa(i) //Tag URIs repeat when two articles share a slug and a date
+	}
+	return res
+}
+
+func (plan *articlePlan) assignID(taken map[string]bool) error {
+	prevID := plan.prevItem.ID
+	switch {
+	case len(prevID) > 0 && idFrozen(plan.prevItem):
+		plan.id = prevID //Feed readers shouldn't see a renamed article as new
+		plan.meta.IDFrozen = plan.id == plan.url
+	case len(prevID) > 0:
+		plan.id = prevID //Not frozen by migrate-ids yet, but a rename mustn't change it either
+		plan.meta.IDFrozen = plan.id != plan.url
+	default:
+		id, err := newItemID(conf.IDScheme, plan.published, articleSlug(plan.meta.Slug, plan.title, plan.dir), plan.url)
+		if err != nil {
+			return err
+		}
+		plan.id = uniqueItemID(id, taken)
+		plan.meta.IDFrozen = plan.id == plan.url
+	}
+	taken[plan.id] = true
+	return nil
+}
+
+func idFrozen(ji jsfItem) bool {
+	return ji.ID != ji.URL || (ji.Blom != nil && ji.Blom.IDFrozen)
+}
+
+func findIDCollisions(itemList []jsfItem) error {
+	seen := make(map[string]string)
+	for _, ji := range itemList {
+		if prevDir, ok := seen[ji.ID]; ok {
+			return fmt.Errorf("id collision: '%s' is used by both '%s' and '%s', edit one of their item.json files", ji.ID, prevDir, ji.dir)
+		}
+		seen[ji.ID] = ji.dir
+	}
+	return nil
+}
+
+func migrateIDs(blogRelativePath string) (int, error) {
+	frozen := 0
+	articlePaths, err := findArticlePaths(blogRelativePath)
+	if err != nil {
+		return frozen, err
+	}
+	itemList := make([]jsfItem, len(articlePaths))
+	migrate := make([]bool, len(articlePaths))
+	for i, articlePath := range articlePaths {
+		itemList[i], _, err = getPreviousItem(articlePath)
+		if err != nil {
+			return frozen, err
+		}
+		itemList[i].dir, err = articleURLPath(blogRelativePath, articlePath)
+		if err != nil {
+			return frozen, err
+		}
+		if len(itemList[i].ID) > 0 && idFrozen(itemList[i]) {
+			continue
+		}
+		if len(itemList[i].URL) < 1 {
+			return frozen, fmt.Errorf("no id or url in '%s'", articlePath)
+		}
+		itemList[i].ID = itemList[i].URL
+		migrate[i] = true
+	}
+	err = findIDCollisions(itemList) //Before writing anything
+	if err != nil {
+		return frozen, err
+	}
+	for i, ji := range itemList {
+		if !migrate[i] {
+			continue
+		}
+		if ji.Blom == nil {
+			ji.Blom = &blomMeta{}
+		}
+		ji.Blom.IDFrozen = true
+		err = writeItemFile(ji, articlePaths[i])
+		if err != nil {
+			return frozen, err
+		}
+		log.Printf("Froze id of '%s' as '%s'", articlePaths[i], ji.ID)
+		frozen++
+	}
+	return frozen, nil
+}
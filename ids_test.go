@@ -0,0 +1,259 @@
+package main
+
+import (
+	"html/template"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestNewItemID(t *testing.T) {
+	published, _ := time.Parse("2006-01-02", "2017-06-10")
+	itemURL := hostRawURL + "/hello"
+
+	res, err := newItemID(idSchemeTag, published, "hello", itemURL)
+	if err != nil {
+		t.Errorf("Error (%s) for tag scheme", err.Error())
+	}
+	if res != "tag:ratan.blog,2017-06-10:hello" {
+		t.Errorf("Wrong tag URI, expected 'tag:ratan.blog,2017-06-10:hello', actual '%s'", res)
+	}
+
+	res, err = newItemID(idSchemeUUID, published, "hello", itemURL)
+	if err != nil {
+		t.Errorf("Error (%s) for uuid scheme", err.Error())
+	}
+	uuidPattern := regexp.MustCompile("^urn:uuid:[0-9a-f]{8}-[0-9a-f]{4}-4[0-9a-f]{3}-[89ab][0-9a-f]{3}-[0-9a-f]{12}$")
+	if !uuidPattern.MatchString(res) {
+		t.Errorf("Malformed UUID '%s'", res)
+	}
+
+	res, err = newItemID(idSchemeURL, published, "hello", itemURL)
+	if err != nil || res != itemURL {
+		t.Errorf("Wrong URL id, expected '%s', actual '%s'", itemURL, res)
+	}
+
+	_, err = newItemID("serial", published, "hello", itemURL)
+	if err == nil {
+		t.Errorf("No error for unsupported scheme")
+	}
+}
+
+func TestProcessArticleKeepsID(t *testing.T) {
+	tmpl := template.Must(template.New("Whatever").Parse("{{.Title}}"))
+	blogPath := setupArticlePath(t)
+	articlePath := filepath.Join(blogPath, "hello")
+	err := os.Mkdir(articlePath, 0777)
+	if err != nil {
+		t.Errorf("Error (%s) BEFORE STARTING TEST.", err.Error())
+	}
+	err = ioutil.WriteFile(filepath.Join(articlePath, contentFileHTML), []byte("<p>Hi</p>"), 0664)
+	if err != nil {
+		t.Errorf("Error (%s) BEFORE STARTING TEST.", err.Error())
+	}
+
+	first, err := processArticle(tmpl, blogPath, articlePath, articleFlags{title: "Hello"})
+	if err != nil {
+		t.Errorf("Error (%s) when all parameters valid.", err.Error())
+	}
+	expectedID := "tag:ratan.blog," + time.Now().Format("2006-01-02") + ":hello"
+	if first.ID != expectedID {
+		t.Errorf("Wrong new ID, expected '%s', actual '%s'", expectedID, first.ID)
+	}
+
+	conf.IDScheme = idSchemeUUID
+	second, err := processArticle(tmpl, blogPath, articlePath, articleFlags{})
+	if err != nil {
+		t.Errorf("Error (%s) when all parameters valid.", err.Error())
+	}
+	if second.ID != first.ID {
+		t.Errorf("ID changed between runs, expected '%s', actual '%s'", first.ID, second.ID)
+	}
+	conf = blogConfig{}
+	teardownArticlePath(t, blogPath)
+}
+
+func TestProcessArticleUnfrozenID(t *testing.T) {
+	tmpl := template.Must(template.New("Whatever").Parse("{{.Title}}"))
+	blogPath := setupArticlePath(t)
+	defer teardownArticlePath(t, blogPath)
+	articlePath := filepath.Join(blogPath, "renamed")
+	err := os.Mkdir(articlePath, 0777)
+	if err != nil {
+		t.Errorf("Error (%s) BEFORE STARTING TEST.", err.Error())
+	}
+	err = ioutil.WriteFile(filepath.Join(articlePath, contentFileHTML), []byte("<p>Hi</p>"), 0664)
+	if err != nil {
+		t.Errorf("Error (%s) BEFORE STARTING TEST.", err.Error())
+	}
+	oldURL := hostRawURL + "/original"
+	err = writeItemFile(jsfItem{ID: oldURL, URL: oldURL, Title: "Renamed", DatePublished: "2017-06-10T10:00:00Z"}, articlePath)
+	if err != nil {
+		t.Errorf("Error (%s) BEFORE STARTING TEST.", err.Error())
+	}
+
+	ji, err := processArticle(tmpl, blogPath, articlePath, articleFlags{})
+	if err != nil {
+		t.Errorf("Error (%s) when all parameters valid.", err.Error())
+	}
+	if ji.ID != oldURL || ji.URL == oldURL {
+		t.Errorf("Unfrozen URL id changed with the URL, expected '%s', actual '%s'", oldURL, ji.ID)
+	}
+	prevItem, _, err := getPreviousItem(articlePath)
+	if err != nil || prevItem.Blom == nil || !prevItem.Blom.IDFrozen {
+		t.Errorf("Kept id not frozen in item file: %v, %v", prevItem.Blom, err)
+	}
+
+	err = writeItemFile(jsfItem{ID: oldURL, URL: oldURL, Title: "Renamed", DatePublished: "2017-06-10T10:00:00Z", Blom: &blomMeta{IDFrozen: true}}, articlePath)
+	if err != nil {
+		t.Errorf("Error (%s) BEFORE STARTING TEST.", err.Error())
+	}
+	ji, err = processArticle(tmpl, blogPath, articlePath, articleFlags{})
+	if err != nil {
+		t.Errorf("Error (%s) when all parameters valid.", err.Error())
+	}
+	if ji.ID != oldURL {
+		t.Errorf("Frozen URL id changed, expected '%s', actual '%s'", oldURL, ji.ID)
+	}
+}
+
+func TestProcessArticleUniqueID(t *testing.T) {
+	tmpl := template.Must(template.New("Whatever").Parse("{{.Title}}"))
+	blogPath := setupArticlePath(t)
+	defer teardownArticlePath(t, blogPath)
+	articlePaths := []string{filepath.Join(blogPath, "notes", "hello"), filepath.Join(blogPath, "2017", "hello")}
+	ids := make(map[string]bool)
+	for _, articlePath := range articlePaths {
+		err := os.MkdirAll(articlePath, 0777)
+		if err != nil {
+			t.Errorf("Error (%s) BEFORE STARTING TEST.", err.Error())
+		}
+		err = ioutil.WriteFile(filepath.Join(articlePath, contentFileHTML), []byte("<p>Hi</p>"), 0664)
+		if err != nil {
+			t.Errorf("Error (%s) BEFORE STARTING TEST.", err.Error())
+		}
+		ji, err := processArticle(tmpl, blogPath, articlePath, articleFlags{title: "Hello"})
+		if err != nil {
+			t.Errorf("Error (%s) when all parameters valid.", err.Error())
+		}
+		ids[ji.ID] = true
+	}
+	expectedID := "tag:ratan.blog," + time.Now().Format("2006-01-02") + ":hello"
+	if len(ids) != 2 || !ids[expectedID] || !ids[expectedID+"-2"] {
+		t.Errorf("IDs not unique, expected '%s' and '%s-2', actual %v", expectedID, expectedID, ids)
+	}
+}
+
+func TestBuildItemListUniqueID(t *testing.T) {
+	tmpl := template.Must(template.New("Whatever").Parse("{{.Title}}"))
+	conf.SlugFrom = slugFromTitle
+	defer func() { conf = blogConfig{} }()
+	blogPath, _ := setupBlog(t, []byte(`{"title": "Hello", "date_published": "2017-06-10T10:00:00Z"}`), []byte("Hi"), 5, 5)
+	defer teardownArticlePath(t, blogPath)
+
+	itemList, err := buildItemList(tmpl, blogPath)
+	if err != nil {
+		t.Fatalf("Error (%s) when all parameters valid.", err.Error())
+	}
+	ids := make(map[string]bool)
+	for _, ji := range itemList {
+		ids[ji.ID] = true
+	}
+	expectedID := "tag:ratan.blog,2017-06-10:hello"
+	if len(ids) != 5 || !ids[expectedID] || !ids[expectedID+"-5"] {
+		t.Errorf("IDs not unique, expected '%s' to '%s-5', actual %v", expectedID, expectedID, ids)
+	}
+}
+
+func TestFindIDCollisions(t *testing.T) {
+	itemList := []jsfItem{{ID: "a", dir: "one"}, {ID: "b", dir: "two"}}
+	if err := findIDCollisions(itemList); err != nil {
+		t.Errorf("Error (%s) with unique IDs", err.Error())
+	}
+	itemList = append(itemList, jsfItem{ID: "a", dir: "three"})
+	if err := findIDCollisions(itemList); err == nil {
+		t.Errorf("No error for ID collision")
+	}
+}
+
+func setupMigrateBlog(t *testing.T, items []jsfItem) (string, []string) {
+	blogPath := setupArticlePath(t)
+	articlePaths := make([]string, len(items))
+	for i := range items {
+		articlePaths[i] = filepath.Join(blogPath, strings.TrimPrefix(items[i].URL, hostRawURL+"/"))
+		err := os.Mkdir(articlePaths[i], 0777)
+		if err != nil {
+			t.Errorf("Error (%s) BEFORE STARTING TEST.", err.Error())
+		}
+		err = writeItemFile(items[i], articlePaths[i])
+		if err != nil {
+			t.Errorf("Error (%s) BEFORE STARTING TEST.", err.Error())
+		}
+	}
+	return blogPath, articlePaths
+}
+
+func TestMigrateIDs(t *testing.T) {
+	items := []jsfItem{
+		{URL: hostRawURL + "/missing"},
+		{URL: hostRawURL + "/legacy", ID: hostRawURL + "/legacy"},
+		{URL: hostRawURL + "/current", ID: "tag:ratan.blog,2017-06-10:current"},
+	}
+	blogPath, articlePaths := setupMigrateBlog(t, items)
+	defer teardownArticlePath(t, blogPath)
+
+	frozen, err := migrateIDs(blogPath)
+	if err != nil {
+		t.Errorf("Error (%s) when all parameters valid.", err.Error())
+	}
+	if frozen != 2 {
+		t.Errorf("Wrong frozen count, expected 2, actual %v", frozen)
+	}
+	for i, articlePath := range articlePaths {
+		ji, _, err := getPreviousItem(articlePath)
+		if err != nil {
+			t.Errorf("Error (%s) reading migrated item", err.Error())
+		}
+		expectedID := items[i].ID
+		if len(expectedID) < 1 {
+			expectedID = items[i].URL
+		}
+		if ji.ID != expectedID {
+			t.Errorf("Wrong ID at index %v, expected '%s', actual '%s'", i, expectedID, ji.ID)
+		}
+		if !idFrozen(ji) {
+			t.Errorf("ID at index %v not frozen: %v", i, ji.Blom)
+		}
+	}
+
+	frozen, err = migrateIDs(blogPath)
+	if err != nil || frozen != 0 {
+		t.Errorf("Second migration froze %v ids, error %v", frozen, err)
+	}
+}
+
+func TestMigrateIDsCollision(t *testing.T) {
+	items := []jsfItem{
+		{URL: hostRawURL + "/first", ID: "tag:ratan.blog,2017-06-10:hello"},
+		{URL: hostRawURL + "/second", ID: "tag:ratan.blog,2017-06-10:hello"},
+		{URL: hostRawURL + "/legacy", ID: hostRawURL + "/legacy"},
+	}
+	blogPath, articlePaths := setupMigrateBlog(t, items)
+	defer teardownArticlePath(t, blogPath)
+
+	_, err := migrateIDs(blogPath)
+	if err == nil {
+		t.Errorf("No error for ID collision")
+	}
+	ji, _, err := getPreviousItem(articlePaths[2])
+	if err != nil {
+		t.Errorf("Error (%s) reading item", err.Error())
+	}
+	if idFrozen(ji) {
+		t.Errorf("ID frozen despite collision")
+	}
+}
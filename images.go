@@ -0,0 +1,316 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"fmt"
+	"golang.org/x/image/draw"
+	"golang.org/x/net/html"
+	"image"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const imageDir = "images"
+const defaultThumbnailWidth = 320
+const defaultImageQuality = 85
+const defaultImageSizes = "100vw"
+
+var defaultImageWidths = []int{480, 800, 1200}
+
+var imageFormats = map[string]string{"image/jpeg": "jpg", "image/png": "png"}
+
+type imageConfig struct {
+	Widths         []int  `json:"widths"`
+	ThumbnailWidth int    `json:"thumbnail_width"`
+	Quality        int    `json:"quality"` //JPEG only
+	Sizes          string `json:"sizes"`
+}
+
+type imageVariant struct {
+	URL    string
+	Width  int
+	Height int
+}
+
+type responsiveImage struct {
+	Width     int
+	Height    int
+	Variants  []imageVariant //Narrowest first, without the original
+	Thumbnail imageVariant
+}
+
+func (ic imageConfig) widths() []int {
+	if ic.Widths == nil {
+		return defaultImageWidths
+	}
+	return ic.Widths
+}
+
+func (ic imageConfig) thumbnailWidth() int {
+	if ic.ThumbnailWidth < 1 {
+		return defaultThumbnailWidth
+	}
+	return ic.ThumbnailWidth
+}
+
+func (ic imageConfig) quality() int {
+	if ic.Quality < 1 {
+		return defaultImageQuality
+	}
+	return ic.Quality
+}
+
+func (ic imageConfig) sizes() string {
+	if len(ic.Sizes) < 1 {
+		return defaultImageSizes
+	}
+	return ic.Sizes
+}
+
+func (ic imageConfig) validate() error {
+	for _, w := range ic.Widths {
+		if w < 1 {
+			return fmt.Errorf("invalid image width %d", w)
+		}
+	}
+	if ic.Quality < 0 || ic.Quality > 100 {
+		return fmt.Errorf("invalid image quality %d", ic.Quality)
+	}
+	return nil
+}
+
+func imageHash(content []byte, quality int) string {
+	h := sha256.New()
+	h.Write(content)
+	fmt.Fprintf(h, "\nquality=%d", quality) //A new quality needs new files
+	return fmt.Sprintf("%x", h.Sum(nil)[:8])
+}
+
+func scaledHeight(width, height, newWidth int) int {
+	res := (height*newWidth + width/2) / width
+	if res < 1 {
+		return 1
+	}
+	return res
+}
+
+func resizeImage(img image.Image, width int) image.Image {
+	b := img.Bounds()
+	dst := image.NewRGBA(image.Rect(0, 0, width, scaledHeight(b.Dx(), b.Dy(), width)))
+	draw.CatmullRom.Scale(dst, dst.Bounds(), img, b, draw.Src, nil)
+	return dst
+}
+
+func encodeImage(w io.Writer, img image.Image, ext string, quality int) error {
+	if ext == "png" {
+		return png.Encode(w, img)
+	}
+	return jpeg.Encode(w, img, &jpeg.Options{Quality: quality})
+}
+
+func writeImageVariant(img image.Image, ext string, width, quality int, outputPath string) error {
+	f, err := ioutil.TempFile(filepath.Dir(outputPath), ".tmp-")
+	if err != nil {
+		return err
+	}
+	err = encodeImage(f, resizeImage(img, width), ext, quality)
+	if err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return err
+	}
+	err = f.Close()
+	if err != nil {
+		os.Remove(f.Name())
+		return err
+	}
+	return os.Rename(f.Name(), outputPath) //Articles sharing an image run at the same time
+}
+
+func variantWidths(ic imageConfig, width int) []int {
+	res := make([]int, 0)
+	seen := make(map[int]bool)
+	for _, w := range ic.widths() {
+		if w < width && !seen[w] {
+			res = append(res, w)
+			seen[w] = true
+		}
+	}
+	sort.Ints(res)
+	return res
+}
+
+func makeResponsiveImage(content []byte, mimeType, blogPath string, ic imageConfig) (responsiveImage, error) {
+	var ri responsiveImage
+	ext := imageFormats[mimeType]
+	cfg, _, err := image.DecodeConfig(bytes.NewReader(content))
+	if err != nil {
+		return ri, err
+	}
+	ri.Width, ri.Height = cfg.Width, cfg.Height
+	orientation := imageOrientation(content, mimeType)
+	if orientation >= 5 {
+		ri.Width, ri.Height = cfg.Height, cfg.Width //Turned on its side
+	}
+	if ri.Width < 1 || ri.Height < 1 {
+		return ri, fmt.Errorf("empty image")
+	}
+
+	thumbWidth := ic.thumbnailWidth()
+	if thumbWidth > ri.Width {
+		thumbWidth = ri.Width
+	}
+	widths := append(variantWidths(ic, ri.Width), thumbWidth)
+	hash := imageHash(content, ic.quality())
+	outputDir := filepath.Join(blogPath, imageDir)
+	err = os.MkdirAll(outputDir, 0775)
+	if err != nil {
+		return ri, err
+	}
+	var img image.Image
+	for i, w := range widths {
+		name := fmt.Sprintf("%s-%d.%s", hash, w, ext)
+		v := imageVariant{hostRawURL + "/" + imageDir + "/" + name, w, scaledHeight(ri.Width, ri.Height, w)}
+		if i == len(widths)-1 {
+			ri.Thumbnail = v
+		} else {
+			ri.Variants = append(ri.Variants, v)
+		}
+		outputPath := filepath.Join(outputDir, name)
+		if _, err := os.Stat(outputPath); err == nil {
+			continue //Made by an earlier build
+		}
+		if img == nil {
+			img, _, err = image.Decode(bytes.NewReader(content))
+			if err != nil {
+				return ri, err
+			}
+			img = orientImage(img, orientation) //Resized copies have no EXIF to say how to turn them
+		}
+		err = writeImageVariant(img, ext, w, ic.quality(), outputPath)
+		if err != nil {
+			return ri, err
+		}
+	}
+	return ri, nil
+}
+
+func attachmentFilePath(articlePath string, ja jsfAttachment) (string, error) {
+	if len(ja.path) < 1 {
+		return "", fmt.Errorf("no source for attachment '%s'", ja.URL)
+	}
+	return filepath.Join(articlePath, attachmentDir, filepath.FromSlash(ja.path)), nil
+}
+
+func responsiveImages(attachments []jsfAttachment, articlePath, blogPath string, ic imageConfig) (map[string]responsiveImage, error) {
+	res := make(map[string]responsiveImage)
+	for _, ja := range attachments {
+		if _, ok := imageFormats[ja.MIMEType]; !ok {
+			continue
+		}
+		attachPath, err := attachmentFilePath(articlePath, ja)
+		if err != nil {
+			return nil, err
+		}
+		content, err := ioutil.ReadFile(attachPath)
+		if err != nil {
+			return nil, err
+		}
+		ri, err := makeResponsiveImage(content, ja.MIMEType, blogPath, ic)
+		if err != nil {
+			return nil, fmt.Errorf("image '%s': %s", attachPath, err.Error())
+		}
+		res[ja.URL] = ri
+	}
+	return res, nil
+}
+
+func (ri responsiveImage) srcset(originalSrc string) string {
+	candidates := make([]string, 0, len(ri.Variants)+1)
+	for _, v := range ri.Variants {
+		candidates = append(candidates, v.URL+" "+strconv.Itoa(v.Width)+"w")
+	}
+	candidates = append(candidates, originalSrc+" "+strconv.Itoa(ri.Width)+"w")
+	return strings.Join(candidates, ", ")
+}
+
+func responsiveAttrs(tok html.Token, ri responsiveImage, src, sizes string) []html.Attribute {
+	attrs := tok.Attr
+	if !hasAttr(tok, "srcset") {
+		attrs = append(attrs, html.Attribute{Key: "srcset", Val: ri.srcset(src)})
+		if !hasAttr(tok, "sizes") {
+			attrs = append(attrs, html.Attribute{Key: "sizes", Val: sizes})
+		}
+	}
+	if !hasAttr(tok, "width") && !hasAttr(tok, "height") {
+		attrs = append(attrs, html.Attribute{Key: "width", Val: strconv.Itoa(ri.Width)})
+		attrs = append(attrs, html.Attribute{Key: "height", Val: strconv.Itoa(ri.Height)})
+	}
+	if !hasAttr(tok, "loading") {
+		attrs = append(attrs, html.Attribute{Key: "loading", Val: "lazy"})
+	}
+	return attrs
+}
+
+func addResponsiveImages(content []byte, pageURL string, images map[string]responsiveImage, sizes string) ([]byte, error) {
+	if len(images) < 1 {
+		return content, nil
+	}
+	base, err := pageBase(pageURL)
+	if err != nil {
+		return nil, err
+	}
+	var b bytes.Buffer
+	z := html.NewTokenizer(bytes.NewReader(content))
+	for {
+		tt := z.Next()
+		if tt == html.ErrorToken {
+			return b.Bytes(), nil
+		}
+		raw := append([]byte(nil), z.Raw()...) //Token() unescapes in place
+		if tt != html.StartTagToken && tt != html.SelfClosingTagToken {
+			b.Write(raw)
+			continue
+		}
+		tok := z.Token()
+		ri, ok := responsiveImage{}, false
+		src := ""
+		if tok.Data == "img" {
+			for _, attr := range tok.Attr {
+				if attr.Key == "src" {
+					src = attr.Val
+					ri, ok = images[resolveLink(base, attr.Val)]
+				}
+			}
+		}
+		if !ok {
+			b.Write(raw)
+			continue
+		}
+		writeStartTag(&b, tok, responsiveAttrs(tok, ri, src, sizes))
+	}
+}
+
+func isDerivedImage(imageURL string) bool {
+	return strings.HasPrefix(imageURL, hostRawURL+"/"+imageDir+"/")
+}
+
+func itemImage(current string, attachments []jsfAttachment, images map[string]responsiveImage) string {
+	if len(current) > 0 && !isDerivedImage(current) {
+		return current //Set by the author
+	}
+	for _, ja := range attachments {
+		if ri, ok := images[ja.URL]; ok {
+			return ri.Thumbnail.URL
+		}
+	}
+	return ""
+}
@@ -0,0 +1,224 @@
+package main
+
+import (
+	"bytes"
+	"html/template"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func testImageBytes(t *testing.T, width, height int, format string) []byte {
+	img := image.NewRGBA(image.Rect(0, 0, width, height))
+	for x := 0; x < width; x++ {
+		for y := 0; y < height; y++ {
+			img.Set(x, y, color.RGBA{uint8(x), uint8(y), 128, 255})
+		}
+	}
+	var b bytes.Buffer
+	var err error
+	if format == "png" {
+		err = png.Encode(&b, img)
+	} else {
+		err = jpeg.Encode(&b, img, nil)
+	}
+	if err != nil {
+		t.Errorf("Error (%s) BEFORE STARTING TEST.", err.Error())
+	}
+	return b.Bytes()
+}
+
+var variantWidthsTests = []struct {
+	widths   []int
+	width    int
+	expected []int
+}{
+	{nil, 1200, []int{480, 800}},
+	{nil, 2000, []int{480, 800, 1200}},
+	{nil, 300, []int{}},
+	{[]int{640, 320, 640}, 1000, []int{320, 640}},
+}
+
+func TestVariantWidths(t *testing.T) {
+	for _, test := range variantWidthsTests {
+		res := variantWidths(imageConfig{Widths: test.widths}, test.width)
+		if !reflect.DeepEqual(res, test.expected) {
+			t.Errorf("Wrong widths for %v at %d, expected %v, actual %v", test.widths, test.width, test.expected, res)
+		}
+	}
+}
+
+func TestImageConfigValidate(t *testing.T) {
+	if err := (imageConfig{Widths: []int{480}, Quality: 90}).validate(); err != nil {
+		t.Errorf("Error (%s) when all parameters valid.", err.Error())
+	}
+	if err := (imageConfig{Widths: []int{0}}).validate(); err == nil {
+		t.Errorf("No error for zero width")
+	}
+	if err := (imageConfig{Quality: 101}).validate(); err == nil {
+		t.Errorf("No error for quality over 100")
+	}
+}
+
+func TestMakeResponsiveImage(t *testing.T) {
+	blogPath := setupArticlePath(t)
+	defer teardownArticlePath(t, blogPath)
+	content := testImageBytes(t, 1200, 600, "jpeg")
+	ic := imageConfig{}
+
+	ri, err := makeResponsiveImage(content, "image/jpeg", blogPath, ic)
+	if err != nil {
+		t.Errorf("Error (%s) when all parameters valid.", err.Error())
+	}
+	if ri.Width != 1200 || ri.Height != 600 {
+		t.Errorf("Wrong size, expected 1200x600, actual %dx%d", ri.Width, ri.Height)
+	}
+	if len(ri.Variants) != 2 {
+		t.Fatalf("Wrong number of variants, expected 2, actual %d", len(ri.Variants))
+	}
+	hash := imageHash(content, defaultImageQuality)
+	expected := []imageVariant{
+		{hostRawURL + "/images/" + hash + "-480.jpg", 480, 240},
+		{hostRawURL + "/images/" + hash + "-800.jpg", 800, 400},
+	}
+	if !reflect.DeepEqual(ri.Variants, expected) {
+		t.Errorf("Wrong variants, expected %v, actual %v", expected, ri.Variants)
+	}
+	expectedThumb := imageVariant{hostRawURL + "/images/" + hash + "-320.jpg", 320, 160}
+	if ri.Thumbnail != expectedThumb {
+		t.Errorf("Wrong thumbnail, expected %v, actual %v", expectedThumb, ri.Thumbnail)
+	}
+	for _, w := range []string{"480", "800", "320"} {
+		f, err := os.Open(filepath.Join(blogPath, imageDir, hash+"-"+w+".jpg"))
+		if err != nil {
+			t.Errorf("Error (%s) opening variant", err.Error())
+			continue
+		}
+		cfg, format, err := image.DecodeConfig(f)
+		f.Close()
+		if err != nil || format != "jpeg" || strconv.Itoa(cfg.Width) != w {
+			t.Errorf("Wrong variant %s: %v %s %v", w, cfg, format, err)
+		}
+	}
+
+	variantPath := filepath.Join(blogPath, imageDir, hash+"-480.jpg")
+	err = ioutil.WriteFile(variantPath, []byte("cached"), 0664)
+	if err != nil {
+		t.Errorf("Error (%s) BEFORE STARTING TEST.", err.Error())
+	}
+	_, err = makeResponsiveImage(content, "image/jpeg", blogPath, ic)
+	if err != nil {
+		t.Errorf("Error (%s) when all parameters valid.", err.Error())
+	}
+	cached, _ := ioutil.ReadFile(variantPath)
+	if string(cached) != "cached" {
+		t.Errorf("Cached variant was regenerated")
+	}
+}
+
+func TestMakeResponsiveImageSmall(t *testing.T) {
+	blogPath := setupArticlePath(t)
+	defer teardownArticlePath(t, blogPath)
+	ri, err := makeResponsiveImage(testImageBytes(t, 100, 50, "png"), "image/png", blogPath, imageConfig{})
+	if err != nil {
+		t.Errorf("Error (%s) when all parameters valid.", err.Error())
+	}
+	if len(ri.Variants) != 0 {
+		t.Errorf("Small image was given variants: %v", ri.Variants)
+	}
+	if ri.Thumbnail.Width != 100 || !strings.HasSuffix(ri.Thumbnail.URL, "-100.png") {
+		t.Errorf("Wrong thumbnail for small image: %v", ri.Thumbnail)
+	}
+}
+
+func TestAddResponsiveImages(t *testing.T) {
+	ri := responsiveImage{
+		Width:    1200,
+		Height:   600,
+		Variants: []imageVariant{{"http://ratan.blog/images/abc-480.jpg", 480, 240}},
+	}
+	images := map[string]responsiveImage{"http://ratan.blog/trip/attachments/1200.jpg": ri}
+	content := "<p>Look</p><img src=\"attachments/1200.jpg\" alt=\"A &amp; B\"><img src=\"other.jpg\"><img src=\"attachments/1200.jpg\" width=\"600\" loading=\"eager\"/>"
+	expected := "<p>Look</p>" +
+		"<img src=\"attachments/1200.jpg\" alt=\"A &amp; B\" srcset=\"http://ratan.blog/images/abc-480.jpg 480w, attachments/1200.jpg 1200w\" sizes=\"100vw\" width=\"1200\" height=\"600\" loading=\"lazy\">" +
+		"<img src=\"other.jpg\">" +
+		"<img src=\"attachments/1200.jpg\" width=\"600\" loading=\"eager\" srcset=\"http://ratan.blog/images/abc-480.jpg 480w, attachments/1200.jpg 1200w\" sizes=\"100vw\" />"
+	res, err := addResponsiveImages([]byte(content), "http://ratan.blog/trip", images, defaultImageSizes)
+	if err != nil {
+		t.Errorf("Error (%s) when all parameters valid.", err.Error())
+	}
+	if string(res) != expected {
+		t.Errorf("Wrong content, expected '%s', actual '%s'", expected, res)
+	}
+}
+
+var itemImageTests = []struct {
+	current  string
+	expected string
+}{
+	{"", "http://ratan.blog/images/abc-320.jpg"},
+	{"http://ratan.blog/images/old-320.jpg", "http://ratan.blog/images/abc-320.jpg"},
+	{"http://ratan.blog/cover.png", "http://ratan.blog/cover.png"},
+}
+
+func TestItemImage(t *testing.T) {
+	attachments := []jsfAttachment{{URL: "http://ratan.blog/trip/attachments/notes.txt"}, {URL: "http://ratan.blog/trip/attachments/1200.jpg"}}
+	images := map[string]responsiveImage{"http://ratan.blog/trip/attachments/1200.jpg": {Thumbnail: imageVariant{URL: "http://ratan.blog/images/abc-320.jpg"}}}
+	for _, test := range itemImageTests {
+		res := itemImage(test.current, attachments, images)
+		if res != test.expected {
+			t.Errorf("Wrong image for '%s', expected '%s', actual '%s'", test.current, test.expected, res)
+		}
+	}
+}
+
+func TestProcessArticleImages(t *testing.T) {
+	conf.Images = &imageConfig{Widths: []int{400}}
+	defer func() { conf = blogConfig{} }()
+	tmpl := template.Must(template.New("Whatever").Parse("{{.ContentHTML}}"))
+	blogPath := setupArticlePath(t)
+	defer teardownArticlePath(t, blogPath)
+	articlePath := filepath.Join(blogPath, "trip")
+	err := os.MkdirAll(filepath.Join(articlePath, attachmentDir), 0777)
+	if err != nil {
+		t.Errorf("Error (%s) BEFORE STARTING TEST.", err.Error())
+	}
+	content := testImageBytes(t, 800, 400, "jpeg")
+	err = ioutil.WriteFile(filepath.Join(articlePath, attachmentDir, "photo.jpg"), content, 0664)
+	if err != nil {
+		t.Errorf("Error (%s) BEFORE STARTING TEST.", err.Error())
+	}
+	err = ioutil.WriteFile(filepath.Join(articlePath, contentFileHTML), []byte("<img src=\"attachments/photo.jpg\">"), 0664)
+	if err != nil {
+		t.Errorf("Error (%s) BEFORE STARTING TEST.", err.Error())
+	}
+
+	ji, err := processArticle(tmpl, blogPath, articlePath, articleFlags{title: "Trip"})
+	if err != nil {
+		t.Errorf("Error (%s) when all parameters valid.", err.Error())
+	}
+	hash := imageHash(content, defaultImageQuality)
+	expected := "<img src=\"attachments/photo.jpg\" srcset=\"" + hostRawURL + "/images/" + hash + "-400.jpg 400w, attachments/photo.jpg 800w\" sizes=\"100vw\" width=\"800\" height=\"400\" loading=\"lazy\">"
+	if ji.ContentHTML != expected {
+		t.Errorf("Wrong content, expected '%s', actual '%s'", expected, ji.ContentHTML)
+	}
+	page, _ := ioutil.ReadFile(filepath.Join(articlePath, finalWebpageFile))
+	if string(page) != expected {
+		t.Errorf("Wrong page, expected '%s', actual '%s'", expected, page)
+	}
+	expectedImage := hostRawURL + "/images/" + hash + "-320.jpg"
+	if ji.Image != expectedImage {
+		t.Errorf("Wrong item image, expected '%s', actual '%s'", expectedImage, ji.Image)
+	}
+	if _, err := os.Stat(filepath.Join(blogPath, imageDir, hash+"-400.jpg")); err != nil {
+		t.Errorf("Missing variant: %s", err.Error())
+	}
+}
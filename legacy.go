@@ -0,0 +1,173 @@
+package main
+
+import (
+	"encoding/xml"
+	"github.com/gorilla/feeds"
+	"io/ioutil"
+	"strconv"
+	"time"
+)
+
+const atomNS = "http://www.w3.org/2005/Atom"
+const contentNS = "http://purl.org/rss/1.0/modules/content/"
+const dcNS = "http://purl.org/dc/elements/1.1/"
+
+//gorilla/feeds has room for one author and one category, the wrappers below hold the rest
+
+type atomCategory struct {
+	Term string `xml:"term,attr"`
+}
+
+type atomEntry struct {
+	*feeds.AtomEntry
+	Authors    []*feeds.AtomAuthor `xml:"author"`
+	Categories []atomCategory      `xml:"category"`
+}
+
+type atomFeed struct {
+	*feeds.AtomFeed
+	Links   []feeds.AtomLink    `xml:"link"`
+	Authors []*feeds.AtomAuthor `xml:"author"`
+	Entries []atomEntry         `xml:"entry"`
+}
+
+type rssLink struct {
+	Href string `xml:"href,attr"`
+	Rel  string `xml:"rel,attr"`
+	Type string `xml:"type,attr"`
+}
+
+type rssItem struct {
+	*feeds.RssItem
+	Creators   []string `xml:"dc:creator"`
+	Categories []string `xml:"category"`
+}
+
+type rssChannel struct {
+	*feeds.RssFeed
+	SelfLink rssLink   `xml:"atom:link"`
+	Items    []rssItem `xml:"item"`
+}
+
+type rssFeed struct {
+	*feeds.RssFeedXml
+	AtomNS  string     `xml:"xmlns:atom,attr"`
+	DCNS    string     `xml:"xmlns:dc,attr"`
+	Channel rssChannel `xml:"channel"`
+}
+
+func newestModified(itemList []jsfItem) time.Time {
+	var res time.Time
+	for _, ji := range itemList {
+		modified, _ := time.Parse(time.RFC3339, ji.DateModified)
+		if modified.After(res) {
+			res = modified
+		}
+	}
+	if res.IsZero() {
+		return buildTime()
+	}
+	return res
+}
+
+func atomAuthors(authors []jsfAuthor) []*feeds.AtomAuthor {
+	res := make([]*feeds.AtomAuthor, 0, len(authors))
+	for _, author := range authors {
+		res = append(res, &feeds.AtomAuthor{AtomPerson: feeds.AtomPerson{Name: author.Name, Uri: author.URL}})
+	}
+	return res
+}
+
+func feedAuthors(scope feedScope) []jsfAuthor {
+	if len(conf.Authors) > 0 {
+		return conf.Authors
+	}
+	return []jsfAuthor{{Name: scope.Title}} //Atom needs an author, for the feed or every entry
+}
+
+func wrapAtomEntry(ae *feeds.AtomEntry, ji jsfItem) atomEntry {
+	ae.Published = ji.DatePublished
+	ae.Author = nil
+	for i, ja := range ji.Attachments {
+		if i < 1 {
+			continue //Already the enclosure from fromJsfItem
+		}
+		ae.Links = append(ae.Links, feeds.AtomLink{Href: ja.URL, Rel: "enclosure", Type: ja.MIMEType, Length: strconv.FormatInt(ja.SizeInBytes, 10)})
+	}
+	res := atomEntry{AtomEntry: ae, Authors: atomAuthors(ji.Authors)}
+	for _, tag := range ji.Tags {
+		res.Categories = append(res.Categories, atomCategory{Term: tag})
+	}
+	return res
+}
+
+func wrapAtomFeed(gf *feeds.Feed, itemList []jsfItem, scope feedScope) atomFeed {
+	af := atomFeed{AtomFeed: (&feeds.Atom{Feed: gf}).AtomFeed()}
+	af.Subtitle = conf.Description
+	af.Icon = conf.Icon
+	af.Author = nil
+	af.Authors = atomAuthors(feedAuthors(scope))
+	af.Entries = make([]atomEntry, len(itemList))
+	for i, ji := range itemList {
+		af.Entries[i] = wrapAtomEntry(af.AtomFeed.Entries[i], ji)
+	}
+	return af
+}
+
+func makeAtomFeed(itemList []jsfItem, scope feedScope) (atomFeed, error) {
+	gf, err := makeLegacyFeed(itemList, scope)
+	if err != nil {
+		return atomFeed{}, err
+	}
+	selfURL, err := scope.resolve(atomPath)
+	if err != nil {
+		return atomFeed{}, err
+	}
+	af := wrapAtomFeed(&gf, itemList, scope)
+	af.Links = []feeds.AtomLink{{Href: gf.Link.Href, Rel: "alternate", Type: "text/html"}, {Href: selfURL, Rel: "self", Type: "application/atom+xml"}}
+	return af, nil
+}
+
+func rssDate(rfc3339 string) string {
+	t, _ := time.Parse(time.RFC3339, rfc3339)
+	return t.Format(time.RFC1123Z)
+}
+
+func wrapRssItem(ri *feeds.RssItem, ji jsfItem) rssItem {
+	ri.Author = "" //RSS wants an email address here, the names go in dc:creator
+	res := rssItem{RssItem: ri, Categories: ji.Tags}
+	for _, author := range ji.Authors {
+		res.Creators = append(res.Creators, author.Name)
+	}
+	return res
+}
+
+func makeRssFeed(itemList []jsfItem, scope feedScope) (rssFeed, error) {
+	gf, err := makeLegacyFeed(itemList, scope)
+	if err != nil {
+		return rssFeed{}, err
+	}
+	selfURL, err := scope.resolve(rssPath)
+	if err != nil {
+		return rssFeed{}, err
+	}
+	rss := &feeds.Rss{Feed: &gf}
+	rf := rssFeed{RssFeedXml: rss.FeedXml().(*feeds.RssFeedXml), AtomNS: atomNS, DCNS: dcNS}
+	rf.Channel.RssFeed = rf.RssFeedXml.Channel
+	rf.Channel.ManagingEditor = "" //Also an email address, which the blog doesn't have
+	rf.Channel.Language = conf.Language
+	rf.Channel.SelfLink = rssLink{Href: selfURL, Rel: "self", Type: "application/rss+xml"}
+	rf.Channel.Items = make([]rssItem, len(itemList))
+	for i, ji := range itemList {
+		rf.Channel.Items[i] = wrapRssItem(rf.Channel.RssFeed.Items[i], ji)
+	}
+	return rf, nil
+}
+
+func writeXML(v interface{}, outputPath string) error {
+	b, err := xml.MarshalIndent(v, "", "\t")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(outputPath, append([]byte(xml.Header), b...), 0664)
+}
@@ -0,0 +1,147 @@
+package main
+
+import (
+	"encoding/xml"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func legacyTestItems() []jsfItem {
+	itemList := make([]jsfItem, 3)
+	for i := range itemList {
+		itemList[i].URL = hostRawURL + "/" + strconv.Itoa(i)
+		itemList[i].ID = "tag:ratan.blog,2017-06-10:" + strconv.Itoa(i)
+		itemList[i].Title = "Title " + strconv.Itoa(i)
+		itemList[i].ContentHTML = strconv.Itoa(i * 10)
+		itemList[i].DatePublished = "2017-06-1" + strconv.Itoa(i) + "T10:00:00Z"
+		itemList[i].DateModified = itemList[i].DatePublished
+	}
+	itemList[1].DateModified = "2018-01-02T03:04:05Z"
+	itemList[0].Tags = []string{"Tag1", "Tag2"}
+	itemList[0].Authors = []jsfAuthor{{Name: "Item Author", URL: "https://example.com/"}}
+	itemList[0].Attachments = []jsfAttachment{
+		{URL: hostRawURL + "/0/a.mp3", MIMEType: "audio/mpeg", SizeInBytes: 1234},
+		{URL: hostRawURL + "/0/b.png", MIMEType: "image/png", SizeInBytes: 56},
+	}
+	return itemList
+}
+
+func TestMakeAtomFeed(t *testing.T) {
+	conf.Authors = []jsfAuthor{{Name: "Blog Author"}}
+	itemList := legacyTestItems()
+	res, err := makeAtomFeed(itemList, rootScope)
+	if err != nil {
+		t.Errorf("Error (%s) when all parameters valid.", err.Error())
+	}
+	if res.Updated != "2018-01-02T03:04:05Z" {
+		t.Errorf("Wrong updated time, expected newest modified, actual '%s'", res.Updated)
+	}
+	if len(res.Authors) != 1 || res.Authors[0].Name != "Blog Author" {
+		t.Errorf("Wrong feed authors: %+v", res.Authors)
+	}
+	if len(res.Links) != 2 || res.Links[1].Rel != "self" || res.Links[1].Href != hostRawURL+"/"+atomPath {
+		t.Errorf("Wrong feed links: %+v", res.Links)
+	}
+	if len(res.Entries) != len(itemList) {
+		t.Fatalf("Unexpected entry count, expected %v, actual %v", len(itemList), len(res.Entries))
+	}
+	for i, entry := range res.Entries {
+		if entry.Id != itemList[i].ID {
+			t.Errorf("Unexpected ID at index %v, expected '%s', actual '%s'", i, itemList[i].ID, entry.Id)
+		}
+		if entry.Content == nil || entry.Content.Content != itemList[i].ContentHTML {
+			t.Errorf("Unexpected content at index %v: %+v", i, entry.Content)
+		}
+	}
+	first := res.Entries[0]
+	if len(first.Categories) != 2 || first.Categories[1].Term != "Tag2" {
+		t.Errorf("Wrong categories: %+v", first.Categories)
+	}
+	if len(first.Authors) != 1 || first.Authors[0].Uri != "https://example.com/" {
+		t.Errorf("Wrong entry authors: %+v", first.Authors)
+	}
+	if first.Published != itemList[0].DatePublished {
+		t.Errorf("Wrong published time: '%s'", first.Published)
+	}
+	if len(first.Links) != 3 || first.Links[1].Rel != "enclosure" || first.Links[1].Length != "1234" || first.Links[2].Type != "image/png" {
+		t.Errorf("Wrong entry links: %+v", first.Links)
+	}
+	conf = blogConfig{}
+
+	res, _ = makeAtomFeed(itemList, rootScope)
+	if len(res.Authors) != 1 || res.Authors[0].Name != blogTitle {
+		t.Errorf("Wrong fallback feed authors: %+v", res.Authors)
+	}
+}
+
+func TestMakeRssFeed(t *testing.T) {
+	itemList := legacyTestItems()
+	res, err := makeRssFeed(itemList, rootScope)
+	if err != nil {
+		t.Errorf("Error (%s) when all parameters valid.", err.Error())
+	}
+	if res.Channel.LastBuildDate != "Tue, 02 Jan 2018 03:04:05 +0000" {
+		t.Errorf("Wrong lastBuildDate: '%s'", res.Channel.LastBuildDate)
+	}
+	if res.Channel.SelfLink.Href != hostRawURL+"/"+rssPath {
+		t.Errorf("Wrong self link: %+v", res.Channel.SelfLink)
+	}
+	if len(res.Channel.Items) != len(itemList) {
+		t.Fatalf("Unexpected item count, expected %v, actual %v", len(itemList), len(res.Channel.Items))
+	}
+	for i, item := range res.Channel.Items {
+		if item.Guid.Id != itemList[i].ID || item.Guid.IsPermaLink != "false" {
+			t.Errorf("Unexpected guid at index %v: %+v", i, item.Guid)
+		}
+		if item.Description != itemList[i].ContentHTML {
+			t.Errorf("Unexpected content at index %v, expected '%s', actual '%s'", i, itemList[i].ContentHTML, item.Description)
+		}
+	}
+	first := res.Channel.Items[0]
+	if strings.Join(first.Categories, ",") != "Tag1,Tag2" {
+		t.Errorf("Wrong categories: %v", first.Categories)
+	}
+	if len(first.Creators) != 1 || first.Creators[0] != "Item Author" {
+		t.Errorf("Wrong creators: %v", first.Creators)
+	}
+	if first.Author != "" {
+		t.Errorf("Name in author element: '%s'", first.Author)
+	}
+	if first.Enclosure == nil || first.Enclosure.Url != itemList[0].Attachments[0].URL || first.Enclosure.Length != "1234" || first.Enclosure.Type != "audio/mpeg" {
+		t.Errorf("Wrong enclosure: %+v", first.Enclosure)
+	}
+	if res.Channel.Items[1].Enclosure != nil {
+		t.Errorf("Enclosure without attachments: %+v", res.Channel.Items[1].Enclosure)
+	}
+}
+
+func TestWriteXML(t *testing.T) {
+	dir, err := ioutil.TempDir(".", "legacy")
+	if err != nil {
+		t.Fatalf("Error (%s) creating temp dir.", err.Error())
+	}
+	defer os.RemoveAll(dir)
+
+	rf, _ := makeRssFeed(legacyTestItems(), rootScope)
+	outputPath := filepath.Join(dir, "rss")
+	err = writeXML(rf, outputPath)
+	if err != nil {
+		t.Errorf("Error (%s) when all parameters valid.", err.Error())
+	}
+	b, _ := ioutil.ReadFile(outputPath)
+	s := string(b)
+	for _, expected := range []string{xml.Header, `<rss version="2.0"`, `<atom:link href="`, `<dc:creator>Item Author</dc:creator>`, `<category>Tag1</category>`, `<enclosure url="`} {
+		if !strings.Contains(s, expected) {
+			t.Errorf("Missing '%s' in output:\n%s", expected, s)
+		}
+	}
+	var parsed rssFeed
+	err = xml.Unmarshal(b, &parsed)
+	if err != nil {
+		t.Errorf("Error (%s) parsing written feed.", err.Error())
+	}
+}
@@ -9,28 +9,85 @@ import (
 
 func main() {
 	if len(os.Args) < 2 {
-		log.Fatalf("Specify a mode: '%s' or '%s'", articleMode, updateMode)
+		log.Fatalf("Specify a mode: '%s', '%s', '%s' or '%s'", articleMode, updateMode, exportMode, migrateIDsMode)
 	}
 	fArticle := flag.NewFlagSet(articleMode, flag.ContinueOnError)
 	templateSrc := fArticle.String("template", "../../template.html", "Filename of template file")
 	tagList := fArticle.String("tags", "", "Comma-seperated list of tags")
 	title := fArticle.String("title", "", "Title of the article")
+	slug := fArticle.String("slug", "", "Slug used in the article's permalink")
+	aliasList := fArticle.String("aliases", "", "Comma-seperated list of former URLs of the article")
+	summary := fArticle.String("summary", "", "Summary of the article, instead of an excerpt")
+	season := fArticle.Int("season", 0, "Podcast season number of the article")
+	episode := fArticle.Int("episode", 0, "Podcast episode number of the article")
+	explicit := fArticle.Bool("explicit", false, "Mark the article's podcast episode as explicit")
+	toc := fArticle.Bool("toc", false, "Give the article a table of contents")
+	gallery := fArticle.String("gallery", "", "Show the article's images as a gallery, ordered by 'name' or 'date', or 'none' for no gallery")
+	language := fArticle.String("language", "", "Language of the article, if not the blog's")
+	authorList := fArticle.String("authors", "", "Comma-seperated list of the article's authors, if not the blog's")
 	articlePath := fArticle.String("articledir", ".", "Directory holding the article")
+	articleBlogPath := fArticle.String("blogdir", "", "Directory holding the blog, found from the config file if unset")
+	articleConfigSrc := fArticle.String("config", "../../blom.json", "Filename of config file")
+	articleNow := fArticle.String("now", "", "Fixed build time, overriding "+sourceDateEpochEnv)
 
 	fUpdate := flag.NewFlagSet(updateMode, flag.ContinueOnError)
 	mainTemplateSrc := fUpdate.String("mtemplate", "../template.html", "Filename of main template file")
 	homeTemplateSrc := fUpdate.String("htemplate", "../home-template.html", "Filename of homepage template file")
 	blogPath := fUpdate.String("blogdir", ".", "Directory holding the blog")
+	updateConfigSrc := fUpdate.String("config", "../blom.json", "Filename of config file")
+	updateNow := fUpdate.String("now", "", "Fixed build time, overriding "+sourceDateEpochEnv)
+
+	fExport := flag.NewFlagSet(exportMode, flag.ContinueOnError)
+	exportBlogPath := fExport.String("blogdir", ".", "Directory holding the blog")
+	exportConfigSrc := fExport.String("config", "../blom.json", "Filename of config file")
+	exportOutputPath := fExport.String("outdir", "", "Directory to write the export to")
+	exportTag := fExport.String("tag", "", "Only export articles with this tag")
+	exportFrom := fExport.String("from", "", "Only export articles published on or after this date (YYYY-MM-DD)")
+	exportTo := fExport.String("to", "", "Only export articles published on or before this date (YYYY-MM-DD)")
+	exportNow := fExport.String("now", "", "Fixed build time, overriding "+sourceDateEpochEnv)
+
+	fMigrateIDs := flag.NewFlagSet(migrateIDsMode, flag.ContinueOnError)
+	migrateBlogPath := fMigrateIDs.String("blogdir", ".", "Directory holding the blog")
+	migrateConfigSrc := fMigrateIDs.String("config", "../blom.json", "Filename of config file")
 
 	switch os.Args[1] {
 	case articleMode:
 		if err := fArticle.Parse(os.Args[2:]); err == nil {
+			conf, err = loadConfig(*articleConfigSrc)
+			if err != nil {
+				log.Fatal(err.Error())
+			}
+			fixedNow, err = reproducibleNow(*articleNow)
+			if err != nil {
+				log.Fatal(err.Error())
+			}
 			tmpl, err := template.ParseFiles(*templateSrc)
 			if err != nil {
 				log.Fatal(err.Error())
 			}
+			if len(*articleBlogPath) < 1 {
+				*articleBlogPath, err = findBlogPath(*articlePath, *articleConfigSrc)
+				if err != nil {
+					log.Fatal(err.Error())
+				}
+			}
 
-			_, err = processArticle(tmpl, *articlePath, *title, *tagList)
+			var explicitFlag, tocFlag *bool
+			var languageFlag, authorsFlag *string
+			fArticle.Visit(func(f *flag.Flag) {
+				switch f.Name {
+				case "explicit":
+					explicitFlag = explicit //So -explicit=false can override the podcast's setting
+				case "toc":
+					tocFlag = toc //So -toc=false can turn it off again
+				case "language":
+					languageFlag = language //So -language= can go back to the blog's
+				case "authors":
+					authorsFlag = authorList
+				}
+			})
+
+			_, err = processArticle(tmpl, *articleBlogPath, *articlePath, articleFlags{*title, *tagList, *slug, *aliasList, *summary, *season, *episode, explicitFlag, tocFlag, *gallery, languageFlag, authorsFlag})
 			if err != nil {
 				log.Fatal(err.Error())
 			}
@@ -39,6 +96,14 @@ func main() {
 		}
 	case updateMode:
 		if err := fUpdate.Parse(os.Args[2:]); err == nil {
+			conf, err = loadConfig(*updateConfigSrc)
+			if err != nil {
+				log.Fatal(err.Error())
+			}
+			fixedNow, err = reproducibleNow(*updateNow)
+			if err != nil {
+				log.Fatal(err.Error())
+			}
 			mainTmpl, err := template.ParseFiles(*mainTemplateSrc)
 			if err != nil {
 				log.Fatal(err.Error())
@@ -54,7 +119,48 @@ func main() {
 		} else {
 			log.Fatal(err.Error())
 		}
+	case exportMode:
+		if len(os.Args) < 3 {
+			log.Fatalf("Specify an export format: %s", exportFormatNames())
+		}
+		if err := fExport.Parse(os.Args[3:]); err == nil {
+			if len(*exportOutputPath) < 1 {
+				log.Fatal("Specify a directory to write the export to with -outdir")
+			}
+			conf, err = loadConfig(*exportConfigSrc)
+			if err != nil {
+				log.Fatal(err.Error())
+			}
+			fixedNow, err = reproducibleNow(*exportNow)
+			if err != nil {
+				log.Fatal(err.Error())
+			}
+			filter, err := newExportFilter(*exportTag, *exportFrom, *exportTo)
+			if err != nil {
+				log.Fatal(err.Error())
+			}
+			err = processExport(*exportBlogPath, os.Args[2], *exportOutputPath, filter)
+			if err != nil {
+				log.Fatal(err.Error())
+			}
+		} else {
+			log.Fatal(err.Error())
+		}
+	case migrateIDsMode:
+		if err := fMigrateIDs.Parse(os.Args[2:]); err == nil {
+			conf, err = loadConfig(*migrateConfigSrc)
+			if err != nil {
+				log.Fatal(err.Error())
+			}
+			frozen, err := migrateIDs(*migrateBlogPath)
+			if err != nil {
+				log.Fatal(err.Error())
+			}
+			log.Printf("Froze %d ids", frozen)
+		} else {
+			log.Fatal(err.Error())
+		}
 	default:
-		log.Fatalf("Unsupported mode: use '%s' or '%s'", articleMode, updateMode)
+		log.Fatalf("Unsupported mode: use '%s', '%s', '%s' or '%s'", articleMode, updateMode, exportMode, migrateIDsMode)
 	}
 }
@@ -0,0 +1,202 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/russross/blackfriday"
+	"golang.org/x/net/html"
+	"strconv"
+	"strings"
+)
+
+const defaultMarkdownEngine = "blackfriday"
+const headingIDsExtension = "heading_ids"
+
+var defaultMarkdownExtensions = []string{"tables", "fenced_code", "autolinks", "strikethrough", headingIDsExtension, "definition_lists", "smart_punctuation"}
+
+type markdownConfig struct {
+	Engine     string   `json:"engine"`
+	Extensions []string `json:"extensions"` //Unset for the defaults, empty for none
+}
+
+type markdownRenderer interface {
+	render(input []byte) ([]byte, error)
+}
+
+type markdownEngine func(extensions []string) (markdownRenderer, error)
+
+var markdownEngines = map[string]markdownEngine{
+	defaultMarkdownEngine: newBlackfridayRenderer,
+}
+
+type blackfridayFlags struct {
+	extensions int
+	htmlFlags  int
+}
+
+var blackfridayExtensions = map[string]blackfridayFlags{
+	"footnotes":         {blackfriday.EXTENSION_FOOTNOTES, 0},
+	"tables":            {blackfriday.EXTENSION_TABLES, 0},
+	"fenced_code":       {blackfriday.EXTENSION_FENCED_CODE, 0},
+	"autolinks":         {blackfriday.EXTENSION_AUTOLINK, 0},
+	"strikethrough":     {blackfriday.EXTENSION_STRIKETHROUGH, 0},
+	"definition_lists":  {blackfriday.EXTENSION_DEFINITION_LISTS, 0},
+	"hard_wraps":        {blackfriday.EXTENSION_HARD_LINE_BREAK, 0},
+	headingIDsExtension: {blackfriday.EXTENSION_HEADER_IDS, 0}, //For explicit {#id}, the rest come from addHeadingIDs
+	"smart_punctuation": {0, blackfriday.HTML_USE_SMARTYPANTS |
+		blackfriday.HTML_SMARTYPANTS_FRACTIONS |
+		blackfriday.HTML_SMARTYPANTS_DASHES |
+		blackfriday.HTML_SMARTYPANTS_LATEX_DASHES},
+}
+
+type blackfridayRenderer struct {
+	flags blackfridayFlags
+}
+
+func newBlackfridayRenderer(extensions []string) (markdownRenderer, error) {
+	res := blackfridayRenderer{blackfridayFlags{
+		blackfriday.EXTENSION_NO_INTRA_EMPHASIS | blackfriday.EXTENSION_SPACE_HEADERS | blackfriday.EXTENSION_BACKSLASH_LINE_BREAK,
+		blackfriday.HTML_USE_XHTML,
+	}}
+	for _, name := range extensions {
+		flags, ok := blackfridayExtensions[name]
+		if !ok {
+			return nil, fmt.Errorf("unsupported markdown extension '%s'", name)
+		}
+		res.flags.extensions |= flags.extensions
+		res.flags.htmlFlags |= flags.htmlFlags
+	}
+	return res, nil
+}
+
+func (br blackfridayRenderer) render(input []byte) ([]byte, error) {
+	var renderer blackfriday.Renderer = blackfriday.HtmlRenderer(br.flags.htmlFlags, "", "")
+	if conf.Highlight != nil {
+		renderer = &highlightRenderer{renderer, *conf.Highlight}
+	}
+	return blackfriday.MarkdownOptions(input, renderer, blackfriday.Options{Extensions: br.flags.extensions}), nil
+}
+
+func (mc markdownConfig) extensions() []string {
+	if mc.Extensions == nil {
+		return defaultMarkdownExtensions
+	}
+	return mc.Extensions
+}
+
+func (mc markdownConfig) hasExtension(name string) bool {
+	for _, ext := range mc.extensions() {
+		if ext == name {
+			return true
+		}
+	}
+	return false
+}
+
+func (mc markdownConfig) renderer() (markdownRenderer, error) {
+	engineName := mc.Engine
+	if len(engineName) < 1 {
+		engineName = defaultMarkdownEngine
+	}
+	engine, ok := markdownEngines[engineName]
+	if !ok {
+		return nil, fmt.Errorf("unsupported markdown engine '%s'", engineName)
+	}
+	return engine(mc.extensions())
+}
+
+func isHeading(name string) bool {
+	return len(name) == 2 && name[0] == 'h' && name[1] >= '1' && name[1] <= '6'
+}
+
+func existingIDs(content []byte) map[string]bool {
+	res := make(map[string]bool)
+	z := html.NewTokenizer(bytes.NewReader(content))
+	for tt := z.Next(); tt != html.ErrorToken; tt = z.Next() {
+		for _, attr := range z.Token().Attr {
+			if attr.Key == "id" {
+				res[attr.Val] = true
+			}
+		}
+	}
+	return res
+}
+
+func uniqueID(base string, seen map[string]bool) string {
+	if len(base) < 1 {
+		base = "section"
+	}
+	res := base
+	for i := 2; seen[res]; i++ {
+		res = base + "-" + strconv.Itoa(i)
+	}
+	seen[res] = true
+	return res
+}
+
+func addHeadingIDs(content []byte) []byte {
+	seen := existingIDs(content)
+	var out, heading, text bytes.Buffer
+	var headingTag []byte
+	z := html.NewTokenizer(bytes.NewReader(content))
+	for {
+		tt := z.Next()
+		if tt == html.ErrorToken {
+			if headingTag != nil {
+				out.Write(headingTag)
+				out.Write(heading.Bytes()) //Unclosed heading
+			}
+			return out.Bytes()
+		}
+		raw := append([]byte(nil), z.Raw()...) //Token() unescapes in place
+		tok := z.Token()
+		if headingTag == nil {
+			if tt == html.StartTagToken && isHeading(tok.Data) && !hasAttr(tok, "id") {
+				headingTag = append([]byte(nil), raw...)
+				heading.Reset()
+				text.Reset()
+			} else {
+				out.Write(raw)
+			}
+			continue
+		}
+		if tt == html.EndTagToken && isHeading(tok.Data) {
+			id := uniqueID(slugify(strings.TrimSpace(text.String())), seen)
+			out.Write(headingTag[:3])
+			out.WriteString(" id=\"" + html.EscapeString(id) + "\"")
+			out.Write(headingTag[3:])
+			out.Write(heading.Bytes())
+			out.Write(raw)
+			headingTag = nil
+			continue
+		}
+		if tt == html.TextToken {
+			text.WriteString(tok.Data)
+		}
+		heading.Write(raw)
+	}
+}
+
+func hasAttr(tok html.Token, key string) bool {
+	for _, attr := range tok.Attr {
+		if attr.Key == key {
+			return true
+		}
+	}
+	return false
+}
+
+func renderMarkdown(input []byte) ([]byte, error) {
+	renderer, err := conf.Markdown.renderer()
+	if err != nil {
+		return nil, err
+	}
+	res, err := renderer.render(input)
+	if err != nil {
+		return nil, err
+	}
+	if conf.Markdown.hasExtension(headingIDsExtension) {
+		res = addHeadingIDs(res)
+	}
+	return res, nil
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+var addHeadingIDsTests = []struct {
+	input  string
+	output string
+}{
+	{"<h2>Hello World</h2>", "<h2 id=\"hello-world\">Hello World</h2>"},
+	{"<h2>A &amp; B</h2><h3>A &amp; B</h3>", "<h2 id=\"a-b\">A &amp; B</h2><h3 id=\"a-b-2\">A &amp; B</h3>"},
+	{"<h3 class=\"x\">Use <code>go vet</code></h3>", "<h3 id=\"use-go-vet\" class=\"x\">Use <code>go vet</code></h3>"},
+	{"<h2>Intro</h2><h2 id=\"intro\">Custom</h2>", "<h2 id=\"intro-2\">Intro</h2><h2 id=\"intro\">Custom</h2>"},
+	{"<h1>!!!</h1><p>Text</p>", "<h1 id=\"section\">!!!</h1><p>Text</p>"},
+	{"<h2>Unclosed", "<h2>Unclosed"},
+}
+
+func TestAddHeadingIDs(t *testing.T) {
+	for _, test := range addHeadingIDsTests {
+		res := string(addHeadingIDs([]byte(test.input)))
+		if res != test.output {
+			t.Errorf("Wrong output for '%s', expected '%s', actual '%s'", test.input, test.output, res)
+		}
+	}
+}
+
+var markdownExtensionTests = []struct {
+	extensions []string
+	input      string
+	contains   string
+}{
+	{nil, "a -- b", "&ndash;"},
+	{nil, "## Heading {#custom}", "<h2 id=\"custom\">Heading</h2>"},
+	{[]string{}, "a -- b", "a -- b"},
+	{[]string{}, "## Heading", "<h2>Heading</h2>"},
+	{[]string{"footnotes"}, "Text[^1]\n\n[^1]: Note\n", "class=\"footnotes\""},
+	{[]string{"hard_wraps"}, "one\ntwo", "one<br />\ntwo"},
+	{[]string{}, "one\ntwo", "one\ntwo"},
+	{[]string{"autolinks"}, "See http://ratan.blog now", "<a href=\"http://ratan.blog\">"},
+}
+
+func TestRenderMarkdownExtensions(t *testing.T) {
+	for _, test := range markdownExtensionTests {
+		conf.Markdown.Extensions = test.extensions
+		res, err := renderMarkdown([]byte(test.input))
+		if err != nil {
+			t.Errorf("Error (%s) when all parameters valid.", err.Error())
+		}
+		if !strings.Contains(string(res), test.contains) {
+			t.Errorf("Extensions %v: output of '%s' lacks '%s':\n%s", test.extensions, test.input, test.contains, res)
+		}
+	}
+	conf = blogConfig{}
+}
+
+type upperRenderer struct{}
+
+func (ur upperRenderer) render(input []byte) ([]byte, error) {
+	return []byte("<h1>" + strings.ToUpper(string(input)) + "</h1>"), nil
+}
+
+func TestMarkdownEngines(t *testing.T) {
+	markdownEngines["upper"] = func(extensions []string) (markdownRenderer, error) { return upperRenderer{}, nil }
+	defer delete(markdownEngines, "upper")
+
+	conf.Markdown.Engine = "upper"
+	res, err := renderMarkdown([]byte("hi"))
+	if err != nil || string(res) != "<h1 id=\"hi\">HI</h1>" {
+		t.Errorf("Wrong output from plugged in engine (%v): '%s'", err, res)
+	}
+	conf.Markdown.Engine = "nonexistent"
+	if _, err := renderMarkdown([]byte("hi")); err == nil {
+		t.Errorf("No error for unknown engine.")
+	}
+	conf.Markdown = markdownConfig{Extensions: []string{"telepathy"}}
+	if _, err := renderMarkdown([]byte("hi")); err == nil {
+		t.Errorf("No error for unknown extension.")
+	}
+	conf = blogConfig{}
+}
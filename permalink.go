@@ -0,0 +1,195 @@
+package main
+
+import (
+	"fmt"
+	"github.com/ratanvarghese/tqtime"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+	"unicode"
+)
+
+const slugFromTitle = "title"
+
+func slugify(s string) string {
+	var b strings.Builder
+	pendingDash := false
+	for _, r := range strings.ToLower(s) {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			if pendingDash && b.Len() > 0 {
+				b.WriteRune('-')
+			}
+			b.WriteRune(r)
+			pendingDash = false
+		} else {
+			pendingDash = true
+		}
+	}
+	return b.String()
+}
+
+func tqMonthSlug(t time.Time) string {
+	month := tqtime.Month(t.Year(), t.YearDay())
+	if month == tqtime.SpecialDay {
+		return slugify(tqtime.DayName(tqtime.Day(t.Year(), t.YearDay())))
+	}
+	return slugify(month.String())
+}
+
+func articleSlug(slugOverride, title, dir string) string {
+	if len(slugOverride) > 0 {
+		return slugify(slugOverride)
+	}
+	if conf.SlugFrom == slugFromTitle && len(title) > 0 {
+		return slugify(title)
+	}
+	return slugify(path.Base(dir))
+}
+
+func expandPermalink(pattern string, published time.Time, slug, dir string) (string, error) {
+	if len(pattern) < 1 {
+		return dir, nil
+	}
+	parts := strings.Split(pattern, "/")
+	for i, part := range parts {
+		if !strings.HasPrefix(part, ":") {
+			continue
+		}
+		switch part {
+		case ":year":
+			parts[i] = fmt.Sprintf("%04d", published.Year())
+		case ":month":
+			parts[i] = fmt.Sprintf("%02d", int(published.Month()))
+		case ":day":
+			parts[i] = fmt.Sprintf("%02d", published.Day())
+		case ":tqyear":
+			parts[i] = fmt.Sprintf("%d", tqtime.Year(published.Year(), published.YearDay()))
+		case ":tqmonth":
+			parts[i] = tqMonthSlug(published)
+		case ":slug":
+			parts[i] = slug
+		case ":path":
+			parts[i] = dir
+		default:
+			return "", fmt.Errorf("unknown permalink placeholder '%s' in '%s'", part, pattern)
+		}
+	}
+	res := strings.Trim(path.Clean("/"+strings.Join(parts, "/")), "/")
+	if len(res) < 1 {
+		return "", fmt.Errorf("permalink pattern '%s' gives an empty path", pattern)
+	}
+	if strings.HasSuffix(pattern, "/") {
+		res += "/"
+	}
+	return res, nil
+}
+
+func publishesElsewhere(pattern string) bool {
+	p := strings.Trim(path.Clean("/"+pattern), "/")
+	return len(p) > 0 && p != ":path"
+}
+
+func (bc blogConfig) permalinkFor(dir string) string {
+	if sc, ok := bc.sectionFor(dir); ok && len(sc.Permalink) > 0 {
+		return sc.Permalink
+	}
+	return bc.Permalink
+}
+
+type urlClaim struct {
+	dir     string
+	urlPath string   //As from urlPathKey
+	aliases []string //Each gets a redirect stub
+}
+
+func aliasPaths(aliases []string) ([]string, error) {
+	res := make([]string, len(aliases))
+	for i, alias := range aliases {
+		aliasURL, err := resolveAlias(alias)
+		if err != nil {
+			return nil, err
+		}
+		res[i], err = urlPathKey(aliasURL)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return res, nil
+}
+
+func itemClaim(ji jsfItem) (urlClaim, error) {
+	res := urlClaim{dir: ji.dir}
+	var err error
+	res.urlPath, err = urlPathKey(ji.URL)
+	if err != nil || ji.Blom == nil {
+		return res, err
+	}
+	res.aliases, err = aliasPaths(ji.Blom.Aliases)
+	return res, err
+}
+
+func itemClaims(itemList []jsfItem) ([]urlClaim, error) {
+	res := make([]urlClaim, 0, len(itemList))
+	for _, ji := range itemList {
+		if len(ji.URL) < 1 {
+			continue //Not built yet, update checks it
+		}
+		c, err := itemClaim(ji)
+		if err != nil {
+			return nil, err
+		}
+		res = append(res, c)
+	}
+	return res, nil
+}
+
+func reservedURLPath(urlPath string) (string, bool) {
+	p := strings.Trim(urlPath, "/")
+	if len(p) < 1 {
+		return "the home page", true
+	}
+	dirs := []string{"tags", "archive", path.Dir(jsfPath), imageDir}
+	if conf.Gemini != nil {
+		dirs = append(dirs, filepath.ToSlash(strings.TrimPrefix(conf.Gemini.outputPath(""), string(filepath.Separator))))
+	}
+	for _, sc := range conf.Sections {
+		if p == sc.Path {
+			return fmt.Sprintf("the index of section '%s'", sc.Path), true
+		}
+		dirs = append(dirs, path.Join(sc.Path, path.Dir(jsfPath)))
+	}
+	for _, dir := range dirs {
+		if p == dir || strings.HasPrefix(p, dir+"/") {
+			return fmt.Sprintf("the generated '%s/' directory", dir), true
+		}
+	}
+	return "", false
+}
+
+func findURLCollisions(claims []urlClaim) error {
+	seen := make(map[string]string)
+	for _, c := range claims {
+		if prevDir, ok := seen[c.urlPath]; ok {
+			return fmt.Errorf("URL collision: '%s' is used by both '%s' and '%s'", c.urlPath, prevDir, c.dir)
+		}
+		if what, ok := reservedURLPath(c.urlPath); ok {
+			return fmt.Errorf("URL '%s' of '%s' would overwrite %s", c.urlPath, c.dir, what)
+		}
+		seen[c.urlPath] = c.dir
+	}
+	for _, c := range claims {
+		for _, alias := range c.aliases {
+			if alias == c.urlPath {
+				continue //Renamed back to a former URL
+			}
+			if otherDir, ok := seen[alias]; ok {
+				return fmt.Errorf("alias '%s' of '%s' is the URL of '%s'", alias, c.dir, otherDir)
+			}
+			if what, ok := reservedURLPath(alias); ok {
+				return fmt.Errorf("alias '%s' of '%s' would overwrite %s", alias, c.dir, what)
+			}
+		}
+	}
+	return nil
+}
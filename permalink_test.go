@@ -0,0 +1,209 @@
+package main
+
+import (
+	"html/template"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+var slugifyTests = []struct {
+	input  string
+	output string
+}{
+	{"hello", "hello"},
+	{"Hello, World!", "hello-world"},
+	{"  leading and trailing  ", "leading-and-trailing"},
+	{"Déjà vu 2", "déjà-vu-2"},
+	{"a--b__c", "a-b-c"},
+}
+
+func TestSlugify(t *testing.T) {
+	for _, s := range slugifyTests {
+		res := slugify(s.input)
+		if res != s.output {
+			t.Errorf("Wrong slug for '%s', expected '%s', actual '%s'", s.input, s.output, res)
+		}
+	}
+}
+
+var expandPermalinkTests = []struct {
+	pattern string
+	date    string
+	output  string
+}{
+	{"", "2017-06-10", "notes/hello"},
+	{"/:year/:month/:slug/", "2017-06-10", "2017/06/hi-there/"},
+	{"/:year/:month/:day/:slug", "2017-06-10", "2017/06/10/hi-there"},
+	{"/:tqyear/:tqmonth/:slug/", "2017-06-10", "48/lavoisier/hi-there/"},
+	{"/:tqyear/:tqmonth/:slug/", "2016-07-20", "47/armstrong-day/hi-there/"},
+	{"/posts/:slug/", "2017-06-10", "posts/hi-there/"},
+	{"/archive/:path", "2017-06-10", "archive/notes/hello"},
+}
+
+func TestExpandPermalink(t *testing.T) {
+	for _, s := range expandPermalinkTests {
+		published, _ := time.Parse("2006-01-02", s.date)
+		res, err := expandPermalink(s.pattern, published, "hi-there", "notes/hello")
+		if err != nil {
+			t.Errorf("Error (%s) for valid pattern '%s'", err.Error(), s.pattern)
+		}
+		if res != s.output {
+			t.Errorf("Wrong permalink for '%s', expected '%s', actual '%s'", s.pattern, s.output, res)
+		}
+	}
+
+	_, err := expandPermalink("/:yaer/:slug/", time.Now(), "hi-there", "hello")
+	if err == nil {
+		t.Errorf("No error for unknown placeholder")
+	}
+}
+
+func TestArticleSlug(t *testing.T) {
+	if res := articleSlug("", "My Title", "notes/My_Dir"); res != "my-dir" {
+		t.Errorf("Wrong slug from directory, expected 'my-dir', actual '%s'", res)
+	}
+	if res := articleSlug("Custom Slug", "My Title", "notes/My_Dir"); res != "custom-slug" {
+		t.Errorf("Wrong slug from override, expected 'custom-slug', actual '%s'", res)
+	}
+	conf.SlugFrom = slugFromTitle
+	if res := articleSlug("", "My Title", "notes/My_Dir"); res != "my-title" {
+		t.Errorf("Wrong slug from title, expected 'my-title', actual '%s'", res)
+	}
+	conf = blogConfig{}
+}
+
+var findURLCollisionsTests = []struct {
+	claims []urlClaim
+	valid  bool
+}{
+	{[]urlClaim{{dir: "a", urlPath: "/posts/a"}, {dir: "b", urlPath: "/posts/b"}, {dir: "notes/a", urlPath: "/posts/c"}}, true},
+	{[]urlClaim{{dir: "a", urlPath: "/posts/a"}, {dir: "notes/a", urlPath: "/posts/a"}}, false},
+	{[]urlClaim{{dir: "tags", urlPath: "/tags"}}, false},
+	{[]urlClaim{{dir: "a", urlPath: "/archive"}}, false},
+	{[]urlClaim{{dir: "a", urlPath: "/feeds/a"}}, false},
+	{[]urlClaim{{dir: "a", urlPath: "/images/a"}}, false},
+	{[]urlClaim{{dir: "a", urlPath: "/"}}, false},
+	{[]urlClaim{{dir: "a", urlPath: "/notes"}}, false},
+	{[]urlClaim{{dir: "a", urlPath: "/notes/feeds"}}, false},
+	{[]urlClaim{{dir: "a", urlPath: "/notes/a"}}, true},
+	{[]urlClaim{{dir: "a", urlPath: "/tagsoup"}}, true},
+	{[]urlClaim{{dir: "a", urlPath: "/a", aliases: []string{"/old-a", "/a"}}, {dir: "b", urlPath: "/b"}}, true},
+	{[]urlClaim{{dir: "a", urlPath: "/a", aliases: []string{"/b"}}, {dir: "b", urlPath: "/b"}}, false},
+	{[]urlClaim{{dir: "a", urlPath: "/a", aliases: []string{"/tags"}}}, false},
+}
+
+func TestFindURLCollisions(t *testing.T) {
+	conf.Sections = []sectionConfig{{Path: "notes"}}
+	defer func() { conf = blogConfig{} }()
+	for _, test := range findURLCollisionsTests {
+		err := findURLCollisions(test.claims)
+		if test.valid && err != nil {
+			t.Errorf("Error (%s) for valid URLs %v", err.Error(), test.claims)
+		} else if !test.valid && err == nil {
+			t.Errorf("No error for invalid URLs %v", test.claims)
+		}
+	}
+}
+
+func TestProcessArticleURLCollision(t *testing.T) {
+	tmpl := template.Must(template.New("Whatever").Parse("{{.Title}}"))
+	conf.Permalink = "/:slug/"
+	defer func() { conf = blogConfig{} }()
+	blogPath, subdirPaths := setupBlog(t, []byte(`{"title": "Trip", "date_published": "2017-06-10T10:00:00Z"}`), []byte("Hello"), 2, 2)
+	defer teardownArticlePath(t, blogPath)
+
+	_, err := processArticle(tmpl, blogPath, subdirPaths[0], articleFlags{slug: "tags"})
+	if err == nil {
+		t.Errorf("No error for article that would overwrite the tag index")
+	}
+	if _, err := os.Stat(filepath.Join(blogPath, "tags")); err == nil {
+		t.Errorf("Tag index overwritten before the URL was checked")
+	}
+
+	_, err = processArticle(tmpl, blogPath, subdirPaths[0], articleFlags{slug: "same"})
+	if err != nil {
+		t.Fatalf("Error (%s) when all parameters valid.", err.Error())
+	}
+	_, err = processArticle(tmpl, blogPath, subdirPaths[1], articleFlags{slug: "same"})
+	if err == nil {
+		t.Errorf("No error for article with the URL of another")
+	}
+	prevItem, _, err := getPreviousItem(subdirPaths[1])
+	if err != nil || len(prevItem.URL) > 0 {
+		t.Errorf("Colliding article was built anyway: '%s', %v", prevItem.URL, err)
+	}
+}
+
+func TestBuildItemListURLCollision(t *testing.T) {
+	tmpl := template.Must(template.New("Whatever").Parse("{{.Title}}"))
+	conf.Permalink = "/:slug/"
+	conf.SlugFrom = slugFromTitle
+	defer func() { conf = blogConfig{} }()
+	blogPath, subdirPaths := setupBlog(t, []byte(`{"title": "Trip", "date_published": "2017-06-10T10:00:00Z"}`), []byte("Hello"), 2, 2)
+	defer teardownArticlePath(t, blogPath)
+
+	_, err := buildItemList(tmpl, blogPath)
+	if err == nil {
+		t.Errorf("No error for articles with the same URL")
+	}
+	if _, err := os.Stat(filepath.Join(blogPath, "trip")); err == nil {
+		t.Errorf("Article written before the URLs were checked")
+	}
+	for _, articlePath := range subdirPaths {
+		prevItem, _, err := getPreviousItem(articlePath)
+		if err != nil || len(prevItem.URL) > 0 {
+			t.Errorf("Article '%s' was built anyway: '%s', %v", articlePath, prevItem.URL, err)
+		}
+	}
+}
+
+func TestProcessArticlePermalink(t *testing.T) {
+	tmpl := template.Must(template.New("Whatever").Parse("{{.Title}}"))
+	conf.Permalink = "/:year/:slug/"
+
+	blogPath := setupArticlePath(t)
+	articlePath := filepath.Join(blogPath, "drafts-2017", "hello")
+	err := os.MkdirAll(filepath.Join(articlePath, attachmentDir), 0777)
+	if err != nil {
+		t.Errorf("Error (%s) BEFORE STARTING TEST.", err.Error())
+	}
+	err = ioutil.WriteFile(filepath.Join(articlePath, contentFileHTML), []byte("<img src=\"attachments/1200.jpg\">"), 0664)
+	if err != nil {
+		t.Errorf("Error (%s) BEFORE STARTING TEST.", err.Error())
+	}
+	err = ioutil.WriteFile(filepath.Join(articlePath, attachmentDir, "1200.jpg"), jpegBytes, 0664)
+	if err != nil {
+		t.Errorf("Error (%s) BEFORE STARTING TEST.", err.Error())
+	}
+
+	ji, err := processArticle(tmpl, blogPath, articlePath, articleFlags{title: "Hello", slug: "Greetings"})
+	if err != nil {
+		t.Errorf("Error (%s) when all parameters valid", err.Error())
+	}
+	year := time.Now().Format("2006")
+	expectedURL := hostRawURL + "/" + year + "/greetings/"
+	if ji.URL != expectedURL {
+		t.Errorf("Wrong URL, expected '%s', actual '%s'", expectedURL, ji.URL)
+	}
+	outputPath := filepath.Join(blogPath, year, "greetings")
+	if _, err := os.Stat(filepath.Join(outputPath, finalWebpageFile)); err != nil {
+		t.Errorf("Error (%s) finding page at permalink", err.Error())
+	}
+	if _, err := os.Stat(filepath.Join(outputPath, attachmentDir, "1200.jpg")); err != nil {
+		t.Errorf("Error (%s) finding copied attachment", err.Error())
+	}
+	if len(ji.Attachments) != 1 || ji.Attachments[0].URL != expectedURL+attachmentDir+"/1200.jpg" {
+		t.Errorf("Wrong attachment URLs: %v", ji.Attachments)
+	}
+
+	prevItem, _, err := getPreviousItem(articlePath)
+	if err != nil || prevItem.Blom == nil || prevItem.Blom.Slug != "Greetings" {
+		t.Errorf("Slug override not kept in item file: %v", prevItem.Blom)
+	}
+
+	conf = blogConfig{}
+	teardownArticlePath(t, blogPath)
+}
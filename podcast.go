@@ -0,0 +1,245 @@
+package main
+
+import (
+	"crypto/sha1"
+	"encoding/xml"
+	"fmt"
+	"html"
+	"math"
+	"net/url"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const podcastPath = "feeds/podcast"
+const itunesNS = "http://www.itunes.com/dtds/podcast-1.0.dtd"
+const podcastNS = "https://podcastindex.org/namespace/1.0"
+
+var podcastGUIDNamespace = []byte{0xea, 0xd4, 0xc2, 0x36, 0xbf, 0x58, 0x58, 0xc6, 0xa2, 0xc6, 0xa6, 0xb2, 0x8d, 0x12, 0x8c, 0xb6}
+
+type rssGUID struct {
+	IsPermaLink string `xml:"isPermaLink,attr"`
+	ID          string `xml:",chardata"`
+}
+
+type rssEnclosure struct {
+	URL    string `xml:"url,attr"`
+	Length string `xml:"length,attr"`
+	Type   string `xml:"type,attr"`
+}
+
+type rssContent struct {
+	Body string `xml:",cdata"`
+}
+
+type itunesImage struct {
+	Href string `xml:"href,attr"`
+}
+
+type itunesCategory struct {
+	Text string          `xml:"text,attr"`
+	Sub  *itunesCategory `xml:"itunes:category"`
+}
+
+type itunesOwner struct {
+	Name  string `xml:"itunes:name,omitempty"`
+	Email string `xml:"itunes:email,omitempty"`
+}
+
+type podcastLocked struct {
+	Owner  string `xml:"owner,attr,omitempty"`
+	Locked string `xml:",chardata"`
+}
+
+type podcastItem struct {
+	Title       string       `xml:"title"`
+	Link        string       `xml:"link"`
+	Description string       `xml:"description"`
+	Content     *rssContent  `xml:"content:encoded"`
+	GUID        rssGUID      `xml:"guid"`
+	PubDate     string       `xml:"pubDate"`
+	Enclosure   rssEnclosure `xml:"enclosure"`
+	Duration    int64        `xml:"itunes:duration,omitempty"`
+	Explicit    string       `xml:"itunes:explicit"`
+	Image       *itunesImage `xml:"itunes:image"`
+	Season      int          `xml:"itunes:season,omitempty"`
+	Episode     int          `xml:"itunes:episode,omitempty"`
+	EpisodeType string       `xml:"itunes:episodeType"`
+}
+
+type podcastChannel struct {
+	Title         string          `xml:"title"`
+	Link          string          `xml:"link"`
+	Description   string          `xml:"description"`
+	Language      string          `xml:"language,omitempty"`
+	Copyright     string          `xml:"copyright,omitempty"`
+	LastBuildDate string          `xml:"lastBuildDate"`
+	SelfLink      rssLink         `xml:"atom:link"`
+	Author        string          `xml:"itunes:author,omitempty"`
+	Owner         *itunesOwner    `xml:"itunes:owner"`
+	Image         *itunesImage    `xml:"itunes:image"`
+	Category      *itunesCategory `xml:"itunes:category"`
+	Explicit      string          `xml:"itunes:explicit"`
+	Type          string          `xml:"itunes:type,omitempty"`
+	GUID          string          `xml:"podcast:guid"`
+	Locked        *podcastLocked  `xml:"podcast:locked"`
+	Items         []podcastItem   `xml:"item"`
+}
+
+type podcastFeed struct {
+	XMLName   xml.Name       `xml:"rss"`
+	Version   string         `xml:"version,attr"`
+	AtomNS    string         `xml:"xmlns:atom,attr"`
+	ContentNS string         `xml:"xmlns:content,attr"`
+	ItunesNS  string         `xml:"xmlns:itunes,attr"`
+	PodcastNS string         `xml:"xmlns:podcast,attr"`
+	Channel   podcastChannel `xml:"channel"`
+}
+
+func podcastGUID(feedURL string) string {
+	name := feedURL
+	if i := strings.Index(name, "://"); i >= 0 {
+		name = name[i+3:]
+	}
+	name = strings.TrimRight(name, "/")
+	h := sha1.New()
+	h.Write(podcastGUIDNamespace)
+	h.Write([]byte(name))
+	b := h.Sum(nil)[:16]
+	b[6] = (b[6] & 0x0f) | 0x50 //Version 5
+	b[8] = (b[8] & 0x3f) | 0x80 //RFC 4122 variant
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:])
+}
+
+func episodeAudio(ji jsfItem) (jsfAttachment, bool) {
+	for _, ja := range ji.Attachments {
+		if strings.HasPrefix(ja.MIMEType, "audio/") {
+			return ja, true
+		}
+	}
+	return jsfAttachment{}, false
+}
+
+func (pc podcastConfig) validate() error {
+	if len(pc.Image) > 0 {
+		u, err := url.Parse(pc.Image)
+		if err != nil || !u.IsAbs() || len(u.Host) < 1 {
+			return fmt.Errorf("podcast image '%s' must be an absolute url", pc.Image)
+		}
+	}
+	return nil
+}
+
+func podcastItemFromJsfItem(ji jsfItem, audio jsfAttachment, pc podcastConfig) podcastItem {
+	var pi podcastItem
+	pi.Title = ji.Title
+	pi.Link = ji.URL
+	pi.Description = ji.ContentHTML
+	if len(ji.Summary) > 0 {
+		pi.Description = html.EscapeString(ji.Summary)
+	}
+	if conf.FeedContent != feedContentSummary {
+		pi.Content = &rssContent{ji.ContentHTML}
+	}
+	pi.GUID = rssGUID{IsPermaLink: strconv.FormatBool(ji.ID == ji.URL), ID: ji.ID}
+	pi.PubDate = rssDate(ji.DatePublished)
+	pi.Enclosure = rssEnclosure{URL: audio.URL, Length: strconv.FormatInt(audio.SizeInBytes, 10), Type: audio.MIMEType}
+	pi.Duration = int64(math.Round(audio.DurationInSeconds))
+	explicit := pc.Explicit
+	if ji.Blom != nil && ji.Blom.Explicit != nil {
+		explicit = *ji.Blom.Explicit
+	}
+	pi.Explicit = strconv.FormatBool(explicit)
+	if len(ji.Image) > 0 {
+		base, err := pageBase(ji.URL)
+		if err == nil {
+			pi.Image = &itunesImage{resolveLink(base, ji.Image)} //Apple only takes absolute URLs
+		}
+	}
+	if ji.Blom != nil {
+		pi.Season = ji.Blom.Season
+		pi.Episode = ji.Blom.Episode
+	}
+	pi.EpisodeType = "full"
+	return pi
+}
+
+func makePodcastFeed(itemList []jsfItem, pc podcastConfig) (podcastFeed, error) {
+	var pf podcastFeed
+	selfURL, err := rootScope.resolve(podcastPath)
+	if err != nil {
+		return pf, err
+	}
+	pf.Version = "2.0"
+	pf.AtomNS = atomNS
+	pf.ContentNS = contentNS
+	pf.ItunesNS = itunesNS
+	pf.PodcastNS = podcastNS
+
+	ch := &pf.Channel
+	ch.Title = pc.Title
+	if len(ch.Title) < 1 {
+		ch.Title = blogTitle
+	}
+	ch.Link = hostRawURL
+	ch.Description = pc.Description
+	if len(ch.Description) < 1 {
+		ch.Description = conf.Description
+	}
+	if len(ch.Description) < 1 {
+		ch.Description = ch.Title
+	}
+	ch.Language = conf.Language
+	ch.Copyright = pc.Copyright
+	ch.SelfLink = rssLink{Href: selfURL, Rel: "self", Type: "application/rss+xml"}
+	ch.Author = pc.Author
+	if len(pc.OwnerName) > 0 || len(pc.OwnerEmail) > 0 {
+		ch.Owner = &itunesOwner{pc.OwnerName, pc.OwnerEmail}
+	}
+	if len(pc.Image) > 0 {
+		ch.Image = &itunesImage{pc.Image}
+	}
+	if len(pc.Category) > 0 {
+		ch.Category = &itunesCategory{Text: pc.Category}
+		if len(pc.Subcategory) > 0 {
+			ch.Category.Sub = &itunesCategory{Text: pc.Subcategory}
+		}
+	}
+	ch.Explicit = strconv.FormatBool(pc.Explicit)
+	ch.Type = pc.Type
+	ch.GUID = pc.GUID
+	if len(ch.GUID) < 1 {
+		ch.GUID = podcastGUID(selfURL)
+	}
+	if pc.Locked {
+		ch.Locked = &podcastLocked{Owner: pc.OwnerEmail, Locked: "yes"}
+	}
+
+	episodes := make([]jsfItem, 0)
+	for _, ji := range itemList {
+		audio, ok := episodeAudio(ji)
+		if !ok {
+			continue
+		}
+		episodes = append(episodes, ji)
+		ch.Items = append(ch.Items, podcastItemFromJsfItem(ji, audio, pc))
+	}
+	ch.LastBuildDate = newestModified(episodes).Format(time.RFC1123Z)
+	return pf, nil
+}
+
+func processPodcast(wg *sync.WaitGroup, itemList []jsfItem, blogPath string, ch chan<- error) {
+	defer wg.Done()
+	pf, err := makePodcastFeed(itemList, *conf.Podcast)
+	if err != nil {
+		ch <- err
+		return
+	}
+	err = writeXML(pf, filepath.Join(blogPath, podcastPath))
+	if err != nil {
+		ch <- err
+	}
+}
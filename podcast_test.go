@@ -0,0 +1,115 @@
+package main
+
+import (
+	"html/template"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestPodcastGUID(t *testing.T) {
+	expected := "9b024349-ccf0-5f69-a609-6b82873eab3c" //Example from the Podcasting 2.0 spec
+	if res := podcastGUID("https://podnews.net/rss/"); res != expected {
+		t.Errorf("Wrong podcast GUID, expected '%s', actual '%s'", expected, res)
+	}
+}
+
+func TestMakePodcastFeed(t *testing.T) {
+	explicit := false
+	itemList := legacyTestItems()
+	itemList[0].Image = "cover.png"
+	itemList[0].Attachments[0].DurationInSeconds = 61.6
+	itemList[0].Blom = &blomMeta{Season: 2, Episode: 7, Explicit: &explicit}
+	itemList[2].Attachments = []jsfAttachment{{URL: hostRawURL + "/2/b.ogg", MIMEType: "audio/ogg", SizeInBytes: 99}}
+
+	pc := podcastConfig{Title: "Ratan Talks", Category: "Arts", Subcategory: "Books", Explicit: true, OwnerEmail: "me@ratan.blog", Locked: true}
+	res, err := makePodcastFeed(itemList, pc)
+	if err != nil {
+		t.Errorf("Error (%s) when all parameters valid.", err.Error())
+	}
+	ch := res.Channel
+	if ch.Title != "Ratan Talks" || ch.Explicit != "true" || ch.Description != "Ratan Talks" {
+		t.Errorf("Wrong show metadata: %+v", ch)
+	}
+	if ch.Category == nil || ch.Category.Sub == nil || ch.Category.Sub.Text != "Books" {
+		t.Errorf("Wrong category: %+v", ch.Category)
+	}
+	if ch.Owner == nil || ch.Owner.Email != "me@ratan.blog" || ch.Locked == nil || ch.Locked.Locked != "yes" {
+		t.Errorf("Wrong owner or lock: %+v %+v", ch.Owner, ch.Locked)
+	}
+	if ch.GUID != podcastGUID(hostRawURL+"/"+podcastPath) {
+		t.Errorf("Wrong GUID: '%s'", ch.GUID)
+	}
+	if len(ch.Items) != 2 {
+		t.Fatalf("Wrong episode count, expected 2, actual %v", len(ch.Items))
+	}
+	first := ch.Items[0]
+	if first.Enclosure.URL != itemList[0].Attachments[0].URL || first.Enclosure.Length != "1234" || first.Enclosure.Type != "audio/mpeg" {
+		t.Errorf("Wrong enclosure: %+v", first.Enclosure)
+	}
+	if first.Duration != 62 || first.Explicit != "false" || first.Season != 2 || first.Episode != 7 {
+		t.Errorf("Wrong episode metadata: %+v", first)
+	}
+	if first.Image == nil || first.Image.Href != hostRawURL+"/0/cover.png" {
+		t.Errorf("Wrong episode artwork: %+v", first.Image)
+	}
+	second := ch.Items[1]
+	if second.Enclosure.Type != "audio/ogg" || second.Explicit != "true" || second.Image != nil {
+		t.Errorf("Wrong second episode: %+v", second)
+	}
+	if ch.LastBuildDate != rssDate(itemList[2].DateModified) {
+		t.Errorf("Wrong lastBuildDate: '%s'", ch.LastBuildDate)
+	}
+}
+
+var podcastConfigTests = []struct {
+	pc    podcastConfig
+	valid bool
+}{
+	{podcastConfig{}, true},
+	{podcastConfig{Image: "https://ratan.blog/cover.png"}, true},
+	{podcastConfig{Image: "/cover.png"}, false},
+	{podcastConfig{Image: "cover.png"}, false},
+}
+
+func TestPodcastConfigValidate(t *testing.T) {
+	for _, test := range podcastConfigTests {
+		err := test.pc.validate()
+		if (err == nil) != test.valid {
+			t.Errorf("Wrong validity for %v, expected %v, actual error %v", test.pc, test.valid, err)
+		}
+	}
+}
+
+func TestProcessArticleExplicit(t *testing.T) {
+	tmpl := template.Must(template.New("Whatever").Parse("{{.Title}}"))
+	blogPath := setupArticlePath(t)
+	defer teardownArticlePath(t, blogPath)
+	articlePath := filepath.Join(blogPath, "hello")
+	err := os.Mkdir(articlePath, 0777)
+	if err != nil {
+		t.Fatalf("Error (%s) BEFORE STARTING TEST.", err.Error())
+	}
+	err = ioutil.WriteFile(filepath.Join(articlePath, contentFileHTML), []byte("<p>Hi</p>"), 0664)
+	if err != nil {
+		t.Fatalf("Error (%s) BEFORE STARTING TEST.", err.Error())
+	}
+
+	for _, explicit := range []bool{true, false} {
+		ji, err := processArticle(tmpl, blogPath, articlePath, articleFlags{title: "Hello", explicit: &explicit})
+		if err != nil {
+			t.Fatalf("Error (%s) when all parameters valid.", err.Error())
+		}
+		if ji.Blom == nil || ji.Blom.Explicit == nil || *ji.Blom.Explicit != explicit {
+			t.Errorf("Explicit not set to %v: %+v", explicit, ji.Blom)
+		}
+	}
+	ji, err := processArticle(tmpl, blogPath, articlePath, articleFlags{})
+	if err != nil {
+		t.Fatalf("Error (%s) when all parameters valid.", err.Error())
+	}
+	if ji.Blom == nil || ji.Blom.Explicit == nil || *ji.Blom.Explicit {
+		t.Errorf("Explicit setting not kept: %+v", ji.Blom)
+	}
+}
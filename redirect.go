@@ -0,0 +1,179 @@
+package main
+
+import (
+	"fmt"
+	"html/template"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+)
+
+const redirectFormatNetlify = "netlify"
+const redirectFormatNginx = "nginx"
+const redirectFormatApache = "apache"
+
+var redirectFiles = map[string]string{
+	redirectFormatNetlify: "_redirects",
+	redirectFormatNginx:   "redirects.nginx.conf",
+	redirectFormatApache:  "redirects.htaccess",
+}
+
+var redirectTmpl = template.Must(template.New("redirect").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>{{.}}</title>
+<link rel="canonical" href="{{.}}">
+<meta name="robots" content="noindex">
+<meta http-equiv="refresh" content="0; url={{.}}">
+</head>
+<body>
+<p>This page has moved to <a href="{{.}}">{{.}}</a>.</p>
+</body>
+</html>
+`))
+
+type redirect struct {
+	from string //Path on this host
+	to   string //Full URL
+}
+
+func resolveAlias(alias string) (string, error) {
+	hostURL, err := url.Parse(hostRawURL)
+	if err != nil {
+		return "", err
+	}
+	aliasURL, err := url.Parse(alias)
+	if err != nil {
+		return "", err
+	}
+	res := hostURL.ResolveReference(aliasURL)
+	if !strings.EqualFold(res.Host, hostURL.Host) {
+		return "", fmt.Errorf("alias '%s' is not on %s, so it can't redirect here", alias, hostURL.Host)
+	}
+	return res.String(), nil
+}
+
+func addAlias(aliases []string, alias string) []string {
+	if len(alias) < 1 {
+		return aliases
+	}
+	for _, existing := range aliases {
+		if existing == alias {
+			return aliases
+		}
+	}
+	return append(aliases, alias)
+}
+
+func urlPathKey(rawURL string) (string, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return "", err
+	}
+	return "/" + strings.Trim(u.Path, "/"), nil
+}
+
+func redirectList(itemList []jsfItem) ([]redirect, error) {
+	livePaths := make(map[string]string)
+	for _, ji := range itemList {
+		p, err := urlPathKey(ji.URL)
+		if err != nil {
+			return nil, err
+		}
+		livePaths[p] = ji.URL
+	}
+
+	res := make([]redirect, 0)
+	for _, ji := range itemList {
+		if ji.Blom == nil {
+			continue
+		}
+		for _, alias := range ji.Blom.Aliases {
+			aliasURL, err := resolveAlias(alias)
+			if err != nil {
+				return nil, err
+			}
+			from, err := urlPathKey(aliasURL)
+			if err != nil {
+				return nil, err
+			}
+			if liveURL, ok := livePaths[from]; ok && liveURL == ji.URL {
+				continue //Renamed back to a former URL
+			} else if ok || from == "/" {
+				return nil, fmt.Errorf("alias '%s' of '%s' is the URL of a current page", alias, ji.URL)
+			}
+			res = append(res, redirect{from: from, to: ji.URL})
+		}
+	}
+	sort.Slice(res, func(i, j int) bool { return res[i].from < res[j].from })
+	return res, nil
+}
+
+func redirectFileLines(redirects []redirect, format string) ([]string, error) {
+	outputLines := make([]string, 0)
+	for _, r := range redirects {
+		switch format {
+		case redirectFormatNetlify:
+			outputLines = append(outputLines, fmt.Sprintf("%s %s 301", r.from, r.to))
+		case redirectFormatNginx:
+			outputLines = append(outputLines, fmt.Sprintf("location ~ ^%s/?$ { return 301 %s; }", r.from, r.to))
+		case redirectFormatApache:
+			outputLines = append(outputLines, fmt.Sprintf("RedirectMatch 301 ^%s/?$ %s", r.from, r.to))
+		default:
+			return nil, fmt.Errorf("unsupported redirect format '%s'", format)
+		}
+	}
+	return outputLines, nil
+}
+
+func writeRedirectStub(r redirect, blogPath string) error {
+	stubPath := filepath.Join(blogPath, filepath.FromSlash(r.from))
+	err := os.MkdirAll(stubPath, 0775)
+	if err != nil {
+		return err
+	}
+	f, err := os.Create(filepath.Join(stubPath, finalWebpageFile))
+	if err != nil {
+		return err
+	}
+	err = redirectTmpl.Execute(f, r.to)
+	if err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+func processRedirects(wg *sync.WaitGroup, itemList []jsfItem, blogPath string, ch chan<- error) {
+	defer wg.Done()
+	redirects, err := redirectList(itemList)
+	if err != nil {
+		ch <- err
+		return
+	}
+	for _, r := range redirects {
+		err = writeRedirectStub(r, blogPath)
+		if err != nil {
+			ch <- err
+			return
+		}
+	}
+	if len(conf.RedirectFormat) < 1 {
+		return
+	}
+	outputLines, err := redirectFileLines(redirects, conf.RedirectFormat)
+	if err != nil {
+		ch <- err
+		return
+	}
+	redirectFilePath := filepath.Join(blogPath, redirectFiles[conf.RedirectFormat])
+	err = ioutil.WriteFile(redirectFilePath, []byte(strings.Join(outputLines, "\n")+"\n"), 0664)
+	if err != nil {
+		ch <- err
+	}
+}
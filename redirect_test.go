@@ -0,0 +1,171 @@
+package main
+
+import (
+	"html/template"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestAddAlias(t *testing.T) {
+	aliases := addAlias(nil, "/old")
+	aliases = addAlias(aliases, "")
+	aliases = addAlias(aliases, "/older")
+	aliases = addAlias(aliases, "/old")
+	if len(aliases) != 2 || aliases[0] != "/old" || aliases[1] != "/older" {
+		t.Errorf("Wrong aliases, expected [/old /older], actual %v", aliases)
+	}
+}
+
+var resolveAliasTests = []struct {
+	alias    string
+	expected string
+	valid    bool
+}{
+	{"/old", hostRawURL + "/old", true},
+	{"2017/older/", hostRawURL + "/2017/older/", true},
+	{hostRawURL + "/old", hostRawURL + "/old", true},
+	{"https://RATAN.blog/old", "https://RATAN.blog/old", true},
+	{"https://other.example/x", "", false},
+	{"//other.example/x", "", false},
+}
+
+func TestResolveAlias(t *testing.T) {
+	for _, test := range resolveAliasTests {
+		res, err := resolveAlias(test.alias)
+		if (err == nil) != test.valid {
+			t.Errorf("Wrong validity for '%s', expected %v, actual error %v", test.alias, test.valid, err)
+		}
+		if res != test.expected {
+			t.Errorf("Wrong URL for '%s', expected '%s', actual '%s'", test.alias, test.expected, res)
+		}
+	}
+}
+
+func TestRedirectList(t *testing.T) {
+	itemList := make([]jsfItem, 2)
+	itemList[0].URL = hostRawURL + "/new"
+	itemList[0].Blom = &blomMeta{Aliases: []string{hostRawURL + "/old", "/2017/older/", hostRawURL + "/new"}}
+	itemList[1].URL = hostRawURL + "/other"
+
+	redirects, err := redirectList(itemList)
+	if err != nil {
+		t.Errorf("Error (%s) when all parameters valid.", err.Error())
+	}
+	expected := []redirect{{"/2017/older", itemList[0].URL}, {"/old", itemList[0].URL}}
+	if len(redirects) != len(expected) {
+		t.Fatalf("Wrong redirect count, expected %v, actual %v", expected, redirects)
+	}
+	for i, r := range redirects {
+		if r != expected[i] {
+			t.Errorf("Wrong redirect at index %v, expected %v, actual %v", i, expected[i], r)
+		}
+	}
+
+	itemList[1].Blom = &blomMeta{Aliases: []string{"/new"}}
+	_, err = redirectList(itemList)
+	if err == nil {
+		t.Errorf("No error for alias matching another article")
+	}
+
+	itemList[1].Blom = &blomMeta{Aliases: []string{"https://other.example/x"}}
+	_, err = redirectList(itemList)
+	if err == nil {
+		t.Errorf("No error for alias on another host")
+	}
+}
+
+var redirectFileTests = []struct {
+	format string
+	line   string
+}{
+	{redirectFormatNetlify, "/old http://ratan.blog/new 301"},
+	{redirectFormatNginx, "location ~ ^/old/?$ { return 301 http://ratan.blog/new; }"},
+	{redirectFormatApache, "RedirectMatch 301 ^/old/?$ http://ratan.blog/new"},
+}
+
+func TestRedirectFileLines(t *testing.T) {
+	redirects := []redirect{{"/old", hostRawURL + "/new"}}
+	for _, s := range redirectFileTests {
+		lines, err := redirectFileLines(redirects, s.format)
+		if err != nil {
+			t.Errorf("Error (%s) for format '%s'", err.Error(), s.format)
+		}
+		if len(lines) != 1 || lines[0] != s.line {
+			t.Errorf("Wrong lines for format '%s', expected '%s', actual %v", s.format, s.line, lines)
+		}
+	}
+	_, err := redirectFileLines(redirects, "lighttpd")
+	if err == nil {
+		t.Errorf("No error for unsupported format")
+	}
+}
+
+func TestProcessRedirects(t *testing.T) {
+	blogPath := setupArticlePath(t)
+	conf.RedirectFormat = redirectFormatNetlify
+	itemList := make([]jsfItem, 1)
+	itemList[0].URL = hostRawURL + "/new"
+	itemList[0].Blom = &blomMeta{Aliases: []string{hostRawURL + "/2017/old"}}
+
+	ch := make(chan error, 1)
+	var wg sync.WaitGroup
+	wg.Add(1)
+	processRedirects(&wg, itemList, blogPath, ch)
+	select {
+	case err := <-ch:
+		t.Errorf("Error (%s) when all parameters valid.", err.Error())
+	default:
+	}
+
+	stub, err := ioutil.ReadFile(filepath.Join(blogPath, "2017", "old", finalWebpageFile))
+	if err != nil {
+		t.Errorf("Error (%s) reading redirect stub", err.Error())
+	}
+	if !strings.Contains(string(stub), "url="+itemList[0].URL) {
+		t.Errorf("No refresh to new URL in stub:\n%s", stub)
+	}
+	if _, err := os.Stat(filepath.Join(blogPath, redirectFiles[redirectFormatNetlify])); err != nil {
+		t.Errorf("Error (%s) finding redirects file", err.Error())
+	}
+	conf = blogConfig{}
+	teardownArticlePath(t, blogPath)
+}
+
+func TestProcessArticleRename(t *testing.T) {
+	tmpl := template.Must(template.New("Whatever").Parse("{{.Title}}"))
+	blogPath := setupArticlePath(t)
+	oldPath := filepath.Join(blogPath, "hello")
+	err := os.Mkdir(oldPath, 0777)
+	if err != nil {
+		t.Errorf("Error (%s) BEFORE STARTING TEST.", err.Error())
+	}
+	err = ioutil.WriteFile(filepath.Join(oldPath, contentFileHTML), []byte("<p>Hi</p>"), 0664)
+	if err != nil {
+		t.Errorf("Error (%s) BEFORE STARTING TEST.", err.Error())
+	}
+	oldItem, err := processArticle(tmpl, blogPath, oldPath, articleFlags{title: "Hello"})
+	if err != nil {
+		t.Errorf("Error (%s) BEFORE STARTING TEST.", err.Error())
+	}
+
+	newPath := filepath.Join(blogPath, "greetings")
+	err = os.Rename(oldPath, newPath)
+	if err != nil {
+		t.Errorf("Error (%s) BEFORE STARTING TEST.", err.Error())
+	}
+	newItem, err := processArticle(tmpl, blogPath, newPath, articleFlags{aliasList: "/hi"})
+	if err != nil {
+		t.Errorf("Error (%s) when all parameters valid.", err.Error())
+	}
+	if newItem.ID != oldItem.ID {
+		t.Errorf("ID changed on rename, expected '%s', actual '%s'", oldItem.ID, newItem.ID)
+	}
+	if newItem.Blom == nil || len(newItem.Blom.Aliases) != 2 || newItem.Blom.Aliases[0] != "/hi" || newItem.Blom.Aliases[1] != oldItem.URL {
+		t.Errorf("Wrong aliases after rename: %v", newItem.Blom)
+	}
+	teardownArticlePath(t, blogPath)
+}
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"time"
+)
+
+const sourceDateEpochEnv = "SOURCE_DATE_EPOCH"
+
+var fixedNow time.Time //Zero unless the build is reproducible
+
+func parseNow(s string) (time.Time, error) {
+	if seconds, err := strconv.ParseInt(s, 10, 64); err == nil {
+		return time.Unix(seconds, 0).UTC(), nil
+	}
+	t, err := time.Parse(time.RFC3339, s)
+	if err != nil {
+		return t, fmt.Errorf("'%s' is neither seconds since the epoch nor an RFC 3339 time", s)
+	}
+	return t, nil
+}
+
+func reproducibleNow(nowFlag string) (time.Time, error) {
+	if len(nowFlag) > 0 {
+		return parseNow(nowFlag)
+	}
+	if epoch := os.Getenv(sourceDateEpochEnv); len(epoch) > 0 {
+		return parseNow(epoch)
+	}
+	return time.Time{}, nil
+}
+
+func buildTime() time.Time {
+	if fixedNow.IsZero() {
+		return time.Now()
+	}
+	return fixedNow
+}
+
+func clampTime(t time.Time) time.Time {
+	if !fixedNow.IsZero() && t.After(fixedNow) {
+		return fixedNow //A fresh checkout shouldn't count as a modification
+	}
+	return t
+}
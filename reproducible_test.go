@@ -0,0 +1,117 @@
+package main
+
+import (
+	"html/template"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+var parseNowTests = []struct {
+	input  string
+	output time.Time
+}{
+	{"1497088800", time.Date(2017, 6, 10, 10, 0, 0, 0, time.UTC)},
+	{"2017-06-10T10:00:00Z", time.Date(2017, 6, 10, 10, 0, 0, 0, time.UTC)},
+}
+
+func TestParseNow(t *testing.T) {
+	for _, test := range parseNowTests {
+		res, err := parseNow(test.input)
+		if err != nil {
+			t.Errorf("Error (%s) for '%s' when all parameters valid.", err.Error(), test.input)
+		}
+		if !res.Equal(test.output) {
+			t.Errorf("Wrong time for '%s', expected %v, actual %v", test.input, test.output, res)
+		}
+	}
+	if _, err := parseNow("last tuesday"); err == nil {
+		t.Errorf("No error for invalid time.")
+	}
+}
+
+func TestReproducibleNow(t *testing.T) {
+	os.Setenv(sourceDateEpochEnv, "1497088800")
+	defer os.Unsetenv(sourceDateEpochEnv)
+	res, err := reproducibleNow("")
+	if err != nil || res.Unix() != 1497088800 {
+		t.Errorf("Wrong time from %s: %v, %v", sourceDateEpochEnv, res, err)
+	}
+	res, err = reproducibleNow("1500000000")
+	if err != nil || res.Unix() != 1500000000 {
+		t.Errorf("Flag didn't override %s: %v, %v", sourceDateEpochEnv, res, err)
+	}
+	os.Unsetenv(sourceDateEpochEnv)
+	res, err = reproducibleNow("")
+	if err != nil || !res.IsZero() {
+		t.Errorf("Fixed time without flag or %s: %v, %v", sourceDateEpochEnv, res, err)
+	}
+}
+
+func TestClampTime(t *testing.T) {
+	past := time.Date(2017, 6, 10, 10, 0, 0, 0, time.UTC)
+	future := past.Add(time.Hour)
+	if res := clampTime(future); !res.Equal(future) {
+		t.Errorf("Time clamped without a fixed build time: %v", res)
+	}
+	fixedNow = past
+	if res := clampTime(future); !res.Equal(past) {
+		t.Errorf("Time not clamped, expected %v, actual %v", past, res)
+	}
+	if res := buildTime(); !res.Equal(past) {
+		t.Errorf("Wrong build time, expected %v, actual %v", past, res)
+	}
+	fixedNow = time.Time{}
+}
+
+func snapshotDir(t *testing.T, dir string) map[string]string {
+	res := make(map[string]string)
+	err := filepath.Walk(dir, func(curPath string, info os.FileInfo, err error) error {
+		if err != nil || info.IsDir() {
+			return err
+		}
+		b, err := ioutil.ReadFile(curPath)
+		res[curPath] = string(b)
+		return err
+	})
+	if err != nil {
+		t.Errorf("Error (%s) reading output.", err.Error())
+	}
+	return res
+}
+
+func TestProcessBlogReproducible(t *testing.T) {
+	fixedNow = time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	defer func() { fixedNow = time.Time{} }()
+	itemContent := []byte(`{"title": "Same day", "date_published": "2017-06-10T10:00:00Z", "tags": ["a", "b"]}`)
+	blogPath, subdirPaths := setupBlog(t, itemContent, []byte("Some *content*"), 4, 4)
+	defer os.RemoveAll(blogPath)
+	for _, dir := range []string{"feeds", "tags", "archive"} {
+		os.Mkdir(filepath.Join(blogPath, dir), 0775)
+	}
+	attachPath := filepath.Join(subdirPaths[0], attachmentDir)
+	os.Mkdir(attachPath, 0775)
+	for _, name := range []string{"c.txt", "a.txt", "b.txt", "d.txt"} {
+		ioutil.WriteFile(filepath.Join(attachPath, name), []byte("Attachment "+name), 0664)
+	}
+
+	tmpl := template.Must(template.New("Whatever").Parse("{{.Title}}\n{{.Date}}\n{{.Today}}\n{{.ContentHTML}}"))
+	var snapshots []map[string]string
+	for i := 0; i < 2; i++ {
+		err := processBlog(tmpl, tmpl, blogPath)
+		if err != nil {
+			t.Fatalf("Error (%s) when all parameters valid.", err.Error())
+		}
+		snapshots = append(snapshots, snapshotDir(t, blogPath))
+	}
+	if len(snapshots[0]) != len(snapshots[1]) {
+		t.Errorf("Different file counts, %v and %v", len(snapshots[0]), len(snapshots[1]))
+	}
+	for p, first := range snapshots[0] {
+		if second := snapshots[1][p]; first != second {
+			t.Errorf("Output '%s' differs between runs:\n%s\n---\n%s", p, first, second)
+		}
+	}
+}
@@ -0,0 +1,224 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"golang.org/x/net/html"
+	"log"
+	"net/url"
+	"sort"
+	"strings"
+)
+
+type sanitizePolicy struct {
+	Tags       []string            `json:"tags"`
+	Attributes map[string][]string `json:"attributes"` //By tag, "*" for every tag
+	URLSchemes []string            `json:"url_schemes"`
+	tags       map[string]bool
+	attributes map[string]bool //Keyed by "tag attribute"
+	schemes    map[string]bool
+}
+
+type sanitizeConfig struct {
+	Content *sanitizePolicy `json:"content"` //Defaults if unset
+	Feed    *sanitizePolicy `json:"feed"`
+}
+
+var dropContentTags = map[string]bool{"script": true, "style": true, "iframe": true, "object": true, "embed": true, "noscript": true, "template": true}
+
+var urlAttributes = map[string]bool{"href": true, "src": true, "cite": true, "poster": true, "action": true, "formaction": true, "longdesc": true}
+
+var feedTags = []string{"a", "abbr", "b", "blockquote", "br", "caption", "cite", "code", "dd", "del", "dfn", "dl", "dt", "em", "figcaption", "figure", "h1", "h2", "h3", "h4", "h5", "h6", "hr", "i", "img", "ins", "kbd", "li", "mark", "ol", "p", "pre", "q", "s", "samp", "small", "span", "strong", "sub", "sup", "table", "tbody", "td", "tfoot", "th", "thead", "time", "tr", "u", "ul"}
+
+var feedAttributes = map[string][]string{
+	"*":          {"id", "title", "lang", "dir"},
+	"a":          {"href"},
+	"img":        {"src", "srcset", "sizes", "alt", "width", "height", "loading"},
+	"td":         {"colspan", "rowspan"},
+	"th":         {"colspan", "rowspan"},
+	"ol":         {"start", "reversed"},
+	"time":       {"datetime"},
+	"blockquote": {"cite"},
+	"q":          {"cite"},
+	"del":        {"cite", "datetime"},
+	"ins":        {"cite", "datetime"},
+}
+
+func defaultFeedPolicy() sanitizePolicy {
+	return sanitizePolicy{Tags: feedTags, Attributes: feedAttributes, URLSchemes: []string{"http", "https", "mailto"}}
+}
+
+func defaultContentPolicy() sanitizePolicy {
+	res := defaultFeedPolicy()
+	res.Tags = append(append([]string(nil), feedTags...), "div", "nav", "section", "article", "aside", "header", "footer", "details", "summary", "picture", "source", "audio", "video", "track", "col", "colgroup")
+	res.Attributes = map[string][]string{
+		"*":      append(append([]string(nil), feedAttributes["*"]...), "class"), //No style, it can restyle the whole page
+		"a":      {"href", "rel"},
+		"source": {"src", "srcset", "sizes", "type", "media"},
+		"audio":  {"src", "controls", "preload"},
+		"video":  {"src", "controls", "preload", "poster", "width", "height"},
+		"track":  {"src", "kind", "srclang", "label", "default"},
+	}
+	for tag, attrs := range feedAttributes {
+		if _, ok := res.Attributes[tag]; !ok {
+			res.Attributes[tag] = attrs
+		}
+	}
+	return res
+}
+
+func (sc sanitizeConfig) contentPolicy() sanitizePolicy {
+	res := defaultContentPolicy()
+	if sc.Content != nil {
+		res = *sc.Content
+	}
+	res.compile()
+	return res
+}
+
+func (sc sanitizeConfig) feedPolicy() sanitizePolicy {
+	res := defaultFeedPolicy()
+	if sc.Feed != nil {
+		res = *sc.Feed
+	}
+	res.compile()
+	return res
+}
+
+func (sp *sanitizePolicy) compile() {
+	sp.tags = make(map[string]bool)
+	for _, tag := range sp.Tags {
+		sp.tags[strings.ToLower(tag)] = true
+	}
+	sp.attributes = make(map[string]bool)
+	for tag, attrs := range sp.Attributes {
+		for _, attr := range attrs {
+			sp.attributes[strings.ToLower(tag)+" "+strings.ToLower(attr)] = true
+		}
+	}
+	sp.schemes = make(map[string]bool)
+	for _, scheme := range sp.URLSchemes {
+		sp.schemes[strings.ToLower(scheme)] = true
+	}
+}
+
+func (sp *sanitizePolicy) allowedURL(rawURL string) bool {
+	cleaned := strings.Map(func(r rune) rune {
+		if r <= ' ' || r == 0x7f {
+			return -1 //Browsers ignore these, so "java\tscript:" is still javascript
+		}
+		return r
+	}, rawURL)
+	u, err := url.Parse(cleaned)
+	if err != nil {
+		return false
+	}
+	return len(u.Scheme) < 1 || sp.schemes[strings.ToLower(u.Scheme)]
+}
+
+func (sp *sanitizePolicy) allowedSrcset(srcset string) bool {
+	for _, candidate := range strings.Split(srcset, ",") {
+		fields := strings.Fields(candidate)
+		if len(fields) > 0 && !sp.allowedURL(fields[0]) {
+			return false
+		}
+	}
+	return true
+}
+
+func (sp *sanitizePolicy) allowedAttr(tag string, attr html.Attribute, removed map[string]int) bool {
+	if !sp.attributes[tag+" "+attr.Key] && !sp.attributes["* "+attr.Key] {
+		removed[fmt.Sprintf("%s attribute on <%s>", attr.Key, tag)]++
+		return false
+	}
+	if (urlAttributes[attr.Key] && !sp.allowedURL(attr.Val)) || (attr.Key == "srcset" && !sp.allowedSrcset(attr.Val)) {
+		removed[fmt.Sprintf("%s URL '%s' on <%s>", attr.Key, attr.Val, tag)]++
+		return false
+	}
+	return true
+}
+
+func writeStartTag(b *bytes.Buffer, tok html.Token, attrs []html.Attribute) {
+	b.WriteString("<" + tok.Data)
+	for _, attr := range attrs {
+		b.WriteString(" " + attr.Key + "=\"" + html.EscapeString(attr.Val) + "\"")
+	}
+	if tok.Type == html.SelfClosingTagToken {
+		b.WriteString(" /")
+	}
+	b.WriteString(">")
+}
+
+func sanitizeHTML(content []byte, sp sanitizePolicy) ([]byte, []string) {
+	var b bytes.Buffer
+	removed := make(map[string]int)
+	skipping := ""
+	z := html.NewTokenizer(bytes.NewReader(content))
+	for {
+		tt := z.Next()
+		if tt == html.ErrorToken {
+			break
+		}
+		tok := z.Token()
+		if len(skipping) > 0 {
+			if tt == html.EndTagToken && tok.Data == skipping {
+				skipping = ""
+			}
+			continue
+		}
+		switch tt {
+		case html.TextToken:
+			b.WriteString(html.EscapeString(tok.Data))
+		case html.StartTagToken, html.SelfClosingTagToken:
+			if !sp.tags[tok.Data] {
+				removed["<"+tok.Data+">"]++
+				if dropContentTags[tok.Data] && tt == html.StartTagToken {
+					skipping = tok.Data
+				}
+				continue
+			}
+			attrs := make([]html.Attribute, 0, len(tok.Attr))
+			for _, attr := range tok.Attr {
+				if sp.allowedAttr(tok.Data, attr, removed) {
+					attrs = append(attrs, attr)
+				}
+			}
+			writeStartTag(&b, tok, attrs)
+		case html.EndTagToken:
+			if sp.tags[tok.Data] {
+				b.WriteString("</" + tok.Data + ">")
+			}
+		case html.CommentToken:
+			removed["comments"]++
+		case html.DoctypeToken:
+			removed["doctype"]++
+		}
+	}
+	report := make([]string, 0, len(removed))
+	for what, count := range removed {
+		report = append(report, fmt.Sprintf("%s (%d)", what, count))
+	}
+	sort.Strings(report)
+	return b.Bytes(), report
+}
+
+func logSanitizeReport(what string, report []string) {
+	if len(report) > 0 {
+		log.Printf("Sanitised %s, removed: %s", what, strings.Join(report, ", "))
+	}
+}
+
+func sanitizeFeedItems(itemList []jsfItem) []jsfItem {
+	if conf.Sanitize == nil {
+		return itemList
+	}
+	feedPolicy := conf.Sanitize.feedPolicy()
+	res := make([]jsfItem, len(itemList))
+	for i, ji := range itemList {
+		content, report := sanitizeHTML([]byte(ji.ContentHTML), feedPolicy)
+		logSanitizeReport(fmt.Sprintf("feed content of '%s'", ji.URL), report)
+		ji.ContentHTML = string(content)
+		res[i] = ji
+	}
+	return res
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"encoding/json"
+	"html/template"
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+var sanitizeContentTests = []struct {
+	input  string
+	output string
+}{
+	{"<p class=\"x\">Hello <b>there</b></p>", "<p class=\"x\">Hello <b>there</b></p>"},
+	{"<p>a</p><script>alert('hi')</script><p>b</p>", "<p>a</p><p>b</p>"},
+	{"<a href=\"/x\" onclick=\"evil()\">x</a>", "<a href=\"/x\">x</a>"},
+	{"<a href=\"java\tscript:evil()\">x</a>", "<a>x</a>"},
+	{"<a href=\"https://ratan.blog/\">x</a>", "<a href=\"https://ratan.blog/\">x</a>"},
+	{"<blink>Old</blink> &amp; <br/>", "Old &amp; <br />"},
+	{"<p>a<!--more-->b</p>", "<p>ab</p>"},
+	{"<img src=\"a.png\" srcset=\"a.png 1x, javascript:x 2x\" alt=\"A\">", "<img src=\"a.png\" alt=\"A\">"},
+	{"<iframe src=\"https://example.com\"><p>inside</p></iframe><p>after</p>", "<p>after</p>"},
+	{"<div class=\"x\" style=\"position:fixed\">Hi</div>", "<div class=\"x\">Hi</div>"},
+}
+
+func TestSanitizeHTMLContent(t *testing.T) {
+	contentPolicy := sanitizeConfig{}.contentPolicy()
+	for _, test := range sanitizeContentTests {
+		res, _ := sanitizeHTML([]byte(test.input), contentPolicy)
+		if string(res) != test.output {
+			t.Errorf("Wrong output for '%s', expected '%s', actual '%s'", test.input, test.output, res)
+		}
+	}
+}
+
+func TestSanitizeHTMLFeed(t *testing.T) {
+	feedPolicy := sanitizeConfig{}.feedPolicy()
+	input := "<div class=\"x\"><p style=\"color:red\" id=\"a\">Hi</p><script>evil()</script><a href=\"/p\" onclick=\"evil()\">p</a></div>"
+	res, report := sanitizeHTML([]byte(input), feedPolicy)
+	expected := "<p id=\"a\">Hi</p><a href=\"/p\">p</a>"
+	if string(res) != expected {
+		t.Errorf("Wrong feed output, expected '%s', actual '%s'", expected, res)
+	}
+	expectedReport := []string{"<div> (1)", "<script> (1)", "onclick attribute on <a> (1)", "style attribute on <p> (1)"}
+	if !reflect.DeepEqual(report, expectedReport) {
+		t.Errorf("Wrong report, expected %v, actual %v", expectedReport, report)
+	}
+}
+
+func TestSanitizeConfigPolicies(t *testing.T) {
+	var sc sanitizeConfig
+	err := json.Unmarshal([]byte(`{"feed": {"tags": ["p", "a"], "attributes": {"a": ["href"]}, "url_schemes": ["https"]}}`), &sc)
+	if err != nil {
+		t.Errorf("Error (%s) PRIOR TO RUNNING TEST.", err.Error())
+	}
+	contentPolicy, feedPolicy := sc.contentPolicy(), sc.feedPolicy()
+	input := "<p><em>Hi</em> <a href=\"http://ratan.blog\">there</a></p>"
+	if res, _ := sanitizeHTML([]byte(input), feedPolicy); string(res) != "<p>Hi <a>there</a></p>" {
+		t.Errorf("Wrong output from configured policy: '%s'", res)
+	}
+	if res, _ := sanitizeHTML([]byte(input), contentPolicy); string(res) != input {
+		t.Errorf("Default content policy changed by feed policy: '%s'", res)
+	}
+}
+
+func TestSanitizeFeedItems(t *testing.T) {
+	itemList := []jsfItem{{ContentHTML: "<p onclick=\"x()\">Hi</p>"}}
+	if res := sanitizeFeedItems(itemList); res[0].ContentHTML != itemList[0].ContentHTML {
+		t.Errorf("Content sanitised without config: '%s'", res[0].ContentHTML)
+	}
+	conf.Sanitize = &sanitizeConfig{}
+	res := sanitizeFeedItems(itemList)
+	if res[0].ContentHTML != "<p>Hi</p>" || itemList[0].ContentHTML != "<p onclick=\"x()\">Hi</p>" {
+		t.Errorf("Wrong sanitised items: '%s', original '%s'", res[0].ContentHTML, itemList[0].ContentHTML)
+	}
+	conf = blogConfig{}
+}
+
+func TestProcessArticleSanitize(t *testing.T) {
+	conf.Sanitize = &sanitizeConfig{}
+	defer func() { conf = blogConfig{} }()
+	tmpl := template.Must(template.New("Whatever").Parse("{{.ContentHTML}}"))
+	articlePath := setupArticlePath(t)
+	defer teardownArticlePath(t, articlePath)
+	articlePath, _ = filepath.Rel(".", articlePath)
+	err := ioutil.WriteFile(filepath.Join(articlePath, contentFileHTML), []byte("<p>Guest post<script>evil()</script></p><!--more--><p>Rest</p>"), 0664)
+	if err != nil {
+		t.Errorf("Error (%s) BEFORE STARTING TEST.", err.Error())
+	}
+
+	ji, err := processArticle(tmpl, ".", articlePath, articleFlags{title: "Guest"})
+	if err != nil {
+		t.Errorf("Error (%s) when all parameters valid", err.Error())
+	}
+	expected := "<p>Guest post</p><p>Rest</p>"
+	if ji.ContentHTML != expected {
+		t.Errorf("Wrong content, expected '%s', actual '%s'", expected, ji.ContentHTML)
+	}
+	if ji.Summary != "Guest post" {
+		t.Errorf("Wrong summary, expected 'Guest post', actual '%s'", ji.Summary)
+	}
+	page, _ := ioutil.ReadFile(filepath.Join(articlePath, finalWebpageFile))
+	if string(page) != expected {
+		t.Errorf("Wrong page, expected '%s', actual '%s'", expected, page)
+	}
+}
@@ -0,0 +1,398 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"image"
+	"io/ioutil"
+	"net/http"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+const exifHeader = "Exif\x00\x00"
+const exifOrientationTag = 0x0112
+const exifGPSTag = 0x8825
+const pngSignature = "\x89PNG\r\n\x1a\n"
+const stripReportFile = "strip-report.txt"
+
+var xmpHeaders = []string{"http://ns.adobe.com/xap/1.0/\x00", "http://ns.adobe.com/xmp/extension/\x00"}
+
+var keptJPEGMarkers = map[byte]bool{0xe0: true, 0xe2: true, 0xee: true} //JFIF, ICC profile, Adobe colour transform
+
+func tiffOrder(tiff []byte) binary.ByteOrder {
+	if len(tiff) < 8 {
+		return nil
+	}
+	switch string(tiff[:4]) {
+	case "II*\x00":
+		return binary.LittleEndian
+	case "MM\x00*":
+		return binary.BigEndian
+	}
+	return nil
+}
+
+func ifdEntries(tiff []byte, order binary.ByteOrder, offset int) map[uint16][]byte {
+	res := make(map[uint16][]byte)
+	if offset < 8 || offset+2 > len(tiff) {
+		return res
+	}
+	count := int(order.Uint16(tiff[offset:]))
+	for i := 0; i < count; i++ {
+		entry := offset + 2 + i*12
+		if entry+12 > len(tiff) {
+			break
+		}
+		res[order.Uint16(tiff[entry:])] = tiff[entry : entry+12]
+	}
+	return res
+}
+
+func entryValue(order binary.ByteOrder, entry []byte) uint32 {
+	if order.Uint16(entry[2:]) == 3 { //SHORT
+		return uint32(order.Uint16(entry[8:]))
+	}
+	return order.Uint32(entry[8:])
+}
+
+func entryString(tiff []byte, order binary.ByteOrder, entry []byte) string {
+	count := int(order.Uint32(entry[4:]))
+	data := entry[8:12]
+	if count > 4 {
+		offset := int(order.Uint32(entry[8:]))
+		if offset < 0 || offset+count > len(tiff) {
+			return ""
+		}
+		data = tiff[offset : offset+count]
+	} else {
+		data = data[:count]
+	}
+	return strings.TrimRight(string(data), "\x00 ")
+}
+
+func exifTags(tiff []byte) map[uint16]uint32 {
+	res := make(map[uint16]uint32)
+	order := tiffOrder(tiff)
+	if order == nil {
+		return res
+	}
+	for tag, entry := range ifdEntries(tiff, order, int(order.Uint32(tiff[4:8]))) {
+		res[tag] = entryValue(order, entry)
+	}
+	return res
+}
+
+func findEXIF(content []byte, mimeType string) []byte {
+	switch mimeType {
+	case "image/jpeg":
+		for i := 2; i+4 <= len(content) && content[i] == 0xff; {
+			marker := content[i+1]
+			if marker == 0xff {
+				i++
+				continue
+			}
+			end := i + 2 + int(binary.BigEndian.Uint16(content[i+2:]))
+			if marker == 0xda || marker == 0xd9 || end > len(content) || end < i+4 {
+				return nil
+			}
+			if marker == 0xe1 && bytes.HasPrefix(content[i+4:end], []byte(exifHeader)) {
+				return content[i+4+len(exifHeader) : end]
+			}
+			i = end
+		}
+	case "image/png":
+		for i := len(pngSignature); i+12 <= len(content); {
+			end := i + 12 + int(binary.BigEndian.Uint32(content[i:]))
+			if end > len(content) || end < i+12 {
+				return nil
+			}
+			if string(content[i+4:i+8]) == "eXIf" {
+				return content[i+8 : end-4]
+			}
+			i = end
+		}
+	}
+	return nil
+}
+
+func orientationTIFF(orientation int) []byte {
+	b := []byte("MM\x00*\x00\x00\x00\x08\x00\x01")
+	b = append(b, 0x01, 0x12, 0x00, 0x03, 0x00, 0x00, 0x00, 0x01, 0x00, byte(orientation), 0x00, 0x00)
+	return append(b, 0x00, 0x00, 0x00, 0x00) //No next IFD
+}
+
+func describeEXIF(tags map[uint16]uint32) string {
+	if _, ok := tags[exifGPSTag]; ok {
+		return "EXIF (with GPS)"
+	}
+	return "EXIF"
+}
+
+func validOrientation(tags map[uint16]uint32) int {
+	orientation := int(tags[exifOrientationTag])
+	if orientation < 1 || orientation > 8 {
+		return 1
+	}
+	return orientation
+}
+
+func jpegSegment(segment []byte, marker byte) ([]byte, string, int) {
+	payload := segment[4:]
+	switch {
+	case keptJPEGMarkers[marker]:
+		return segment, "", 0
+	case marker == 0xe1 && bytes.HasPrefix(payload, []byte(exifHeader)):
+		tags := exifTags(payload[len(exifHeader):])
+		return nil, describeEXIF(tags), validOrientation(tags)
+	case marker == 0xe1:
+		for _, header := range xmpHeaders {
+			if bytes.HasPrefix(payload, []byte(header)) {
+				return nil, "XMP", 0
+			}
+		}
+		return nil, "APP1", 0
+	case marker == 0xed:
+		return nil, "IPTC", 0
+	case marker == 0xfe:
+		return nil, "comment", 0
+	case marker >= 0xe0 && marker <= 0xef:
+		return nil, fmt.Sprintf("APP%d", marker-0xe0), 0
+	}
+	return segment, "", 0
+}
+
+func stripJPEG(content []byte) ([]byte, []string, int, error) {
+	if len(content) < 4 || content[0] != 0xff || content[1] != 0xd8 {
+		return nil, nil, 1, errors.New("not a JPEG file")
+	}
+	removed := make([]string, 0)
+	segments := make([][]byte, 0)
+	orientation := 1
+	i := 2
+	for {
+		if i+4 > len(content) || content[i] != 0xff {
+			return nil, nil, 1, errors.New("truncated JPEG file")
+		}
+		marker := content[i+1]
+		if marker == 0xff {
+			i++ //Fill byte
+			continue
+		}
+		if marker == 0xda || marker == 0xd9 { //Start of scan, the rest is image data
+			segments = append(segments, content[i:])
+			break
+		}
+		end := i + 2 + int(binary.BigEndian.Uint16(content[i+2:]))
+		if end > len(content) || end < i+4 {
+			return nil, nil, 1, errors.New("truncated JPEG file")
+		}
+		kept, what, o := jpegSegment(content[i:end], marker)
+		if kept != nil {
+			segments = append(segments, kept)
+		} else {
+			removed = append(removed, what)
+		}
+		if o > 1 {
+			orientation = o
+		}
+		i = end
+	}
+
+	var b bytes.Buffer
+	b.Write(content[:2])
+	for j, segment := range segments {
+		if j == 0 && segment[1] == 0xe0 {
+			b.Write(segment) //JFIF must come first
+			segment = nil
+		}
+		if j == 0 && orientation > 1 {
+			tiff := orientationTIFF(orientation)
+			b.Write([]byte{0xff, 0xe1})
+			binary.Write(&b, binary.BigEndian, uint16(2+len(exifHeader)+len(tiff)))
+			b.WriteString(exifHeader)
+			b.Write(tiff)
+		}
+		b.Write(segment)
+	}
+	return b.Bytes(), removed, orientation, nil
+}
+
+func writePNGChunk(b *bytes.Buffer, chunkType string, data []byte) {
+	binary.Write(b, binary.BigEndian, uint32(len(data)))
+	crc := crc32.NewIEEE()
+	crc.Write([]byte(chunkType))
+	crc.Write(data)
+	b.WriteString(chunkType)
+	b.Write(data)
+	binary.Write(b, binary.BigEndian, crc.Sum32())
+}
+
+func pngChunkMetadata(chunkType string, data []byte) string {
+	switch chunkType {
+	case "eXIf":
+		return describeEXIF(exifTags(data))
+	case "iTXt":
+		if bytes.HasPrefix(data, []byte("XML:com.adobe.xmp\x00")) {
+			return "XMP"
+		}
+		return "text"
+	case "tEXt", "zTXt":
+		return "text"
+	case "tIME":
+		return "timestamp"
+	}
+	return ""
+}
+
+func stripPNG(content []byte) ([]byte, []string, int, error) {
+	if !bytes.HasPrefix(content, []byte(pngSignature)) {
+		return nil, nil, 1, errors.New("not a PNG file")
+	}
+	removed := make([]string, 0)
+	orientation := 1
+	written := false
+	var b bytes.Buffer
+	b.WriteString(pngSignature)
+	i := len(pngSignature)
+	for i < len(content) {
+		if i+12 > len(content) {
+			return nil, nil, 1, errors.New("truncated PNG file")
+		}
+		end := i + 12 + int(binary.BigEndian.Uint32(content[i:]))
+		if end > len(content) || end < i+12 {
+			return nil, nil, 1, errors.New("truncated PNG file")
+		}
+		chunkType := string(content[i+4 : i+8])
+		data := content[i+8 : end-4]
+		if what := pngChunkMetadata(chunkType, data); len(what) > 0 {
+			removed = append(removed, what)
+			if chunkType == "eXIf" {
+				orientation = validOrientation(exifTags(data))
+			}
+			i = end
+			continue
+		}
+		if chunkType == "IDAT" && orientation > 1 && !written {
+			writePNGChunk(&b, "eXIf", orientationTIFF(orientation)) //Must come before the image data
+			written = true
+		}
+		b.Write(content[i:end])
+		i = end
+	}
+	return b.Bytes(), removed, orientation, nil
+}
+
+func stripMetadata(content []byte, mimeType string) ([]byte, []string, int, error) {
+	switch mimeType {
+	case "image/jpeg":
+		return stripJPEG(content)
+	case "image/png":
+		return stripPNG(content)
+	}
+	return content, nil, 1, nil
+}
+
+func imageOrientation(content []byte, mimeType string) int {
+	_, _, orientation, err := stripMetadata(content, mimeType)
+	if err != nil {
+		return 1
+	}
+	return orientation
+}
+
+func orientImage(img image.Image, orientation int) image.Image {
+	if orientation < 2 || orientation > 8 {
+		return img
+	}
+	b := img.Bounds()
+	w, h := b.Dx(), b.Dy()
+	dw, dh := w, h
+	if orientation >= 5 {
+		dw, dh = h, w
+	}
+	dst := image.NewRGBA(image.Rect(0, 0, dw, dh))
+	for y := 0; y < dh; y++ {
+		for x := 0; x < dw; x++ {
+			sx, sy := x, y
+			switch orientation {
+			case 2:
+				sx = w - 1 - x
+			case 3:
+				sx, sy = w-1-x, h-1-y
+			case 4:
+				sy = h - 1 - y
+			case 5:
+				sx, sy = y, x
+			case 6:
+				sx, sy = y, h-1-x
+			case 7:
+				sx, sy = w-1-y, h-1-x
+			case 8:
+				sx, sy = w-1-y, x
+			}
+			dst.Set(x, y, img.At(b.Min.X+sx, b.Min.Y+sy))
+		}
+	}
+	return dst
+}
+
+func writeStrippedFile(content []byte, mimeType, what, dst string) ([]string, error) {
+	stripped, removed, _, err := stripMetadata(content, mimeType)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %s", what, err.Error())
+	}
+	return removed, ioutil.WriteFile(dst, stripped, 0664)
+}
+
+func copyAttachment(attachPath, dst string) ([]string, error) {
+	if !conf.StripMetadata {
+		return nil, copyFile(attachPath, dst)
+	}
+	content, err := ioutil.ReadFile(attachPath)
+	if err != nil {
+		return nil, err
+	}
+	mimeType := attachmentMIMEType(attachPath, http.DetectContentType(content))
+	if _, ok := imageFormats[mimeType]; !ok {
+		return nil, copyFile(attachPath, dst)
+	}
+	return writeStrippedFile(content, mimeType, fmt.Sprintf("'%s'", attachPath), dst) //Unpublished images too, they're still served
+}
+
+func writeStripReport(blogPath string, itemList []jsfItem) error {
+	if !conf.StripMetadata {
+		return nil
+	}
+	lines := make([]string, 0)
+	for _, ji := range itemList {
+		lines = append(lines, ji.stripped...)
+	}
+	if len(lines) < 1 {
+		lines = append(lines, "Nothing removed.")
+	}
+	sort.Strings(lines)
+	return ioutil.WriteFile(filepath.Join(blogPath, stripReportFile), []byte(strings.Join(lines, "\n")+"\n"), 0664)
+}
+
+func (res *jsfItem) strippedAttachmentDetails(outputPath string) error {
+	for i, ja := range res.Attachments {
+		if _, ok := imageFormats[ja.MIMEType]; !ok {
+			continue
+		}
+		stripped, err := ioutil.ReadFile(filepath.Join(outputPath, attachmentDir, filepath.FromSlash(ja.path)))
+		if err != nil {
+			return err
+		}
+		res.Attachments[i].SizeInBytes = int64(len(stripped))
+		if ja.Blom != nil {
+			res.Attachments[i].Blom.SHA256 = fmt.Sprintf("%x", sha256.Sum256(stripped)) //Of the published file
+		}
+	}
+	return nil
+}
@@ -0,0 +1,266 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"html/template"
+	"image"
+	"image/color"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func exifWithGPS(orientation int) []byte {
+	b := []byte("II*\x00\x08\x00\x00\x00\x02\x00")
+	b = append(b, 0x12, 0x01, 0x03, 0x00, 0x01, 0x00, 0x00, 0x00, byte(orientation), 0x00, 0x00, 0x00)
+	b = append(b, 0x25, 0x88, 0x04, 0x00, 0x01, 0x00, 0x00, 0x00, 0x26, 0x00, 0x00, 0x00)
+	return append(b, 0x00, 0x00, 0x00, 0x00)
+}
+
+func jpegAppSegment(marker byte, payload []byte) []byte {
+	var b bytes.Buffer
+	b.Write([]byte{0xff, marker})
+	binary.Write(&b, binary.BigEndian, uint16(len(payload)+2))
+	b.Write(payload)
+	return b.Bytes()
+}
+
+func jpegWithMetadata(t *testing.T, orientation int) []byte {
+	plain := testImageBytes(t, 40, 20, "jpeg")
+	var b bytes.Buffer
+	b.Write(plain[:2])
+	b.Write(jpegAppSegment(0xe1, append([]byte(exifHeader), exifWithGPS(orientation)...)))
+	b.Write(jpegAppSegment(0xe1, []byte(xmpHeaders[0]+"<x:xmpmeta/>")))
+	b.Write(jpegAppSegment(0xed, []byte("Photoshop 3.0\x00IPTC")))
+	b.Write(jpegAppSegment(0xfe, []byte("Taken at home")))
+	b.Write(plain[2:])
+	return b.Bytes()
+}
+
+func pngWithMetadata(t *testing.T, orientation int) []byte {
+	plain := testImageBytes(t, 40, 20, "png")
+	ihdrEnd := len(pngSignature) + 12 + 13
+	var b bytes.Buffer
+	b.Write(plain[:ihdrEnd])
+	writePNGChunk(&b, "eXIf", exifWithGPS(orientation))
+	writePNGChunk(&b, "tEXt", []byte("Author\x00Ratan"))
+	writePNGChunk(&b, "iTXt", []byte("XML:com.adobe.xmp\x00\x00\x00\x00\x00<x:xmpmeta/>"))
+	b.Write(plain[ihdrEnd:])
+	return b.Bytes()
+}
+
+func TestStripJPEG(t *testing.T) {
+	res, removed, orientation, err := stripJPEG(jpegWithMetadata(t, 6))
+	if err != nil {
+		t.Errorf("Error (%s) when all parameters valid.", err.Error())
+	}
+	expected := []string{"EXIF (with GPS)", "XMP", "IPTC", "comment"}
+	if !reflect.DeepEqual(removed, expected) {
+		t.Errorf("Wrong report, expected %v, actual %v", expected, removed)
+	}
+	if orientation != 6 {
+		t.Errorf("Wrong orientation, expected 6, actual %d", orientation)
+	}
+	if bytes.Contains(res, []byte("Taken at home")) || bytes.Contains(res, []byte("xmpmeta")) || bytes.Contains(res, []byte{0x25, 0x88}) {
+		t.Errorf("Metadata left in stripped JPEG")
+	}
+	if imageOrientation(res, "image/jpeg") != 6 {
+		t.Errorf("Orientation not kept in stripped JPEG")
+	}
+	cfg, _, err := image.DecodeConfig(bytes.NewReader(res))
+	if err != nil || cfg.Width != 40 || cfg.Height != 20 {
+		t.Errorf("Stripped JPEG does not decode: %v %v", cfg, err)
+	}
+}
+
+func TestStripJPEGNoMetadata(t *testing.T) {
+	plain := testImageBytes(t, 40, 20, "jpeg")
+	res, removed, _, err := stripJPEG(plain)
+	if err != nil {
+		t.Errorf("Error (%s) when all parameters valid.", err.Error())
+	}
+	if len(removed) != 0 || !bytes.Equal(res, plain) {
+		t.Errorf("JPEG without metadata was changed, removed %v", removed)
+	}
+	_, _, _, err = stripJPEG(plain[:10])
+	if err == nil {
+		t.Errorf("No error for truncated JPEG")
+	}
+}
+
+func TestStripPNG(t *testing.T) {
+	res, removed, orientation, err := stripPNG(pngWithMetadata(t, 3))
+	if err != nil {
+		t.Errorf("Error (%s) when all parameters valid.", err.Error())
+	}
+	expected := []string{"EXIF (with GPS)", "text", "XMP"}
+	if !reflect.DeepEqual(removed, expected) {
+		t.Errorf("Wrong report, expected %v, actual %v", expected, removed)
+	}
+	if orientation != 3 || imageOrientation(res, "image/png") != 3 {
+		t.Errorf("Orientation not kept in stripped PNG")
+	}
+	if bytes.Contains(res, []byte("Ratan")) || bytes.Contains(res, []byte("xmpmeta")) {
+		t.Errorf("Metadata left in stripped PNG")
+	}
+	_, _, err = image.Decode(bytes.NewReader(res))
+	if err != nil {
+		t.Errorf("Stripped PNG does not decode: %s", err.Error())
+	}
+}
+
+var orientImageTests = []struct {
+	orientation int
+	expected    []color.Gray //Row by row
+	width       int
+}{
+	{1, []color.Gray{{1}, {2}, {3}, {4}, {5}, {6}}, 3},
+	{2, []color.Gray{{3}, {2}, {1}, {6}, {5}, {4}}, 3},
+	{3, []color.Gray{{6}, {5}, {4}, {3}, {2}, {1}}, 3},
+	{6, []color.Gray{{4}, {1}, {5}, {2}, {6}, {3}}, 2},
+	{8, []color.Gray{{3}, {6}, {2}, {5}, {1}, {4}}, 2},
+}
+
+func TestOrientImage(t *testing.T) {
+	img := image.NewGray(image.Rect(0, 0, 3, 2))
+	for i := range img.Pix {
+		img.Pix[i] = uint8(i + 1)
+	}
+	for _, test := range orientImageTests {
+		res := orientImage(img, test.orientation)
+		if res.Bounds().Dx() != test.width {
+			t.Errorf("Wrong width for orientation %d, expected %d, actual %d", test.orientation, test.width, res.Bounds().Dx())
+			continue
+		}
+		for i, expected := range test.expected {
+			actual := color.GrayModel.Convert(res.At(i%test.width, i/test.width)).(color.Gray)
+			if actual != expected {
+				t.Errorf("Wrong pixel %d for orientation %d, expected %v, actual %v", i, test.orientation, expected, actual)
+			}
+		}
+	}
+}
+
+func TestMakeResponsiveImageOrientation(t *testing.T) {
+	blogPath := setupArticlePath(t)
+	defer teardownArticlePath(t, blogPath)
+	ri, err := makeResponsiveImage(jpegWithMetadata(t, 6), "image/jpeg", blogPath, imageConfig{ThumbnailWidth: 10})
+	if err != nil {
+		t.Errorf("Error (%s) when all parameters valid.", err.Error())
+	}
+	if ri.Width != 20 || ri.Height != 40 {
+		t.Errorf("Wrong size, expected 20x40, actual %dx%d", ri.Width, ri.Height)
+	}
+	f, err := os.Open(filepath.Join(blogPath, imageDir, filepath.Base(ri.Thumbnail.URL)))
+	if err != nil {
+		t.Fatalf("Error (%s) opening thumbnail", err.Error())
+	}
+	defer f.Close()
+	cfg, _, err := image.DecodeConfig(f)
+	if err != nil || cfg.Width != 10 || cfg.Height != 20 {
+		t.Errorf("Thumbnail not turned upright: %v %v", cfg, err)
+	}
+}
+
+func TestProcessArticleStripMetadata(t *testing.T) {
+	conf.StripMetadata = true
+	defer func() { conf = blogConfig{} }()
+	tmpl := template.Must(template.New("Whatever").Parse("{{.ContentHTML}}"))
+	blogPath := setupArticlePath(t)
+	defer teardownArticlePath(t, blogPath)
+	articlePath := filepath.Join(blogPath, "trip")
+	err := os.MkdirAll(filepath.Join(articlePath, attachmentDir), 0777)
+	if err != nil {
+		t.Errorf("Error (%s) BEFORE STARTING TEST.", err.Error())
+	}
+	original := jpegWithMetadata(t, 1)
+	sourcePath := filepath.Join(articlePath, attachmentDir, "photo.jpg")
+	err = ioutil.WriteFile(sourcePath, original, 0664)
+	if err != nil {
+		t.Errorf("Error (%s) BEFORE STARTING TEST.", err.Error())
+	}
+	err = ioutil.WriteFile(filepath.Join(articlePath, contentFileHTML), []byte("<img src=\"attachments/photo.jpg\">"), 0664)
+	if err != nil {
+		t.Errorf("Error (%s) BEFORE STARTING TEST.", err.Error())
+	}
+
+	_, err = processArticle(tmpl, blogPath, articlePath, articleFlags{title: "Trip"})
+	if err == nil {
+		t.Errorf("No error for stripping metadata where the originals are published")
+	}
+
+	conf.Permalink = "/:slug/"
+	ji, err := processArticle(tmpl, blogPath, articlePath, articleFlags{title: "Trip", slug: "journey"})
+	if err != nil {
+		t.Fatalf("Error (%s) when all parameters valid.", err.Error())
+	}
+	strippedURL := hostRawURL + "/journey/" + attachmentDir + "/photo.jpg"
+	if len(ji.Attachments) != 1 || ji.Attachments[0].URL != strippedURL {
+		t.Fatalf("Wrong attachments, expected URL '%s', actual %v", strippedURL, ji.Attachments)
+	}
+	stripped, err := ioutil.ReadFile(filepath.Join(blogPath, "journey", attachmentDir, "photo.jpg"))
+	if err != nil {
+		t.Errorf("Error (%s) reading stripped copy", err.Error())
+	}
+	if bytes.Contains(stripped, []byte("Taken at home")) || int64(len(stripped)) != ji.Attachments[0].SizeInBytes {
+		t.Errorf("Wrong stripped copy, %d bytes, attachment says %d", len(stripped), ji.Attachments[0].SizeInBytes)
+	}
+	source, _ := ioutil.ReadFile(sourcePath)
+	if !bytes.Equal(source, original) {
+		t.Errorf("Source attachment was changed")
+	}
+	report, err := ioutil.ReadFile(filepath.Join(blogPath, stripReportFile))
+	if err != nil || !strings.HasPrefix(string(report), "journey/"+attachmentDir+"/photo.jpg: EXIF (with GPS)") {
+		t.Errorf("Wrong strip report: '%s', %v", report, err)
+	}
+}
+
+func TestProcessArticleStripUnpublished(t *testing.T) {
+	conf.StripMetadata = true
+	conf.Permalink = "/:slug/"
+	conf.Attachments = attachmentRules{Publish: []string{"*.txt"}}
+	defer func() { conf = blogConfig{} }()
+	tmpl := template.Must(template.New("Whatever").Parse("{{.ContentHTML}}"))
+	blogPath := setupArticlePath(t)
+	defer teardownArticlePath(t, blogPath)
+	articlePath := filepath.Join(blogPath, "trip")
+	err := os.MkdirAll(filepath.Join(articlePath, attachmentDir), 0777)
+	if err != nil {
+		t.Fatalf("Error (%s) BEFORE STARTING TEST.", err.Error())
+	}
+	err = ioutil.WriteFile(filepath.Join(articlePath, attachmentDir, "photo.jpg"), jpegWithMetadata(t, 1), 0664)
+	if err != nil {
+		t.Fatalf("Error (%s) BEFORE STARTING TEST.", err.Error())
+	}
+	err = ioutil.WriteFile(filepath.Join(articlePath, attachmentDir, "notes.txt"), []byte("Notes"), 0664)
+	if err != nil {
+		t.Fatalf("Error (%s) BEFORE STARTING TEST.", err.Error())
+	}
+	err = ioutil.WriteFile(filepath.Join(articlePath, contentFileHTML), []byte("<p>Trip</p>"), 0664)
+	if err != nil {
+		t.Fatalf("Error (%s) BEFORE STARTING TEST.", err.Error())
+	}
+
+	ji, err := processArticle(tmpl, blogPath, articlePath, articleFlags{title: "Trip", slug: "journey"})
+	if err != nil {
+		t.Fatalf("Error (%s) when all parameters valid.", err.Error())
+	}
+	if len(ji.Attachments) != 1 || ji.Attachments[0].MIMEType == "image/jpeg" {
+		t.Errorf("Unpublished photo in attachments: %v", ji.Attachments)
+	}
+	copied, err := ioutil.ReadFile(filepath.Join(blogPath, "journey", attachmentDir, "photo.jpg"))
+	if err != nil {
+		t.Fatalf("Error (%s) reading copied photo", err.Error())
+	}
+	if _, removed, _, err := stripJPEG(copied); err != nil || len(removed) > 0 {
+		t.Errorf("Unpublished photo copied with metadata: %v %v", removed, err)
+	}
+	notes, err := ioutil.ReadFile(filepath.Join(blogPath, "journey", attachmentDir, "notes.txt"))
+	if err != nil || string(notes) != "Notes" {
+		t.Errorf("Other attachment not copied: '%s', %v", notes, err)
+	}
+}
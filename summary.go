@@ -0,0 +1,89 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"golang.org/x/net/html"
+	"strings"
+)
+
+const moreMarker = "<!--more-->"
+const defaultSummaryWords = 50
+const feedContentSummary = "summary"
+
+func htmlToText(content string) string {
+	var b bytes.Buffer
+	z := html.NewTokenizer(strings.NewReader(content))
+	skipDepth := 0
+	for {
+		tt := z.Next()
+		switch tt {
+		case html.ErrorToken:
+			return strings.Join(strings.Fields(b.String()), " ")
+		case html.StartTagToken, html.EndTagToken:
+			name, _ := z.TagName()
+			switch string(name) {
+			case "script", "style":
+				if tt == html.StartTagToken {
+					skipDepth++
+				} else if skipDepth > 0 {
+					skipDepth--
+				}
+			case "p", "br", "li", "h1", "h2", "h3", "h4", "h5", "h6", "div", "pre", "blockquote", "tr", "td", "th":
+				b.WriteByte(' ') //Otherwise words either side of a block run together
+			}
+		case html.SelfClosingTagToken:
+			b.WriteByte(' ')
+		case html.TextToken:
+			if skipDepth == 0 {
+				b.Write(z.Text())
+			}
+		}
+	}
+}
+
+func firstWords(text string, count int) (string, bool) {
+	words := strings.Fields(text)
+	if len(words) <= count {
+		return strings.Join(words, " "), false
+	}
+	return strings.Join(words[:count], " "), true
+}
+
+func articleSummary(explicit string, content []byte) string {
+	if len(explicit) > 0 {
+		return explicit
+	}
+	contentStr := string(content)
+	if i := strings.Index(contentStr, moreMarker); i >= 0 {
+		return htmlToText(contentStr[:i])
+	}
+	wordCount := conf.SummaryWords
+	if wordCount < 1 {
+		wordCount = defaultSummaryWords
+	}
+	res, truncated := firstWords(htmlToText(contentStr), wordCount)
+	if truncated {
+		res += "…"
+	}
+	return res
+}
+
+func summaryHTML(ji jsfItem) string {
+	return fmt.Sprintf("<p>%s</p>\n<p><a href=\"%s\">Read more</a></p>", html.EscapeString(ji.Summary), html.EscapeString(ji.URL))
+}
+
+func feedItem(ji jsfItem) jsfItem {
+	if conf.FeedContent == feedContentSummary && len(ji.Summary) > 0 {
+		ji.ContentHTML = summaryHTML(ji)
+	}
+	return ji
+}
+
+func feedItemList(itemList []jsfItem) []jsfItem {
+	res := make([]jsfItem, len(itemList))
+	for i, ji := range itemList {
+		res[i] = feedItem(ji)
+	}
+	return res
+}
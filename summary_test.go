@@ -0,0 +1,73 @@
+package main
+
+import (
+	"github.com/gorilla/feeds"
+	"strings"
+	"testing"
+)
+
+var htmlToTextTests = []struct {
+	input  string
+	output string
+}{
+	{"<h2>Heading</h2><p>Some <em>text</em> here.</p>", "Heading Some text here."},
+	{"<p>a &amp; b</p><script>alert(1)</script><p>c</p>", "a & b c"},
+	{"line<br/>break", "line break"},
+	{"", ""},
+}
+
+func TestHtmlToText(t *testing.T) {
+	for _, s := range htmlToTextTests {
+		res := htmlToText(s.input)
+		if res != s.output {
+			t.Errorf("Wrong text for '%s', expected '%s', actual '%s'", s.input, s.output, res)
+		}
+	}
+}
+
+func TestArticleSummary(t *testing.T) {
+	content := []byte("<p>First part.</p>\n<!--more-->\n<p>Second part.</p>")
+	if res := articleSummary("Explicit", content); res != "Explicit" {
+		t.Errorf("Explicit summary ignored, actual '%s'", res)
+	}
+	if res := articleSummary("", content); res != "First part." {
+		t.Errorf("Wrong excerpt before marker, expected 'First part.', actual '%s'", res)
+	}
+
+	conf.SummaryWords = 3
+	if res := articleSummary("", []byte("<p>one two three four</p>")); res != "one two three…" {
+		t.Errorf("Wrong word excerpt, expected 'one two three…', actual '%s'", res)
+	}
+	if res := articleSummary("", []byte("<p>one two</p>")); res != "one two" {
+		t.Errorf("Wrong short excerpt, expected 'one two', actual '%s'", res)
+	}
+	conf = blogConfig{}
+}
+
+func TestFeedItemSummaryMode(t *testing.T) {
+	var ji jsfItem
+	ji.URL = hostRawURL + "/hello"
+	ji.ContentHTML = "<p>Full content</p>"
+	ji.Summary = "Short & sweet"
+
+	if res := feedItem(ji); res.ContentHTML != ji.ContentHTML {
+		t.Errorf("Content changed in full mode: '%s'", res.ContentHTML)
+	}
+	var gi feeds.Item
+	fromJsfItem(&gi, ji)
+	if gi.Description != "Short &amp; sweet" || gi.Content != ji.ContentHTML {
+		t.Errorf("Wrong legacy item in full mode, description '%s', content '%s'", gi.Description, gi.Content)
+	}
+
+	conf.FeedContent = feedContentSummary
+	res := feedItem(ji)
+	if !strings.Contains(res.ContentHTML, "Short &amp; sweet") || strings.Contains(res.ContentHTML, "Full content") {
+		t.Errorf("Wrong content in summary mode: '%s'", res.ContentHTML)
+	}
+	gi = feeds.Item{}
+	fromJsfItem(&gi, ji)
+	if len(gi.Content) > 0 {
+		t.Errorf("Full content in legacy item in summary mode: '%s'", gi.Content)
+	}
+	conf = blogConfig{}
+}
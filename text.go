@@ -0,0 +1,492 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+const textFormat = "text"
+const textMarkupMarkdown = "markdown"
+const textMarkupPlain = "plain"
+const textIndexName = "index"
+
+var markdownEscaper = strings.NewReplacer("\\", "\\\\", "`", "\\`", "*", "\\*", "_", "\\_", "[", "\\[", "]", "\\]", "<", "\\<")
+
+var markdownContainerTags = map[string]bool{"div": true, "section": true, "article": true, "header": true, "footer": true, "main": true, "nav": true, "aside": true, "figure": true, "body": true}
+var markdownParagraphTags = map[string]bool{"p": true, "figcaption": true, "dt": true, "dd": true, "address": true}
+var markdownRawTags = map[string]bool{"table": true, "dl": true, "details": true, "video": true, "audio": true, "iframe": true, "object": true, "form": true, "svg": true, "math": true}
+
+type textConfig struct {
+	Markup string `json:"markup"` //"markdown" (default) or "plain"
+	Width  int    `json:"width"`  //Plain text only
+}
+
+type markdownWriter struct {
+	link func(string) string
+}
+
+func (tc textConfig) validate() error {
+	if tc.Markup != "" && tc.Markup != textMarkupMarkdown && tc.Markup != textMarkupPlain {
+		return fmt.Errorf("unsupported text markup '%s', use '%s' or '%s'", tc.Markup, textMarkupMarkdown, textMarkupPlain)
+	}
+	if tc.Width != 0 && tc.Width < minGopherWidth {
+		return fmt.Errorf("text width %d is below the minimum of %d", tc.Width, minGopherWidth)
+	}
+	return nil
+}
+
+func (tc textConfig) markdown() bool {
+	return tc.Markup != textMarkupPlain
+}
+
+func (tc textConfig) width() int {
+	if tc.Width == 0 {
+		return defaultGopherWidth
+	}
+	return tc.Width
+}
+
+func (tc textConfig) ext() string {
+	if tc.markdown() {
+		return ".md"
+	}
+	return ".txt"
+}
+
+func collapseSpace(s string) string {
+	res := strings.Join(strings.Fields(s), " ")
+	if len(res) < 1 {
+		if len(s) > 0 {
+			return " "
+		}
+		return ""
+	}
+	if first, _ := utf8.DecodeRuneInString(s); strings.ContainsRune(" \t\r\n", first) {
+		res = " " + res
+	}
+	if last, _ := utf8.DecodeLastRuneInString(s); strings.ContainsRune(" \t\r\n", last) {
+		res += " "
+	}
+	return res
+}
+
+func textContent(n *html.Node) string {
+	if n.Type == html.TextNode {
+		return n.Data
+	}
+	var b strings.Builder
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		b.WriteString(textContent(c))
+	}
+	return b.String()
+}
+
+func markdownCode(code string) string {
+	fence := "`"
+	for strings.Contains(code, fence) {
+		fence += "`"
+	}
+	if strings.HasPrefix(code, "`") || strings.HasSuffix(code, "`") {
+		return fence + " " + code + " " + fence
+	}
+	return fence + code + fence
+}
+
+func markdownDestination(dest, title string) string {
+	if strings.ContainsAny(dest, " ()<>") {
+		dest = "<" + strings.NewReplacer("<", "%3C", ">", "%3E").Replace(dest) + ">"
+	}
+	if len(title) > 0 {
+		dest += " \"" + strings.Replace(title, "\"", "\\\"", -1) + "\""
+	}
+	return dest
+}
+
+func emphasise(marker, text string) string {
+	trimmed := strings.TrimSpace(text)
+	if len(trimmed) < 1 {
+		return text
+	}
+	start := text[:strings.Index(text, trimmed)] //Markers must touch the text
+	end := text[len(start)+len(trimmed):]
+	return start + marker + trimmed + marker + end
+}
+
+func (mw markdownWriter) inline(n *html.Node) string {
+	var b strings.Builder
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		b.WriteString(mw.inlineNode(c))
+	}
+	return b.String()
+}
+
+func (mw markdownWriter) inlineNode(n *html.Node) string {
+	if n.Type == html.TextNode {
+		return markdownEscaper.Replace(collapseSpace(n.Data))
+	}
+	if n.Type != html.ElementNode || dropContentTags[n.Data] {
+		return ""
+	}
+	switch n.Data {
+	case "br":
+		return "\\\n" //Backslash line breaks, as blom's Markdown uses
+	case "em", "i", "cite", "var":
+		return emphasise("*", mw.inline(n))
+	case "strong", "b":
+		return emphasise("**", mw.inline(n))
+	case "del", "s", "strike":
+		return emphasise("~~", mw.inline(n))
+	case "code", "kbd", "samp", "tt":
+		return markdownCode(textContent(n))
+	case "img":
+		return "![" + markdownEscaper.Replace(nodeAttr(n, "alt")) + "](" + markdownDestination(mw.link(nodeAttr(n, "src")), nodeAttr(n, "title")) + ")"
+	case "a":
+		text := mw.inline(n)
+		href := nodeAttr(n, "href")
+		if len(href) < 1 {
+			return text
+		}
+		href = mw.link(href)
+		if text == markdownEscaper.Replace(href) && strings.Contains(href, "://") && len(nodeAttr(n, "title")) < 1 {
+			return "<" + href + ">"
+		}
+		return "[" + strings.TrimSpace(text) + "](" + markdownDestination(href, nodeAttr(n, "title")) + ")"
+	}
+	return mw.inline(n)
+}
+
+func paragraph(inline string) string {
+	lines := strings.Split(inline, "\n")
+	for i, line := range lines {
+		lines[i] = strings.TrimLeft(line, " ")
+		if i < len(lines)-1 {
+			lines[i] = strings.TrimRight(lines[i], " ") //Before a line break
+		}
+	}
+	return strings.TrimSpace(strings.Join(lines, "\n"))
+}
+
+func indentLines(text, first, rest string) string {
+	lines := strings.Split(text, "\n")
+	for i, line := range lines {
+		prefix := rest
+		if i == 0 {
+			prefix = first
+		}
+		if len(line) < 1 {
+			prefix = strings.TrimRight(prefix, " ")
+		}
+		lines[i] = prefix + line
+	}
+	return strings.Join(lines, "\n")
+}
+
+func (mw markdownWriter) list(n *html.Node) string {
+	items := make([]string, 0)
+	number := 1
+	if start := nodeAttr(n, "start"); len(start) > 0 {
+		fmt.Sscanf(start, "%d", &number)
+	}
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		if c.Type != html.ElementNode || c.Data != "li" {
+			continue
+		}
+		marker := "- "
+		if n.Data == "ol" {
+			marker = fmt.Sprintf("%d. ", number)
+			number++
+		}
+		seperator := "\n"
+		for gc := c.FirstChild; gc != nil; gc = gc.NextSibling {
+			if gc.Type == html.ElementNode && gc.Data == "p" {
+				seperator = "\n\n" //Loose list item
+			}
+		}
+		content := strings.Join(mw.blocks(c), seperator)
+		items = append(items, indentLines(content, marker, strings.Repeat(" ", len(marker))))
+	}
+	return strings.Join(items, "\n")
+}
+
+func (mw markdownWriter) pre(n *html.Node) string {
+	lang := ""
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		if c.Type == html.ElementNode && c.Data == "code" {
+			for _, class := range strings.Fields(nodeAttr(c, "class")) {
+				if strings.HasPrefix(class, "language-") {
+					lang = strings.TrimPrefix(class, "language-")
+				}
+			}
+		}
+	}
+	code := strings.TrimRight(textContent(n), "\n")
+	fence := "```"
+	for strings.Contains(code, fence) {
+		fence += "`"
+	}
+	return fence + lang + "\n" + code + "\n" + fence
+}
+
+func (mw markdownWriter) blocks(n *html.Node) []string {
+	res := make([]string, 0)
+	var inline strings.Builder
+	flush := func() {
+		if text := paragraph(inline.String()); len(text) > 0 {
+			res = append(res, text)
+		}
+		inline.Reset()
+	}
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		if c.Type != html.ElementNode {
+			inline.WriteString(mw.inlineNode(c))
+			continue
+		}
+		switch {
+		case dropContentTags[c.Data]:
+		case c.Data == "h1" || c.Data == "h2" || c.Data == "h3" || c.Data == "h4" || c.Data == "h5" || c.Data == "h6":
+			flush()
+			heading := strings.Replace(paragraph(mw.inline(c)), "\\\n", " ", -1)
+			res = append(res, strings.Repeat("#", int(c.Data[1]-'0'))+" "+heading)
+		case markdownParagraphTags[c.Data]:
+			flush()
+			if text := paragraph(mw.inline(c)); len(text) > 0 {
+				res = append(res, text)
+			}
+		case c.Data == "ul" || c.Data == "ol":
+			flush()
+			res = append(res, mw.list(c))
+		case c.Data == "blockquote":
+			flush()
+			res = append(res, indentLines(strings.Join(mw.blocks(c), "\n\n"), "> ", "> "))
+		case c.Data == "pre":
+			flush()
+			res = append(res, mw.pre(c))
+		case c.Data == "hr":
+			flush()
+			res = append(res, "---")
+		case markdownContainerTags[c.Data] || c.Data == "li":
+			flush()
+			res = append(res, mw.blocks(c)...)
+		case markdownRawTags[c.Data]:
+			flush()
+			var b bytes.Buffer
+			html.Render(&b, c) //Markdown has no equivalent, but allows HTML blocks
+			res = append(res, b.String())
+		default:
+			inline.WriteString(mw.inlineNode(c))
+		}
+	}
+	flush()
+	return res
+}
+
+func htmlToMarkdown(content string, link func(string) string) (string, error) {
+	body := &html.Node{Type: html.ElementNode, Data: "body", DataAtom: atom.Body}
+	nodes, err := html.ParseFragment(strings.NewReader(content), body)
+	if err != nil {
+		return "", err
+	}
+	for _, n := range nodes {
+		body.AppendChild(n)
+	}
+	blocks := markdownWriter{link}.blocks(body)
+	if len(blocks) < 1 {
+		return "", nil
+	}
+	return strings.Join(blocks, "\n\n") + "\n", nil
+}
+
+func textItemPath(tc textConfig, ji jsfItem) string {
+	return path.Join(ji.dir, textIndexName+tc.ext())
+}
+
+func textLinkMapper(tc textConfig, ji jsfItem, itemList []jsfItem) func(string) string {
+	pages := make(map[string]string)
+	for _, other := range itemList {
+		pages[strings.TrimSuffix(other.URL, "/")] = textItemPath(tc, other)
+	}
+	up := strings.Repeat("../", strings.Count(ji.dir, "/")+1)
+	return func(link string) string {
+		if prefix := strings.TrimSuffix(ji.URL, "/") + "/"; strings.HasPrefix(link, prefix) {
+			return strings.TrimPrefix(link, prefix) //Attachments are copied alongside
+		}
+		parts := strings.SplitN(link, "#", 2)
+		if p, ok := pages[strings.TrimSuffix(parts[0], "/")]; ok {
+			parts[0] = up + p
+			return strings.Join(parts, "#")
+		}
+		return link
+	}
+}
+
+func frontMatterLine(b *bytes.Buffer, key string, value interface{}) {
+	encoded, _ := json.Marshal(value) //JSON values are valid YAML
+	fmt.Fprintf(b, "%s: %s\n", key, encoded)
+}
+
+func textAttachmentPaths(ji jsfItem) []string {
+	res := make([]string, 0, len(ji.Attachments))
+	for _, ja := range ji.Attachments {
+		if len(ja.path) > 0 {
+			res = append(res, attachmentDir+"/"+ja.path)
+		}
+	}
+	return res
+}
+
+func markdownArticle(ji jsfItem, body string) []byte {
+	published, _ := time.Parse(time.RFC3339, ji.DatePublished)
+	var b bytes.Buffer
+	b.WriteString("---\n")
+	frontMatterLine(&b, "title", ji.Title)
+	frontMatterLine(&b, "id", ji.ID)
+	frontMatterLine(&b, "url", ji.URL)
+	frontMatterLine(&b, "date_published", ji.DatePublished)
+	if len(ji.DateModified) > 0 && ji.DateModified != ji.DatePublished {
+		frontMatterLine(&b, "date_modified", ji.DateModified)
+	}
+	frontMatterLine(&b, "tranquility_date", tqDateStr(published))
+	if len(ji.Tags) > 0 {
+		frontMatterLine(&b, "tags", ji.Tags)
+	}
+	if attachments := textAttachmentPaths(ji); len(attachments) > 0 {
+		frontMatterLine(&b, "attachments", attachments)
+	}
+	b.WriteString("---\n\n")
+	b.WriteString(body)
+	return b.Bytes()
+}
+
+func plainArticle(tc textConfig, ji jsfItem) []byte {
+	var b bytes.Buffer
+	title := wrapText(ji.Title, tc.width(), "", "")
+	longest := 0
+	for _, line := range title {
+		b.WriteString(line + "\n")
+		if n := utf8.RuneCountInString(line); n > longest {
+			longest = n
+		}
+	}
+	b.WriteString(strings.Repeat("=", longest) + "\n\n")
+	published, _ := time.Parse(time.RFC3339, ji.DatePublished)
+	b.WriteString("Published: " + dualDateSeperated(published, " ") + "\n")
+	if len(ji.DateModified) > 0 && ji.DateModified != ji.DatePublished {
+		modified, _ := time.Parse(time.RFC3339, ji.DateModified)
+		b.WriteString("Modified: " + dualDateSeperated(modified, " ") + "\n")
+	}
+	if len(ji.Tags) > 0 {
+		b.WriteString("Tags: " + strings.Join(ji.Tags, ", ") + "\n")
+	}
+	b.WriteString("URL: " + ji.URL + "\n")
+	if attachments := textAttachmentPaths(ji); len(attachments) > 0 {
+		b.WriteString("Attachments: " + strings.Join(attachments, ", ") + "\n")
+	}
+	b.WriteString("\n")
+	b.WriteString(htmlToWrappedText(ji.ContentHTML, tc.width()))
+	return b.Bytes()
+}
+
+func textArticle(tc textConfig, ji jsfItem, itemList []jsfItem, blogPath string) ([]byte, error) {
+	if !tc.markdown() {
+		return plainArticle(tc, ji), nil
+	}
+	markdown, err := ioutil.ReadFile(filepath.Join(blogPath, filepath.FromSlash(ji.dir), contentFileMD))
+	if os.IsNotExist(err) {
+		body, err := htmlToMarkdown(ji.ContentHTML, textLinkMapper(tc, ji, itemList)) //Best effort for HTML articles
+		if err != nil {
+			return nil, err
+		}
+		return markdownArticle(ji, body), nil
+	} else if err != nil {
+		return nil, err
+	}
+	return markdownArticle(ji, string(markdown)), nil //The source as written, with links relative to it
+}
+
+func textIndex(tc textConfig, itemList []jsfItem) []byte {
+	var b bytes.Buffer
+	if tc.markdown() {
+		b.WriteString("# " + blogTitle + "\n\n")
+	} else {
+		b.WriteString(blogTitle + "\n" + strings.Repeat("=", utf8.RuneCountInString(blogTitle)) + "\n\n")
+	}
+	if len(conf.Description) > 0 {
+		b.WriteString(conf.Description + "\n\n")
+	}
+	for _, ji := range itemList {
+		published, _ := time.Parse(time.RFC3339, ji.DatePublished)
+		date := published.Format(exportDateFormat)
+		if tc.markdown() {
+			fmt.Fprintf(&b, "- %s [%s](%s)\n", date, markdownEscaper.Replace(ji.Title), markdownDestination(textItemPath(tc, ji), ""))
+		} else {
+			fmt.Fprintf(&b, "%s %s (%s)\n", date, strings.Join(strings.Fields(ji.Title), " "), textItemPath(tc, ji))
+		}
+	}
+	return b.Bytes()
+}
+
+func copyTextAttachments(ji jsfItem, blogPath, outputPath string) error {
+	articlePath := filepath.Join(blogPath, filepath.FromSlash(ji.dir))
+	sourceAttachPath := filepath.Join(articlePath, attachmentDir)
+	if _, err := os.Stat(sourceAttachPath); err != nil {
+		return nil
+	}
+	publishedArticlePath, ok := publishedPath(blogPath, ji.URL)
+	if !ok {
+		return fmt.Errorf("article '%s' is not published in the blog", ji.URL)
+	}
+	var articleRules *attachmentRules
+	if ji.Blom != nil {
+		articleRules = ji.Blom.Attachments
+	}
+	attachPathMap, err := getAttachPaths(articlePath, conf.Attachments.merge(articleRules))
+	if err != nil {
+		return err
+	}
+	for attachPath := range attachPathMap { //Unpublished ones too, the content can still use them
+		relPath, err := filepath.Rel(sourceAttachPath, attachPath)
+		if err != nil {
+			return err
+		}
+		src := filepath.Join(publishedArticlePath, attachmentDir, relPath) //Not the source, which may still have its metadata
+		dst := filepath.Join(outputPath, filepath.FromSlash(ji.dir), attachmentDir, relPath)
+		err = os.MkdirAll(filepath.Dir(dst), 0775)
+		if err != nil {
+			return err
+		}
+		err = copyFile(src, dst)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func writeTextArchive(job exportJob) error {
+	tc := conf.Text
+	for _, ji := range job.itemList {
+		content, err := textArticle(tc, ji, job.itemList, job.blogPath)
+		if err != nil {
+			return fmt.Errorf("article '%s': %s", ji.URL, err.Error())
+		}
+		err = writeOutputFile(content, filepath.Join(job.outputPath, filepath.FromSlash(textItemPath(tc, ji))))
+		if err != nil {
+			return err
+		}
+		err = copyTextAttachments(ji, job.blogPath, job.outputPath)
+		if err != nil {
+			return err
+		}
+	}
+	return writeOutputFile(textIndex(tc, job.itemList), filepath.Join(job.outputPath, textIndexName+tc.ext()))
+}
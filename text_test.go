@@ -0,0 +1,233 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+var htmlToMarkdownTests = []struct {
+	content  string
+	expected string
+}{
+	{"", ""},
+	{"<p>Plain  words\nwrapped</p>", "Plain words wrapped\n"},
+	{"<h2 id=\"x\">A <em>heading</em></h2><p>Text</p>", "## A *heading*\n\nText\n"},
+	{"<p><strong>Bold </strong>and <del>gone</del> and <code>a`b</code></p>", "**Bold** and ~~gone~~ and ``a`b``\n"},
+	{"<p>Line<br />break</p>", "Line\\\nbreak\n"},
+	{"<p>Stars * and_under [x]</p>", "Stars \\* and\\_under \\[x\\]\n"},
+	{"<p><a href=\"http://e.com/a b\" title=\"T\">link</a> <a href=\"http://e.com\">http://e.com</a></p>", "[link](<http://e.com/a b> \"T\") <http://e.com>\n"},
+	{"<p><img src=\"http://e.com/i.png\" alt=\"Gull\" /></p>", "![Gull](http://e.com/i.png)\n"},
+	{"<ul>\n<li>one</li>\n<li>two\n<ol start=\"3\"><li>three</li></ol></li>\n</ul>", "- one\n- two\n  3. three\n"},
+	{"<ul><li><p>loose</p><p>item</p></li></ul>", "- loose\n\n  item\n"},
+	{"<blockquote><p>Quoted</p><p>twice</p></blockquote>", "> Quoted\n>\n> twice\n"},
+	{"<pre><code class=\"language-go\">x := 1\n\ny := 2\n</code></pre>", "```go\nx := 1\n\ny := 2\n```\n"},
+	{"<div><p>In a div</p>loose text</div><hr /><script>var x;</script>", "In a div\n\nloose text\n\n---\n"},
+	{"<table><tr><td>cell</td></tr></table>", "<table><tbody><tr><td>cell</td></tr></tbody></table>\n"},
+}
+
+func TestHTMLToMarkdown(t *testing.T) {
+	for _, test := range htmlToMarkdownTests {
+		res, err := htmlToMarkdown(test.content, func(link string) string { return link })
+		if err != nil {
+			t.Errorf("Error (%s) when all parameters valid.", err.Error())
+		}
+		if res != test.expected {
+			t.Errorf("Wrong Markdown for '%s', expected %q, actual %q", test.content, test.expected, res)
+		}
+	}
+}
+
+var textConfigTests = []struct {
+	tc    textConfig
+	valid bool
+}{
+	{textConfig{}, true},
+	{textConfig{Markup: "markdown"}, true},
+	{textConfig{Markup: "plain", Width: 60}, true},
+	{textConfig{Markup: "rst"}, false},
+	{textConfig{Width: 5}, false},
+}
+
+func TestTextConfigValidate(t *testing.T) {
+	for _, test := range textConfigTests {
+		err := test.tc.validate()
+		if (err == nil) != test.valid {
+			t.Errorf("Wrong validity for %v, expected %v, actual error %v", test.tc, test.valid, err)
+		}
+	}
+}
+
+func TestTextLinkMapper(t *testing.T) {
+	ji := jsfItem{URL: hostRawURL + "/notes/b", dir: "notes/b"}
+	itemList := []jsfItem{ji, {URL: hostRawURL + "/a", dir: "a"}}
+	link := textLinkMapper(textConfig{}, ji, itemList)
+	for input, expected := range map[string]string{
+		hostRawURL + "/notes/b/attachments/x.png": "attachments/x.png",
+		hostRawURL + "/a/#part":                   "../../a/index.md#part",
+		"http://example.com/":                     "http://example.com/",
+	} {
+		if res := link(input); res != expected {
+			t.Errorf("Wrong link for '%s', expected '%s', actual '%s'", input, expected, res)
+		}
+	}
+
+	ji = jsfItem{URL: hostRawURL + "/notes/c/", dir: "notes/c"} //A permalink ending in a slash
+	link = textLinkMapper(textConfig{}, ji, append(itemList, ji))
+	if res := link(hostRawURL + "/notes/c/attachments/x.png"); res != "attachments/x.png" {
+		t.Errorf("Wrong attachment link for trailing slash URL: '%s'", res)
+	}
+}
+
+func setupTextBlog(t *testing.T) (string, []string) {
+	blogPath, subdirPaths := setupBlog(t, []byte(`{"title": "Trip", "date_published": "2017-06-10T10:00:00Z", "tags": ["sea"]}`), []byte("See the [photo](attachments/a.txt)."), 2, 2)
+	err := os.MkdirAll(filepath.Join(subdirPaths[0], attachmentDir), 0775)
+	if err != nil {
+		t.Fatalf("Error (%s) PRIOR TO RUNNING TEST.", err.Error())
+	}
+	err = ioutil.WriteFile(filepath.Join(subdirPaths[0], attachmentDir, "a.txt"), []byte("Attached"), 0664)
+	if err != nil {
+		t.Fatalf("Error (%s) PRIOR TO RUNNING TEST.", err.Error())
+	}
+	setupArticle(t, subdirPaths[1], []byte(`{"title": "Home", "date_published": "2017-06-11T10:00:00Z"}`), nil)
+	err = os.Remove(filepath.Join(subdirPaths[1], contentFileMD)) //An HTML article
+	if err != nil {
+		t.Fatalf("Error (%s) PRIOR TO RUNNING TEST.", err.Error())
+	}
+	html := "<p>Back to <a href=\"../" + filepath.Base(subdirPaths[0]) + "\">the trip</a>.</p>"
+	err = ioutil.WriteFile(filepath.Join(subdirPaths[1], contentFileHTML), []byte(html), 0664)
+	if err != nil {
+		t.Fatalf("Error (%s) PRIOR TO RUNNING TEST.", err.Error())
+	}
+	return blogPath, subdirPaths
+}
+
+func TestProcessExportText(t *testing.T) {
+	blogPath, subdirPaths := setupTextBlog(t)
+	defer teardownArticlePath(t, blogPath)
+	outputPath := filepath.Join(blogPath, "text")
+	buildTestBlog(t, blogPath)
+	err := processExport(blogPath, textFormat, outputPath, exportFilter{})
+	if err != nil {
+		t.Fatalf("Error (%s) when all parameters valid.", err.Error())
+	}
+
+	trip, home := filepath.Base(subdirPaths[0]), filepath.Base(subdirPaths[1])
+	content, err := ioutil.ReadFile(filepath.Join(outputPath, trip, "index.md"))
+	if err != nil {
+		t.Fatalf("Error (%s) reading article", err.Error())
+	}
+	for _, expected := range []string{
+		"---\ntitle: \"Trip\"\n",
+		"url: \"" + hostRawURL + "/" + trip + "\"\n",
+		"date_published: \"2017-06-10T10:00:00Z\"\n",
+		"tranquility_date: \"Sunday, 17 Lavoisier, 48 AT\"\n",
+		"tags: [\"sea\"]\n",
+		"attachments: [\"attachments/a.txt\"]\n---\n\nSee the [photo](attachments/a.txt).",
+	} {
+		if !strings.Contains(string(content), expected) {
+			t.Errorf("No %q in Markdown article:\n%s", expected, content)
+		}
+	}
+	attached, err := ioutil.ReadFile(filepath.Join(outputPath, trip, attachmentDir, "a.txt"))
+	if err != nil || string(attached) != "Attached" {
+		t.Errorf("Attachment not copied: '%s', %v", attached, err)
+	}
+
+	content, err = ioutil.ReadFile(filepath.Join(outputPath, home, "index.md"))
+	if err != nil {
+		t.Fatalf("Error (%s) reading article", err.Error())
+	}
+	if !strings.HasSuffix(string(content), "---\n\nBack to [the trip](../"+trip+"/index.md).\n") {
+		t.Errorf("HTML article not converted:\n%s", content)
+	}
+
+	index, err := ioutil.ReadFile(filepath.Join(outputPath, "index.md"))
+	if err != nil {
+		t.Fatalf("Error (%s) reading index", err.Error())
+	}
+	expected := "# " + blogTitle + "\n\n- 2017-06-11 [Home](" + home + "/index.md)\n- 2017-06-10 [Trip](" + trip + "/index.md)\n"
+	if string(index) != expected {
+		t.Errorf("Wrong index, expected:\n%s\nactual:\n%s", expected, index)
+	}
+}
+
+func TestProcessExportTextPlain(t *testing.T) {
+	conf.Text = textConfig{Markup: textMarkupPlain}
+	fixedNow = time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	defer func() {
+		conf = blogConfig{}
+		fixedNow = time.Time{}
+	}()
+	blogPath, subdirPaths := setupTextBlog(t)
+	defer teardownArticlePath(t, blogPath)
+	outputPath := filepath.Join(blogPath, "text")
+	buildTestBlog(t, blogPath)
+	err := processExport(blogPath, textFormat, outputPath, exportFilter{tag: "sea"})
+	if err != nil {
+		t.Fatalf("Error (%s) when all parameters valid.", err.Error())
+	}
+
+	trip := filepath.Base(subdirPaths[0])
+	content, err := ioutil.ReadFile(filepath.Join(outputPath, trip, "index.txt"))
+	if err != nil {
+		t.Fatalf("Error (%s) reading article", err.Error())
+	}
+	expected := "Trip\n====\n\n" +
+		"Published: Sunday, 17 Lavoisier, 48 AT [Gregorian: Saturday, 10 June, 2017 CE]\n" +
+		"Modified: " + dualDateSeperated(fixedNow, " ") + "\n" +
+		"Tags: sea\nURL: " + hostRawURL + "/" + trip + "\nAttachments: attachments/a.txt\n\n" +
+		"See the photo [1].\n\n[1] " + hostRawURL + "/" + trip + "/attachments/a.txt\n"
+	if string(content) != expected {
+		t.Errorf("Wrong article, expected:\n%s\nactual:\n%s", expected, content)
+	}
+	if _, err := os.Stat(filepath.Join(outputPath, filepath.Base(subdirPaths[1]))); err == nil {
+		t.Errorf("Article without the tag exported")
+	}
+	index, err := ioutil.ReadFile(filepath.Join(outputPath, "index.txt"))
+	if err != nil || !strings.HasSuffix(string(index), "2017-06-10 Trip ("+trip+"/index.txt)\n") {
+		t.Errorf("Wrong index: '%s', %v", index, err)
+	}
+}
+
+func TestCopyTextAttachments(t *testing.T) {
+	blogPath := setupArticlePath(t)
+	defer teardownArticlePath(t, blogPath)
+	for dir, content := range map[string]string{"drafts/trip": "Source", "trip": "Published"} {
+		attachmentPath := filepath.Join(blogPath, filepath.FromSlash(dir), attachmentDir)
+		err := os.MkdirAll(attachmentPath, 0775)
+		if err != nil {
+			t.Fatalf("Error (%s) PRIOR TO RUNNING TEST.", err.Error())
+		}
+		for _, name := range []string{"a.txt", "b.txt"} {
+			err = ioutil.WriteFile(filepath.Join(attachmentPath, name), []byte(content), 0664)
+			if err != nil {
+				t.Fatalf("Error (%s) PRIOR TO RUNNING TEST.", err.Error())
+			}
+		}
+	}
+	ji := jsfItem{URL: hostRawURL + "/trip/", dir: "drafts/trip", Attachments: []jsfAttachment{{URL: hostRawURL + "/trip/attachments/a.txt", path: "a.txt"}}}
+	ji.Blom = &blomMeta{Attachments: &attachmentRules{Publish: []string{"a.txt"}}}
+	outputPath := filepath.Join(blogPath, "text")
+	err := copyTextAttachments(ji, blogPath, outputPath)
+	if err != nil {
+		t.Fatalf("Error (%s) when all parameters valid.", err.Error())
+	}
+	for _, name := range []string{"a.txt", "b.txt"} { //b.txt isn't published, but the content can still use it
+		copied, err := ioutil.ReadFile(filepath.Join(outputPath, "drafts", "trip", attachmentDir, name))
+		if err != nil || string(copied) != "Published" {
+			t.Errorf("Wrong attachment copied for %s: '%s', %v", name, copied, err)
+		}
+	}
+
+	err = ioutil.WriteFile(filepath.Join(blogPath, "drafts", "trip", attachmentDir, "new.txt"), []byte("Source"), 0664)
+	if err != nil {
+		t.Fatalf("Error (%s) PRIOR TO RUNNING TEST.", err.Error())
+	}
+	if err := copyTextAttachments(ji, blogPath, outputPath); err == nil {
+		t.Errorf("No error for attachment that isn't published")
+	}
+}
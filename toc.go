@@ -0,0 +1,114 @@
+package main
+
+import (
+	"bytes"
+	"golang.org/x/net/html"
+	"html/template"
+	"strings"
+)
+
+const defaultTOCDepth = 3
+
+type tocEntry struct {
+	Level    int
+	ID       string
+	Title    string
+	Children []tocEntry
+}
+
+type tocHeading struct {
+	level int
+	id    string
+	title string
+}
+
+func findHeadings(content []byte) []tocHeading {
+	res := make([]tocHeading, 0)
+	var cur *tocHeading
+	var text bytes.Buffer
+	z := html.NewTokenizer(bytes.NewReader(content))
+	for {
+		tt := z.Next()
+		if tt == html.ErrorToken {
+			return res
+		}
+		tok := z.Token()
+		switch {
+		case tt == html.StartTagToken && isHeading(tok.Data) && cur == nil:
+			for _, attr := range tok.Attr {
+				if attr.Key == "id" {
+					cur = &tocHeading{level: int(tok.Data[1] - '0'), id: attr.Val}
+					text.Reset()
+				}
+			}
+		case tt == html.EndTagToken && isHeading(tok.Data) && cur != nil:
+			cur.title = strings.Join(strings.Fields(text.String()), " ")
+			res = append(res, *cur)
+			cur = nil
+		case tt == html.TextToken && cur != nil:
+			text.WriteString(tok.Data)
+		}
+	}
+}
+
+func nestHeadings(headings []tocHeading) ([]tocEntry, []tocHeading) {
+	res := make([]tocEntry, 0)
+	for len(headings) > 0 {
+		h := headings[0]
+		entry := tocEntry{Level: h.level, ID: h.id, Title: h.title}
+		headings = headings[1:]
+		end := 0
+		for end < len(headings) && headings[end].level > h.level {
+			end++
+		}
+		entry.Children, _ = nestHeadings(headings[:end])
+		headings = headings[end:]
+		res = append(res, entry)
+	}
+	return res, headings
+}
+
+func buildTOC(content []byte, depth int) []tocEntry {
+	if depth < 1 {
+		depth = defaultTOCDepth
+	}
+	headings := findHeadings(content)
+	top := 7
+	for _, h := range headings {
+		if h.level < top {
+			top = h.level
+		}
+	}
+	shown := make([]tocHeading, 0, len(headings))
+	for _, h := range headings {
+		if h.level < top+depth {
+			shown = append(shown, h)
+		}
+	}
+	res, _ := nestHeadings(shown)
+	return res
+}
+
+func writeTOCList(b *bytes.Buffer, entries []tocEntry) {
+	b.WriteString("<ul>\n")
+	for _, entry := range entries {
+		b.WriteString("<li><a href=\"#" + html.EscapeString(entry.ID) + "\">" + html.EscapeString(entry.Title) + "</a>")
+		if len(entry.Children) > 0 {
+			b.WriteString("\n")
+			writeTOCList(b, entry.Children)
+		}
+		b.WriteString("</li>\n")
+	}
+	b.WriteString("</ul>\n")
+}
+
+func tocHTML(entries []tocEntry) template.HTML {
+	if len(entries) < 1 {
+		return ""
+	}
+	var b bytes.Buffer
+	b.WriteString("<nav class=\"toc\">\n")
+	writeTOCList(&b, entries)
+	b.WriteString("</nav>")
+	return template.HTML(b.String())
+}
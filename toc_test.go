@@ -0,0 +1,86 @@
+package main
+
+import (
+	"html/template"
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+const tocContent = `<h1>Title</h1>
+<h2 id="intro">Intro</h2>
+<h3 id="why">Why <em>bother</em></h3>
+<h4 id="deep">Deep</h4>
+<h2 id="setup">Setup &amp; use</h2>
+<h4 id="skipped">Skipped level</h4>`
+
+func TestBuildTOC(t *testing.T) {
+	expected := []tocEntry{
+		{2, "intro", "Intro", []tocEntry{
+			{3, "why", "Why bother", []tocEntry{{4, "deep", "Deep", []tocEntry{}}}},
+		}},
+		{2, "setup", "Setup & use", []tocEntry{{4, "skipped", "Skipped level", []tocEntry{}}}},
+	}
+	res := buildTOC([]byte(tocContent), 0)
+	if !reflect.DeepEqual(res, expected) {
+		t.Errorf("Wrong TOC, expected %v, actual %v", expected, res)
+	}
+
+	res = buildTOC([]byte(tocContent), 1)
+	if len(res) != 2 || len(res[0].Children) != 0 || len(res[1].Children) != 0 {
+		t.Errorf("Wrong TOC at depth 1: %v", res)
+	}
+	if res := buildTOC([]byte("<p>No headings</p>"), 3); len(res) != 0 {
+		t.Errorf("TOC without headings: %v", res)
+	}
+}
+
+func TestTOCHTML(t *testing.T) {
+	entries := []tocEntry{{2, "a", "A & B", []tocEntry{{3, "c", "C", nil}}}}
+	expected := template.HTML("<nav class=\"toc\">\n<ul>\n<li><a href=\"#a\">A &amp; B</a>\n<ul>\n<li><a href=\"#c\">C</a></li>\n</ul>\n</li>\n</ul>\n</nav>")
+	if res := tocHTML(entries); res != expected {
+		t.Errorf("Wrong TOC HTML, expected '%s', actual '%s'", expected, res)
+	}
+	if res := tocHTML(nil); res != "" {
+		t.Errorf("TOC HTML without entries: '%s'", res)
+	}
+}
+
+func TestProcessArticleTOC(t *testing.T) {
+	tmpl := template.Must(template.New("Whatever").Parse("{{.TOCHTML}}\n{{.ContentHTML}}"))
+	articlePath := setupArticlePath(t)
+	defer teardownArticlePath(t, articlePath)
+	articlePath, _ = filepath.Rel(".", articlePath)
+	err := ioutil.WriteFile(filepath.Join(articlePath, contentFileHTML), []byte("<h2>First</h2><h2>Second</h2>"), 0664)
+	if err != nil {
+		t.Errorf("Error (%s) BEFORE STARTING TEST.", err.Error())
+	}
+
+	toc := true
+	ji, err := processArticle(tmpl, ".", articlePath, articleFlags{title: "Long", toc: &toc})
+	if err != nil {
+		t.Errorf("Error (%s) when all parameters valid", err.Error())
+	}
+	if ji.Blom == nil || !ji.Blom.TOC {
+		t.Errorf("TOC setting not kept: %v", ji.Blom)
+	}
+	page, _ := ioutil.ReadFile(filepath.Join(articlePath, finalWebpageFile))
+	if !strings.Contains(string(page), "<a href=\"#second\">Second</a>") || !strings.Contains(string(page), "<h2 id=\"second\">Second</h2>") {
+		t.Errorf("Wrong page with TOC:\n%s", page)
+	}
+
+	_, err = processArticle(tmpl, ".", articlePath, articleFlags{})
+	page, _ = ioutil.ReadFile(filepath.Join(articlePath, finalWebpageFile))
+	if err != nil || !strings.Contains(string(page), "<nav class=\"toc\">") {
+		t.Errorf("TOC lost when regenerating (%v):\n%s", err, page)
+	}
+
+	toc = false
+	ji, err = processArticle(tmpl, ".", articlePath, articleFlags{toc: &toc})
+	page, _ = ioutil.ReadFile(filepath.Join(articlePath, finalWebpageFile))
+	if err != nil || strings.Contains(string(page), "<nav class=\"toc\">") || (ji.Blom != nil && ji.Blom.TOC) {
+		t.Errorf("TOC not turned off (%v):\n%s", err, page)
+	}
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+const todayModeInline = "inline"
+const todayModeScript = "script"
+const todayModeSSI = "ssi"
+const todayJSFile = "today.js"
+const todayJSONFile = "today.json"
+const todayHTMLFile = "today.html"
+const todayClass = "blom-today"
+const secondsPerDay = 24 * 60 * 60
+
+const todayJSTmpl = `(function () {
+	var start = %d; //Days since the Unix epoch
+	var tq = %s;
+	var days = ["Sunday", "Monday", "Tuesday", "Wednesday", "Thursday", "Friday", "Saturday"];
+	var months = ["January", "February", "March", "April", "May", "June", "July", "August", "September", "October", "November", "December"];
+	var now = new Date();
+	var index = Math.floor(Date.UTC(now.getFullYear(), now.getMonth(), now.getDate()) / 86400000) - start;
+	var g = "[Gregorian: " + days[now.getDay()] + ", " + now.getDate() + " " + months[now.getMonth()] + ", " + now.getFullYear() + " CE]";
+	var html = "Today is " + (index >= 0 && index < tq.length ? tq[index] + "<br />" : "") + g;
+	var els = document.getElementsByClassName("%s");
+	for (var i = 0; i < els.length; i++) {
+		els[i].innerHTML = html;
+	}
+})();
+`
+
+type todayJSON struct {
+	Date string `json:"date"`
+	HTML string `json:"html"`
+}
+
+func validTodayMode(mode string) bool {
+	switch mode {
+	case "", todayModeInline, todayModeScript, todayModeSSI:
+		return true
+	default:
+		return false
+	}
+}
+
+func todayHTML(t time.Time) template.HTML {
+	return "Today is " + template.HTML(dualDateStr(t))
+}
+
+func todaySnippet(t time.Time) template.HTML {
+	switch conf.TodayMode {
+	case todayModeScript:
+		return template.HTML(fmt.Sprintf("<span class=\"%s\"></span><script src=\"%s/%s\" defer></script>", todayClass, hostRawURL, todayJSFile))
+	case todayModeSSI:
+		return template.HTML(fmt.Sprintf("<!--#include virtual=\"/%s\" -->", todayHTMLFile))
+	default:
+		return todayHTML(t)
+	}
+}
+
+func todayTable(t time.Time) (int64, []string) {
+	start := time.Date(t.Year(), time.January, 1, 0, 0, 0, 0, time.UTC)
+	end := time.Date(t.Year()+2, time.January, 1, 0, 0, 0, 0, time.UTC) //Stays valid if the next rebuild is late
+	table := make([]string, 0, 731)
+	for d := start; d.Before(end); d = d.AddDate(0, 0, 1) {
+		table = append(table, tqDateStr(d))
+	}
+	return start.Unix() / secondsPerDay, table
+}
+
+func todayJS(t time.Time) ([]byte, error) {
+	start, table := todayTable(t)
+	tableJSON, err := json.Marshal(table)
+	if err != nil {
+		return nil, err
+	}
+	return []byte(fmt.Sprintf(todayJSTmpl, start, tableJSON, todayClass)), nil
+}
+
+func writeTodayJSON(t time.Time, outputPath string) error {
+	f, err := os.Create(outputPath)
+	if err != nil {
+		return err
+	}
+	enc := json.NewEncoder(f)
+	enc.SetEscapeHTML(false)
+	err = enc.Encode(todayJSON{t.Format("2006-01-02"), string(todayHTML(t))})
+	if err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+func processToday(wg *sync.WaitGroup, blogPath string, ch chan<- error) {
+	defer wg.Done()
+	now := buildTime()
+	js, err := todayJS(now)
+	if err != nil {
+		ch <- err
+		return
+	}
+	err = ioutil.WriteFile(filepath.Join(blogPath, todayJSFile), js, 0664)
+	if err != nil {
+		ch <- err
+		return
+	}
+	err = writeTodayJSON(now, filepath.Join(blogPath, todayJSONFile))
+	if err != nil {
+		ch <- err
+		return
+	}
+	err = ioutil.WriteFile(filepath.Join(blogPath, todayHTMLFile), []byte(todayHTML(now)+"\n"), 0664)
+	if err != nil {
+		ch <- err
+	}
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestTodaySnippet(t *testing.T) {
+	now := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	if res := todaySnippet(now); res != todayHTML(now) {
+		t.Errorf("Wrong inline snippet: '%s'", res)
+	}
+	conf.TodayMode = todayModeScript
+	if res := string(todaySnippet(now)); !strings.Contains(res, todayClass) || !strings.Contains(res, hostRawURL+"/"+todayJSFile) {
+		t.Errorf("Wrong script snippet: '%s'", res)
+	}
+	conf.TodayMode = todayModeSSI
+	if res := string(todaySnippet(now)); res != "<!--#include virtual=\"/"+todayHTMLFile+"\" -->" {
+		t.Errorf("Wrong SSI snippet: '%s'", res)
+	}
+	conf = blogConfig{}
+}
+
+func TestTodayTable(t *testing.T) {
+	now := time.Date(2020, 7, 21, 12, 0, 0, 0, time.UTC)
+	start, table := todayTable(now)
+	expectedStart := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC).Unix() / secondsPerDay
+	if start != expectedStart {
+		t.Errorf("Wrong start day, expected %v, actual %v", expectedStart, start)
+	}
+	if len(table) != 366+365 {
+		t.Errorf("Wrong table length, expected %v, actual %v", 366+365, len(table))
+	}
+	if index := now.YearDay() - 1; table[index] != tqDateStr(now) {
+		t.Errorf("Wrong entry for %v, expected '%s', actual '%s'", now, tqDateStr(now), table[index])
+	}
+	_, later := todayTable(now.AddDate(0, 3, 0))
+	if strings.Join(later, "") != strings.Join(table, "") {
+		t.Errorf("Table changed within the same year.")
+	}
+}
+
+func TestProcessToday(t *testing.T) {
+	fixedNow = time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	defer func() { fixedNow = time.Time{} }()
+	blogPath := setupArticlePath(t)
+	defer teardownArticlePath(t, blogPath)
+
+	ch := make(chan error, 1)
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go processToday(&wg, blogPath, ch)
+	wg.Wait()
+	select {
+	case err := <-ch:
+		t.Errorf("Error (%s) when all parameters valid.", err.Error())
+	default:
+	}
+
+	js, _ := ioutil.ReadFile(filepath.Join(blogPath, todayJSFile))
+	start, _ := todayTable(fixedNow)
+	if !strings.Contains(string(js), "var start = "+strconv.FormatInt(start, 10)) || !strings.Contains(string(js), tqDateStr(fixedNow)) {
+		t.Errorf("Wrong %s:\n%s", todayJSFile, js)
+	}
+	var tj todayJSON
+	b, _ := ioutil.ReadFile(filepath.Join(blogPath, todayJSONFile))
+	err := json.Unmarshal(b, &tj)
+	if err != nil || tj.Date != "2020-01-01" || tj.HTML != string(todayHTML(fixedNow)) {
+		t.Errorf("Wrong %s (%v): %s", todayJSONFile, err, b)
+	}
+	html, _ := ioutil.ReadFile(filepath.Join(blogPath, todayHTMLFile))
+	if string(html) != string(todayHTML(fixedNow))+"\n" {
+		t.Errorf("Wrong %s: '%s'", todayHTMLFile, html)
+	}
+}
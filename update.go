@@ -1,14 +1,17 @@
 package main
 
 import (
+	"bytes"
 	"encoding/json"
 	"fmt"
 	"github.com/gorilla/feeds"
 	"github.com/ratanvarghese/tqtime"
+	"golang.org/x/net/html"
 	"html/template"
-	"io/ioutil"
+	"io"
 	"net/url"
 	"os"
+	"path"
 	"path/filepath"
 	"sort"
 	"strconv"
@@ -19,20 +22,35 @@ import (
 
 const updateMode = "update"
 const blogTitle = "ratan.blog"
-const jsfVersion = "https://jsonfeed.org/version/1"
+const jsfVersion = "https://jsonfeed.org/version/1.1"
 const jsfPath = "feeds/json"
 const atomPath = "feeds/atom"
 const rssPath = "feeds/rss"
+const defaultFeedPageItems = 15
 
 type jsfMain struct {
-	Version     string    `json:"version"`
-	Title       string    `json:"title"`
-	HomePageURL string    `json:"home_page_url"`
-	FeedURL     string    `json:"feed_url"`
-	NextURL     string    `json:"next_url,omitempty"`
-	Items       []jsfItem `json:"items"`
+	Version     string      `json:"version"`
+	Title       string      `json:"title"`
+	HomePageURL string      `json:"home_page_url"`
+	FeedURL     string      `json:"feed_url"`
+	Description string      `json:"description,omitempty"`
+	UserComment string      `json:"user_comment,omitempty"`
+	NextURL     string      `json:"next_url,omitempty"`
+	Icon        string      `json:"icon,omitempty"`
+	Favicon     string      `json:"favicon,omitempty"`
+	Authors     []jsfAuthor `json:"authors,omitempty"`
+	Language    string      `json:"language,omitempty"`
+	Expired     bool        `json:"expired,omitempty"`
+	Items       []jsfItem   `json:"items"`
 }
 
+type feedScope struct {
+	Title string
+	Path  string //Relative to the blog root, empty for the whole blog
+}
+
+var rootScope = feedScope{Title: blogTitle}
+
 type jsfItemErr struct {
 	item jsfItem
 	err  error
@@ -51,39 +69,87 @@ func (b byPublishedDescend) Swap(i, j int) {
 func (b byPublishedDescend) Less(i, j int) bool {
 	ti, _ := time.Parse(time.RFC3339, b[i].DatePublished)
 	tj, _ := time.Parse(time.RFC3339, b[j].DatePublished)
+	if ti.Equal(tj) {
+		return b[i].URL < b[j].URL //Articles arrive in random order
+	}
 	return ti.After(tj)
 }
 
 func findArticlePaths(blogPath string) ([]string, error) {
-	blogDir, err := ioutil.ReadDir(blogPath)
+	ignorePatterns, err := readIgnorePatterns(blogPath)
 	if err != nil {
 		return nil, err
 	}
 	itemPaths := make([]string, 0)
-	for _, folder := range blogDir {
-		curFolderPath := filepath.Join(blogPath, folder.Name())
-		curFolderItemPath := filepath.Join(curFolderPath, itemFile)
-		if _, err := os.Stat(curFolderItemPath); err == nil {
-			itemPaths = append(itemPaths, curFolderPath)
+	err = filepath.Walk(blogPath, func(curPath string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
 		}
-	}
-	return itemPaths, nil
+		if !info.IsDir() || curPath == blogPath {
+			return nil
+		}
+		relPath, err := filepath.Rel(blogPath, curPath)
+		if err != nil {
+			return err
+		}
+		if matchesPattern(ignorePatterns, relPath, true) {
+			return filepath.SkipDir
+		}
+		if _, err := os.Stat(filepath.Join(curPath, itemFile)); err == nil {
+			itemPaths = append(itemPaths, curPath)
+		}
+		return nil
+	})
+	return itemPaths, err
 }
 
-func channeledProcessArticle(tmpl *template.Template, articlePath string, ch chan<- jsfItemErr) {
-	item, err := processArticle(tmpl, articlePath, "", "")
+func channeledProcessArticle(tmpl *template.Template, blogPath, articlePath string, plan articlePlan, ch chan<- jsfItemErr) {
+	item, err := processPlannedArticle(tmpl, blogPath, articlePath, plan)
 	ch <- jsfItemErr{item, err}
 }
 
-func buildItemList(tmpl *template.Template, blogPath string) ([]jsfItem, error) {
+func buildItemList(tmpl *template.Template, blogRelativePath string) ([]jsfItem, error) {
+	blogPath, err := filepath.Abs(blogRelativePath)
+	if err != nil {
+		return nil, err
+	}
 	articlePaths, err := findArticlePaths(blogPath)
 	if err != nil {
 		return nil, err
 	}
+	plans := make([]articlePlan, len(articlePaths))
+	claims := make([]urlClaim, len(articlePaths))
+	for i, articlePath := range articlePaths {
+		plans[i], err = planArticle(blogPath, articlePath, articleFlags{})
+		if err != nil {
+			return nil, err
+		}
+		claims[i], err = plans[i].claim()
+		if err != nil {
+			return nil, err
+		}
+	}
+	err = findURLCollisions(claims) //Before any article is written
+	if err != nil {
+		return nil, err
+	}
+	taken := make(map[string]bool)
+	for _, plan := range plans {
+		if len(plan.prevItem.ID) > 0 {
+			taken[plan.prevItem.ID] = true
+		}
+	}
+	for i := range plans {
+		err = plans[i].assignID(taken) //Not in the goroutines, or new articles could get the same ID
+		if err != nil {
+			return nil, err
+		}
+	}
+
 	itemList := make([]jsfItem, len(articlePaths))
 	ch := make(chan jsfItemErr)
-	for _, articlePath := range articlePaths {
-		go channeledProcessArticle(tmpl, articlePath, ch)
+	for i, articlePath := range articlePaths {
+		go channeledProcessArticle(tmpl, blogPath, articlePath, plans[i], ch)
 	}
 	for i := range itemList {
 		res := <-ch
@@ -92,43 +158,122 @@ func buildItemList(tmpl *template.Template, blogPath string) ([]jsfItem, error)
 		}
 		itemList[i] = res.item
 	}
-	return itemList, nil
+	err = writeStripReport(blogPath, itemList)
+	if err != nil {
+		return nil, err
+	}
+	return itemList, findIDCollisions(itemList)
 }
 
-func (jf *jsfMain) init() error {
-	jf.Version = jsfVersion
-	jf.Title = blogTitle
-	jf.HomePageURL = hostRawURL
+func (fs feedScope) homeURL() (string, error) {
+	if len(fs.Path) < 1 {
+		return hostRawURL, nil
+	}
+	return fs.resolve("")
+}
 
+func (fs feedScope) resolve(relPath string) (string, error) {
 	hostURL, err := url.Parse(hostRawURL)
 	if err != nil {
-		return err
+		return "", err
 	}
 
-	URLRelativeToHost, err := url.Parse(jsfPath)
+	URLRelativeToHost, err := url.Parse(path.Join(fs.Path, relPath))
+	if err != nil {
+		return "", err
+	}
+
+	return hostURL.ResolveReference(URLRelativeToHost).String(), nil
+}
+
+func (jf *jsfMain) init(scope feedScope) error {
+	var err error
+	jf.Version = jsfVersion
+	jf.Title = scope.Title
+	jf.Description = conf.Description
+	jf.UserComment = conf.UserComment
+	jf.Icon = conf.Icon
+	jf.Favicon = conf.Favicon
+	jf.Authors = conf.Authors
+	jf.Language = conf.Language
+	jf.Expired = conf.Expired
+	jf.HomePageURL, err = scope.homeURL()
 	if err != nil {
 		return err
 	}
+	jf.FeedURL, err = scope.resolve(jsfPath)
+	return err
+}
 
-	jf.FeedURL = hostURL.ResolveReference(URLRelativeToHost).String()
-	return nil
+func encodeJsf(w io.Writer, v interface{}, prefix string) error {
+	enc := json.NewEncoder(w)
+	enc.SetEscapeHTML(false)
+	enc.SetIndent(prefix, "\t")
+	return enc.Encode(v)
+}
+
+func itemSize(ji jsfItem) (int, error) {
+	var b bytes.Buffer
+	err := encodeJsf(&b, ji, "\t\t") //As nested in the page's items
+	return b.Len() + 3, err          //The newline and indent before it, and the comma after it
+}
+
+func envelopeSize(scope feedScope, pageCount int) (int, error) {
+	var page jsfMain
+	err := page.init(scope)
+	if err != nil {
+		return 0, err
+	}
+	page.NextURL = page.FeedURL + strconv.Itoa(pageCount) //No page number is longer
+	page.Items = []jsfItem{}
+	var b bytes.Buffer
+	err = encodeJsf(&b, page, "")
+	return b.Len() + 2, err //The items' closing bracket goes on its own line
 }
 
-func pageSplit(itemList []jsfItem, pageLen int) ([]jsfMain, error) {
-	itemCount := len(itemList)
-	feedCount := ((itemCount - 1) / pageLen) + 1
+func pageBounds(itemList []jsfItem, pageLen, pageBytes, envelope int) ([]int, error) {
+	bounds := []int{0}
+	curLen := 0
+	curBytes := envelope
+	for i, ji := range itemList {
+		size, err := itemSize(ji)
+		if err != nil {
+			return nil, err
+		}
+		full := (pageLen > 0 && curLen >= pageLen) || (pageBytes > 0 && curBytes+size > pageBytes)
+		if full && curLen > 0 { //Always at least one item per page, even if it is over budget
+			bounds = append(bounds, i)
+			curLen = 0
+			curBytes = envelope
+		}
+		curLen++
+		curBytes += size
+	}
+	return append(bounds, len(itemList)), nil
+}
+
+func pageSplit(itemList []jsfItem, pageLen, pageBytes int, scope feedScope) ([]jsfMain, error) {
+	envelope, err := envelopeSize(scope, len(itemList))
+	if err != nil {
+		return nil, err
+	}
+	bounds, err := pageBounds(itemList, pageLen, pageBytes, envelope)
+	if err != nil {
+		return nil, err
+	}
+	feedCount := len(bounds) - 1
+	if feedCount < 1 {
+		feedCount = 1
+	}
 	res := make([]jsfMain, feedCount)
 	for i := range res {
-		err := res[i].init()
+		err := res[i].init(scope)
 		if err != nil {
 			return res, err
 		}
-		pageStart := i * pageLen
-		pageEnd := (i + 1) * pageLen
-		if pageEnd > itemCount {
-			pageEnd = itemCount
+		if i+1 < len(bounds) {
+			res[i].Items = itemList[bounds[i]:bounds[i+1]]
 		}
-		res[i].Items = itemList[pageStart:pageEnd]
 		if i < (feedCount - 1) {
 			res[i].NextURL = res[i].FeedURL + strconv.Itoa(i+1)
 		}
@@ -146,10 +291,7 @@ func writeJsf(feedList []jsfMain, blogPath string) error {
 		if err != nil {
 			return err
 		}
-		enc := json.NewEncoder(f)
-		enc.SetEscapeHTML(false)
-		enc.SetIndent("", "\t")
-		err = enc.Encode(feed)
+		err = encodeJsf(f, feed, "")
 		if err != nil {
 			return err
 		}
@@ -163,6 +305,7 @@ func processHomepage(tmpl *template.Template, wg *sync.WaitGroup, latest jsfItem
 	published, _ := time.Parse(time.RFC3339, latest.DatePublished)
 	permalink := fmt.Sprintf("<br /><a href=\"%s\">[Permalink]</a>", latest.URL)
 	exportArgs.init(published, latest.Title, []byte(latest.ContentHTML+permalink))
+	exportArgs.Summary = latest.Summary
 	err := exportArgs.writeFinalWebpage(tmpl, blogPath)
 	if err != nil {
 		ch <- err
@@ -170,7 +313,12 @@ func processHomepage(tmpl *template.Template, wg *sync.WaitGroup, latest jsfItem
 	wg.Done()
 }
 
-func archiveSeperator(gt1 time.Time, gt2 time.Time) (bool, string) {
+type archiveGroup struct {
+	heading string
+	items   []jsfItem
+}
+
+func archiveHeading(gt1 time.Time, gt2 time.Time) (bool, string) {
 	g1Year := gt1.Year()
 	g1YearDay := gt1.YearDay()
 	tq1Year := tqtime.Year(g1Year, g1YearDay)
@@ -185,40 +333,48 @@ func archiveSeperator(gt1 time.Time, gt2 time.Time) (bool, string) {
 
 	isSpecialDay := (tq2Mon == tqtime.SpecialDay)
 
-	var seperatorText string
+	var heading string
 	if tq2Day == tqtime.AldrinDay || tq2Mon == tqtime.Hippocrates { //A feature of this calendar which is annoying for archives
-		seperatorText = fmt.Sprintf("<h3>Hippocrates & Aldrin Day, %d AT</h3>", tq2Year)
+		heading = fmt.Sprintf("Hippocrates & Aldrin Day, %d AT", tq2Year)
 		if (tq1Mon == tqtime.Hippocrates || tq1Day == tqtime.AldrinDay) && tq1Year == tq2Year {
-			return false, seperatorText
+			return false, heading
 		}
 	} else if isSpecialDay {
-		seperatorText = fmt.Sprintf("<h3>%s, %d AT</h3>", tqtime.DayName(tq2Day), tq2Year)
+		heading = fmt.Sprintf("%s, %d AT", tqtime.DayName(tq2Day), tq2Year)
 	} else {
-		seperatorText = fmt.Sprintf("<h3>%s, %d AT</h3>", tq2Mon.String(), tq2Year)
+		heading = fmt.Sprintf("%s, %d AT", tq2Mon.String(), tq2Year)
 	}
 	needSeperation := (tq1Year != tq2Year) || (tq1Mon != tq2Mon) || (isSpecialDay && (tq1Day != tq2Day))
-	return needSeperation, seperatorText
+	return needSeperation, heading
+}
+
+func archiveGroups(itemList []jsfItem) []archiveGroup {
+	var t1 time.Time //intentionally starting at zero value, always a different year than first article.
+	res := make([]archiveGroup, 0)
+	for _, ji := range itemList {
+		t2, _ := time.Parse(time.RFC3339, ji.DatePublished)
+		if sep, heading := archiveHeading(t1, t2); sep {
+			res = append(res, archiveGroup{heading: heading})
+		}
+		res[len(res)-1].items = append(res[len(res)-1].items, ji)
+		t1 = t2
+	}
+	return res
 }
 
 func archiveLines(itemList []jsfItem) []string {
 	if len(itemList) < 1 {
 		return nil
 	}
-	var t1 time.Time //intentionally starting at zero value, always a different year than first article.
 	outputLines := make([]string, 0)
-	for i, ji := range itemList {
-		t2, _ := time.Parse(time.RFC3339, ji.DatePublished)
-		if sep, sepText := archiveSeperator(t1, t2); sep {
-			if i > 0 { //The start of a section is the end of the previous section, unless *no* previous section.
-				outputLines = append(outputLines, "</ul>")
-			}
-			outputLines = append(outputLines, sepText)
-			outputLines = append(outputLines, "<ul>")
+	for _, group := range archiveGroups(itemList) {
+		outputLines = append(outputLines, fmt.Sprintf("<h3>%s</h3>", group.heading))
+		outputLines = append(outputLines, "<ul>")
+		for _, ji := range group.items {
+			outputLines = append(outputLines, fmt.Sprintf("<li><a href=\"%v\">%v</a></li>", ji.URL, ji.Title))
 		}
-		outputLines = append(outputLines, fmt.Sprintf("<li><a href=\"%v\">%v</a></li>", ji.URL, ji.Title))
-		t1 = t2
+		outputLines = append(outputLines, "</ul>")
 	}
-	outputLines = append(outputLines, "</ul>")
 	return outputLines
 }
 
@@ -283,18 +439,44 @@ func processTags(tmpl *template.Template, wg *sync.WaitGroup, itemList []jsfItem
 
 func fromJsfItem(gi *feeds.Item, ji jsfItem) {
 	gi.Title = ji.Title
-	gi.Link = &feeds.Link{Href: ji.URL}
+	gi.Link = &feeds.Link{Href: ji.URL, Type: "text/html"}
 	gi.Created, _ = time.Parse(time.RFC3339, ji.DatePublished)
 	gi.Updated, _ = time.Parse(time.RFC3339, ji.DateModified)
-	gi.Id = ji.URL
+	gi.Id = ji.ID
+	if ji.ID != ji.URL {
+		gi.IsPermaLink = "false"
+	}
 	gi.Description = ji.ContentHTML
+	if len(ji.Summary) > 0 {
+		gi.Description = html.EscapeString(ji.Summary)
+	}
+	if conf.FeedContent != feedContentSummary {
+		gi.Content = ji.ContentHTML
+	}
+	if len(ji.Authors) > 0 {
+		gi.Author = &feeds.Author{Name: ji.Authors[0].Name}
+	}
+	if len(ji.Attachments) > 0 {
+		//RSS allows one enclosure per item, so the other attachments are only in Atom
+		ja := ji.Attachments[0]
+		gi.Enclosure = &feeds.Enclosure{Url: ja.URL, Length: strconv.FormatInt(ja.SizeInBytes, 10), Type: ja.MIMEType}
+	}
 }
 
-func makeLegacyFeed(itemList []jsfItem) feeds.Feed {
+func makeLegacyFeed(itemList []jsfItem, scope feedScope) (feeds.Feed, error) {
 	var gf feeds.Feed
-	gf.Title = blogTitle
-	gf.Link = &feeds.Link{Href: hostRawURL}
-	gf.Created = time.Now()
+	homeURL, err := scope.homeURL()
+	if err != nil {
+		return gf, err
+	}
+	gf.Title = scope.Title
+	gf.Link = &feeds.Link{Href: homeURL}
+	gf.Description = conf.Description
+	if len(gf.Description) < 1 {
+		gf.Description = scope.Title //RSS needs a description
+	}
+	gf.Author = &feeds.Author{Name: feedAuthors(scope)[0].Name}
+	gf.Updated = newestModified(itemList)
 
 	gfItemList := make([]*feeds.Item, len(itemList))
 	for i, ji := range itemList {
@@ -302,18 +484,17 @@ func makeLegacyFeed(itemList []jsfItem) feeds.Feed {
 		fromJsfItem(gfItemList[i], ji)
 	}
 	gf.Items = gfItemList
-	return gf
+	return gf, nil
 }
 
-func processLegacyFeeds(wg *sync.WaitGroup, itemList []jsfItem, blogPath string, ch chan<- error) {
+func processLegacyFeeds(wg *sync.WaitGroup, itemList []jsfItem, blogPath string, scope feedScope, ch chan<- error) {
 	defer wg.Done()
-	gf := makeLegacyFeed(itemList)
-	atom, err := gf.ToAtom()
+	atom, err := makeAtomFeed(itemList, scope)
 	if err != nil {
 		ch <- err
 		return
 	}
-	rss, err := gf.ToRss()
+	rss, err := makeRssFeed(itemList, scope)
 	if err != nil {
 		ch <- err
 		return
@@ -322,20 +503,24 @@ func processLegacyFeeds(wg *sync.WaitGroup, itemList []jsfItem, blogPath string,
 	fullAtomPath := filepath.Join(blogPath, atomPath)
 	fullRssPath := filepath.Join(blogPath, rssPath)
 
-	err = ioutil.WriteFile(fullAtomPath, []byte(atom), 0664)
+	err = writeXML(atom, fullAtomPath)
 	if err != nil {
 		ch <- err
 		return
 	}
-	err = ioutil.WriteFile(fullRssPath, []byte(rss), 0664)
+	err = writeXML(rss, fullRssPath)
 	if err != nil {
 		ch <- err
 	}
 }
 
-func processJsf(wg *sync.WaitGroup, itemList []jsfItem, blogPath string, pageLen int, ch chan<- error) {
+func processJsf(wg *sync.WaitGroup, itemList []jsfItem, blogPath string, scope feedScope, ch chan<- error) {
 	defer wg.Done()
-	feedList, err := pageSplit(itemList, pageLen)
+	pageLen := conf.FeedPageItems
+	if pageLen == 0 {
+		pageLen = defaultFeedPageItems
+	}
+	feedList, err := pageSplit(feedItemList(itemList), pageLen, conf.FeedPageBytes, scope)
 	if err != nil {
 		ch <- err
 		return
@@ -347,6 +532,46 @@ func processJsf(wg *sync.WaitGroup, itemList []jsfItem, blogPath string, pageLen
 	}
 }
 
+func sectionItems(itemList []jsfItem, sectionPath string) []jsfItem {
+	res := make([]jsfItem, 0)
+	for _, ji := range itemList {
+		if inSection(sectionPath, ji.dir) {
+			res = append(res, ji)
+		}
+	}
+	return res
+}
+
+func processSection(tmpl *template.Template, wg *sync.WaitGroup, itemList []jsfItem, blogPath string, sc sectionConfig, ch chan<- error) {
+	defer wg.Done()
+	var exportArgs articleExport
+	var published time.Time
+
+	sectionPath := filepath.Join(blogPath, filepath.FromSlash(sc.Path))
+	err := os.MkdirAll(filepath.Join(sectionPath, filepath.Dir(jsfPath)), 0775)
+	if err != nil {
+		ch <- err
+		return
+	}
+
+	contentLines := archiveLines(itemList)
+	exportArgs.init(published, sc.Title, []byte(strings.Join(contentLines, "\n")))
+	exportArgs.Date = template.HTML("")
+	if sc.tmpl != nil {
+		tmpl = sc.tmpl
+	}
+	err = exportArgs.writeFinalWebpage(tmpl, sectionPath)
+	if err != nil {
+		ch <- err
+		return
+	}
+
+	scope := feedScope{Title: sc.Title, Path: sc.Path}
+	wg.Add(2)
+	go processLegacyFeeds(wg, itemList, sectionPath, scope, ch)
+	go processJsf(wg, itemList, sectionPath, scope, ch)
+}
+
 func processBlog(mainTmpl *template.Template, homeTmpl *template.Template, blogRelativePath string) error {
 	blogPath, err := filepath.Abs(blogRelativePath)
 	if err != nil {
@@ -354,24 +579,60 @@ func processBlog(mainTmpl *template.Template, homeTmpl *template.Template, blogR
 	}
 
 	itemList, err := buildItemList(mainTmpl, blogPath)
+	if err != nil {
+		return err
+	}
 	sort.Sort(byPublishedDescend(itemList))
+	absList, err := absoluteItemList(itemList) //Relative links only work on the article page
+	if err != nil {
+		return err
+	}
+	feedList := sanitizeFeedItems(absList)
 
 	ch := make(chan error)
+	firstErr := make(chan error, 1)
+	go func() {
+		var res error
+		for err := range ch { //Drained as they come, so no goroutine blocks however many send
+			if res == nil {
+				res = err
+			}
+		}
+		firstErr <- res
+	}()
 	var wg sync.WaitGroup
 	if len(itemList) > 0 {
 		wg.Add(1)
-		go processHomepage(homeTmpl, &wg, itemList[0], blogPath, ch)
+		go processHomepage(homeTmpl, &wg, absList[0], blogPath, ch)
 	}
-	wg.Add(4)
-	go processLegacyFeeds(&wg, itemList, blogPath, ch)
+	wg.Add(5)
+	go processLegacyFeeds(&wg, feedList, blogPath, rootScope, ch)
+	go processRedirects(&wg, itemList, blogPath, ch)
 	go processTags(mainTmpl, &wg, itemList, blogPath, ch)
 	go processArchive(mainTmpl, &wg, itemList, blogPath, ch)
-	go processJsf(&wg, itemList, blogPath, 15, ch)
-	wg.Wait()
-	select {
-	case err = <-ch:
-		return err
-	default:
-		return nil
+	go processJsf(&wg, feedList, blogPath, rootScope, ch)
+	if conf.Podcast != nil {
+		wg.Add(1)
+		go processPodcast(&wg, feedList, blogPath, ch)
 	}
+	if conf.TodayMode == todayModeScript || conf.TodayMode == todayModeSSI {
+		wg.Add(1)
+		go processToday(&wg, blogPath, ch)
+	}
+	if conf.Highlight != nil && len(conf.Highlight.CSSFile) > 0 {
+		wg.Add(1)
+		go processHighlightCSS(&wg, blogPath, ch)
+	}
+	if conf.Gemini != nil {
+		wg.Add(1)
+		go processGemini(&wg, itemList, blogPath, ch)
+	}
+	for _, sc := range conf.Sections {
+		scItemList := sectionItems(feedList, sc.Path) //Only the feeds use content
+		wg.Add(1)
+		go processSection(mainTmpl, &wg, scItemList, blogPath, sc, ch)
+	}
+	wg.Wait()
+	close(ch)
+	return <-firstErr
 }
@@ -87,7 +87,10 @@ func TestFindArticlePaths(t *testing.T) {
 	numDirs := 3
 	numItems := 2
 	blogPath, subdirPaths := setupBlog(t, []byte("Fake!"), []byte("Fake!"), numDirs, numItems)
-	expectedArticlePaths := subdirPaths[:numItems]
+	expectedArticlePaths := make([]string, numItems)
+	for i := range expectedArticlePaths {
+		expectedArticlePaths[i] = filepath.Clean(subdirPaths[i])
+	}
 	articlePaths, err := findArticlePaths(blogPath)
 	if err != nil {
 		t.Errorf("Error (%s) when all inputs valid.", err.Error())
@@ -109,6 +112,61 @@ func TestFindArticlePaths(t *testing.T) {
 	teardownArticlePath(t, blogPath)
 }
 
+func TestFindArticlePathsNested(t *testing.T) {
+	blogPath := setupArticlePath(t)
+	nestedPaths := []string{
+		filepath.Join(blogPath, "hello"),
+		filepath.Join(blogPath, "2020", "trip"),
+		filepath.Join(blogPath, "notes", "foo"),
+		filepath.Join(blogPath, "drafts", "wip"),
+		filepath.Join(blogPath, "notes", "assets", "fake"),
+	}
+	for _, p := range nestedPaths {
+		err := os.MkdirAll(p, 0777)
+		if err != nil {
+			t.Errorf("Error (%s) PRIOR TO RUNNING TEST.", err.Error())
+		}
+		err = ioutil.WriteFile(filepath.Join(p, itemFile), []byte("Fake!"), 0664)
+		if err != nil {
+			t.Errorf("Error (%s) PRIOR TO RUNNING TEST.", err.Error())
+		}
+	}
+	err := ioutil.WriteFile(filepath.Join(blogPath, ignoreFile), []byte("# comment\ndrafts/\nassets/\n"), 0664)
+	if err != nil {
+		t.Errorf("Error (%s) PRIOR TO RUNNING TEST.", err.Error())
+	}
+
+	articlePaths, err := findArticlePaths(blogPath)
+	if err != nil {
+		t.Errorf("Error (%s) when all inputs valid.", err.Error())
+	}
+	expectedArticlePaths := []string{nestedPaths[0], nestedPaths[1], nestedPaths[2]}
+	sort.Strings(expectedArticlePaths)
+	sort.Strings(articlePaths)
+	if len(articlePaths) != len(expectedArticlePaths) {
+		t.Errorf("Wrong number of article paths, expected %v, actual %v", expectedArticlePaths, articlePaths)
+	}
+	for i, actualPath := range articlePaths {
+		if i < len(expectedArticlePaths) && actualPath != expectedArticlePaths[i] {
+			t.Errorf("Unexpected path at index %v, expected '%s', actual '%s'", i, expectedArticlePaths[i], actualPath)
+		}
+	}
+	teardownArticlePath(t, blogPath)
+}
+
+func TestSectionItems(t *testing.T) {
+	itemList := make([]jsfItem, 4)
+	itemList[0].dir = "notes/foo"
+	itemList[1].dir = "notes/2020/bar"
+	itemList[2].dir = "notesy"
+	itemList[3].dir = "hello"
+
+	res := sectionItems(itemList, "notes")
+	if len(res) != 2 || res[0].dir != itemList[0].dir || res[1].dir != itemList[1].dir {
+		t.Errorf("Wrong section items, expected first two of %v, actual %v", itemList, res)
+	}
+}
+
 func TestBuildItemList(t *testing.T) {
 	numDirs := 3
 	numItems := 2
@@ -138,7 +196,7 @@ func TestBuildItemList(t *testing.T) {
 		t.Errorf("Error (%s) when all parameters valid.", err.Error())
 	}
 	sort.Sort(byPublishedDescend(itemList))
-	expectedContentList := []string{"<h2>This is the content 0</h2>\n", "<h2>This is the content 1</h2>\n"}
+	expectedContentList := []string{"<h2 id=\"this-is-the-content-0\">This is the content 0</h2>\n", "<h2 id=\"this-is-the-content-1\">This is the content 1</h2>\n"}
 	expectedItemList := []jsfItem{item0, item1}
 
 	if len(itemList) != numItems {
@@ -154,7 +212,7 @@ func TestBuildItemList(t *testing.T) {
 		actualPub := actualItem.DatePublished
 		expectedPub := expectedItemList[i].DatePublished
 		if actualPub != expectedPub {
-			t.Errorf("Wrong published date at index $v, expected '%s', actual '%s'", i, expectedPub, actualPub)
+			t.Errorf("Wrong published date at index %v, expected '%s', actual '%s'", i, expectedPub, actualPub)
 		}
 		finalPagePath := filepath.Join(articlePaths[i], finalWebpageFile)
 		finalPageContent, err := ioutil.ReadFile(finalPagePath)
@@ -172,7 +230,7 @@ func TestBuildItemList(t *testing.T) {
 
 func TestJsfMainInit(t *testing.T) {
 	var jf jsfMain
-	err := jf.init()
+	err := jf.init(rootScope)
 	if err != nil {
 		t.Errorf("Error (%s) with default settings.", err.Error())
 	}
@@ -187,6 +245,40 @@ func TestJsfMainInit(t *testing.T) {
 	}
 }
 
+func TestJsfMainInitMetadata(t *testing.T) {
+	conf.Description = "A blog"
+	conf.Authors = []jsfAuthor{{Name: "Ratan", URL: hostRawURL}}
+	conf.Language = "en-CA"
+	var jf jsfMain
+	err := jf.init(rootScope)
+	if err != nil {
+		t.Errorf("Error (%s) with valid config.", err.Error())
+	}
+	if jf.Description != conf.Description || jf.Language != conf.Language {
+		t.Errorf("Wrong metadata, description '%s', language '%s'", jf.Description, jf.Language)
+	}
+	if len(jf.Authors) != 1 || jf.Authors[0] != conf.Authors[0] {
+		t.Errorf("Wrong authors, expected %v, actual %v", conf.Authors, jf.Authors)
+	}
+	conf = blogConfig{}
+}
+
+func TestJsfMainInitSection(t *testing.T) {
+	var jf jsfMain
+	err := jf.init(feedScope{Title: "Notes", Path: "notes"})
+	if err != nil {
+		t.Errorf("Error (%s) with valid section.", err.Error())
+	}
+	expectedHome := hostRawURL + "/notes"
+	if jf.HomePageURL != expectedHome {
+		t.Errorf("Wrong home URL, expected '%s', actual '%s'", expectedHome, jf.HomePageURL)
+	}
+	expectedFeed := hostRawURL + "/notes/" + jsfPath
+	if jf.FeedURL != expectedFeed {
+		t.Errorf("Wrong feed URL, expected '%s', actual '%s'", expectedFeed, jf.FeedURL)
+	}
+}
+
 var pageSplitTestParams = []struct {
 	itemCount int
 	pageLen   int
@@ -210,7 +302,7 @@ func pageSplitTest(t *testing.T, itemCount int, pageLen int) {
 	for i := range itemList {
 		itemList[i].ID = strconv.Itoa(i)
 	}
-	feedList, err := pageSplit(itemList, pageLen)
+	feedList, err := pageSplit(itemList, pageLen, 0, rootScope)
 
 	if err != nil {
 		t.Errorf("Error (%s) when all parameters valid.", err.Error())
@@ -250,6 +342,78 @@ func TestPageSplitAll(t *testing.T) {
 	}
 }
 
+func TestPageSplitBytes(t *testing.T) {
+	itemList := make([]jsfItem, 6)
+	for i := range itemList {
+		itemList[i].ID = strconv.Itoa(i)
+	}
+	smallSize, _ := itemSize(itemList[0])
+	itemList[1].ContentHTML = strings.Repeat("x", 1000)
+	itemList[4].ContentHTML = strings.Repeat("x", 5000) //Over budget on its own
+	envelope, _ := envelopeSize(rootScope, len(itemList))
+	pageBytes := envelope + 1000 + 2*smallSize
+
+	feedList, err := pageSplit(itemList, 15, pageBytes, rootScope)
+	if err != nil {
+		t.Errorf("Error (%s) when all parameters valid.", err.Error())
+	}
+	expectedIDs := [][]string{{"0", "1"}, {"2", "3"}, {"4"}, {"5"}}
+	if len(feedList) != len(expectedIDs) {
+		t.Fatalf("Wrong feed count, expected %v, actual %v", len(expectedIDs), len(feedList))
+	}
+	for fi, feed := range feedList {
+		if len(feed.Items) != len(expectedIDs[fi]) {
+			t.Errorf("Wrong item count in feed %v, expected %v, actual %v", fi, len(expectedIDs[fi]), len(feed.Items))
+			continue
+		}
+		for i, item := range feed.Items {
+			if item.ID != expectedIDs[fi][i] {
+				t.Errorf("Wrong ID in feed %v, index %v, expected '%s', actual '%s'", fi, i, expectedIDs[fi][i], item.ID)
+			}
+		}
+		if fi < len(feedList)-1 && feed.NextURL != feed.FeedURL+strconv.Itoa(fi+1) {
+			t.Errorf("Wrong NextURL in feed %v: '%s'", fi, feed.NextURL)
+		}
+	}
+
+	blogPath := setupArticlePath(t)
+	defer teardownArticlePath(t, blogPath)
+	err = os.MkdirAll(filepath.Join(blogPath, filepath.Dir(jsfPath)), 0775)
+	if err != nil {
+		t.Fatalf("Error (%s) PRIOR TO RUNNING TEST.", err.Error())
+	}
+	err = writeJsf(feedList, blogPath)
+	if err != nil {
+		t.Fatalf("Error (%s) when all parameters valid.", err.Error())
+	}
+	for fi, feed := range feedList {
+		curPath := filepath.Join(blogPath, jsfPath)
+		if fi > 0 {
+			curPath += strconv.Itoa(fi)
+		}
+		info, err := os.Stat(curPath)
+		if err != nil {
+			t.Fatalf("Error (%s) reading written page", err.Error())
+		}
+		if len(feed.Items) > 1 && info.Size() > int64(pageBytes) {
+			t.Errorf("Page %v over budget, %v bytes written, budget %v", fi, info.Size(), pageBytes)
+		}
+	}
+
+	feedList, err = pageSplit(itemList, 2, pageBytes*10, rootScope)
+	if err != nil {
+		t.Errorf("Error (%s) when all parameters valid.", err.Error())
+	}
+	if len(feedList) != 3 {
+		t.Errorf("Item limit ignored with byte budget, expected 3 feeds, actual %v", len(feedList))
+	}
+
+	feedList, err = pageSplit(nil, 15, pageBytes, rootScope)
+	if err != nil || len(feedList) != 1 || len(feedList[0].Items) != 0 {
+		t.Errorf("Wrong feed list for no items: %v", feedList)
+	}
+}
+
 func TestWriteJsf(t *testing.T) {
 	feedCount := 3
 	feedList := make([]jsfMain, feedCount)
@@ -297,35 +461,35 @@ func TestWriteJsf(t *testing.T) {
 	teardownArticlePath(t, blogPath)
 }
 
-var archiveSeperatorTests = []struct {
+var archiveHeadingTests = []struct {
 	gt1     string
 	gt2     string
 	sep     bool
-	sepText string
+	heading string
 }{
-	{"0001-01-01", "1972-07-20", true, "<h3>Armstrong Day, 3 AT</h3>"},
-	{"1972-07-19", "1972-07-20", true, "<h3>Armstrong Day, 3 AT</h3>"},
-	{"0001-01-01", "1972-06-22", true, "<h3>Mendel, 3 AT</h3>"},
-	{"1972-06-21", "1972-06-22", true, "<h3>Mendel, 3 AT</h3>"},
-	{"1972-06-22", "1972-06-23", false, "<h3>Mendel, 3 AT</h3>"},
-	{"1972-01-04", "1972-01-05", true, "<h3>Galileo, 3 AT</h3>"},
-	{"1972-02-01", "1972-02-02", true, "<h3>Hippocrates & Aldrin Day, 3 AT</h3>"},
-	{"1972-02-28", "1972-02-29", false, "<h3>Hippocrates & Aldrin Day, 3 AT</h3>"},
-	{"1971-02-28", "1972-02-29", true, "<h3>Hippocrates & Aldrin Day, 3 AT</h3>"},
-	{"1972-02-29", "1972-03-01", false, "<h3>Hippocrates & Aldrin Day, 3 AT</h3>"},
-	{"1972-03-01", "1972-03-02", true, "<h3>Imhotep, 3 AT</h3>"},
+	{"0001-01-01", "1972-07-20", true, "Armstrong Day, 3 AT"},
+	{"1972-07-19", "1972-07-20", true, "Armstrong Day, 3 AT"},
+	{"0001-01-01", "1972-06-22", true, "Mendel, 3 AT"},
+	{"1972-06-21", "1972-06-22", true, "Mendel, 3 AT"},
+	{"1972-06-22", "1972-06-23", false, "Mendel, 3 AT"},
+	{"1972-01-04", "1972-01-05", true, "Galileo, 3 AT"},
+	{"1972-02-01", "1972-02-02", true, "Hippocrates & Aldrin Day, 3 AT"},
+	{"1972-02-28", "1972-02-29", false, "Hippocrates & Aldrin Day, 3 AT"},
+	{"1971-02-28", "1972-02-29", true, "Hippocrates & Aldrin Day, 3 AT"},
+	{"1972-02-29", "1972-03-01", false, "Hippocrates & Aldrin Day, 3 AT"},
+	{"1972-03-01", "1972-03-02", true, "Imhotep, 3 AT"},
 }
 
-func TestArchiveSeperator(t *testing.T) {
-	for _, s := range archiveSeperatorTests {
+func TestArchiveHeading(t *testing.T) {
+	for _, s := range archiveHeadingTests {
 		gt1, _ := time.Parse("2006-01-02", s.gt1)
 		gt2, _ := time.Parse("2006-01-02", s.gt2)
-		sep, sepText := archiveSeperator(gt1, gt2)
+		sep, heading := archiveHeading(gt1, gt2)
 		if sep != s.sep {
 			t.Errorf("Wrong seperation status on (%v,%v), expected %v, actual %v", s.gt1, s.gt2, s.sep, sep)
 		}
-		if sepText != s.sepText {
-			t.Errorf("Wrong seperation text on (%v,%v), expected %v, actual %v", s.gt1, s.gt2, s.sepText, sepText)
+		if heading != s.heading {
+			t.Errorf("Wrong heading on (%v,%v), expected %v, actual %v", s.gt1, s.gt2, s.heading, heading)
 		}
 	}
 }
@@ -475,18 +639,25 @@ func TestMakeLegacyFeed(t *testing.T) {
 	itemList := make([]jsfItem, itemCount)
 	for i := range itemList {
 		itemList[i].URL = strconv.Itoa(i)
+		itemList[i].ID = "tag:ratan.blog,2017-06-10:" + strconv.Itoa(i)
 		itemList[i].ContentHTML = strconv.Itoa(i * 10)
 	}
 
-	res := makeLegacyFeed(itemList)
+	res, err := makeLegacyFeed(itemList, rootScope)
+	if err != nil {
+		t.Errorf("Error (%s) when all parameters valid.", err.Error())
+	}
 	resLen := len(res.Items)
 	if resLen != itemCount {
 		t.Errorf("Unexpected item count, expected %v, actual %v", itemCount, resLen)
 	}
 
 	for i, resItem := range res.Items {
-		if resItem.Id != itemList[i].URL {
-			t.Errorf("Unexpected ID at index %v, expected '%s', actual '%s'", i, itemList[i].URL, resItem.Id)
+		if resItem.Id != itemList[i].ID {
+			t.Errorf("Unexpected ID at index %v, expected '%s', actual '%s'", i, itemList[i].ID, resItem.Id)
+		}
+		if resItem.IsPermaLink != "false" {
+			t.Errorf("Unexpected isPermaLink at index %v, expected 'false', actual '%s'", i, resItem.IsPermaLink)
 		}
 		if resItem.Description != itemList[i].ContentHTML {
 			t.Errorf("Unexpected content at index %v, expected '%s', actual '%s", i, itemList[i].ContentHTML, resItem.Description)
@@ -528,3 +699,23 @@ func TestProcessHomepage(t *testing.T) {
 	}
 	teardownArticlePath(t, blogPath)
 }
+
+func TestProcessBlogManyErrors(t *testing.T) {
+	itemContent := []byte(`{"title": "Trip", "date_published": "2017-06-10T10:00:00Z"}`)
+	blogPath, _ := setupBlog(t, itemContent, []byte("Hi"), 1, 1)
+	defer teardownArticlePath(t, blogPath)
+	defer func() { conf = blogConfig{} }()
+	for i := 0; i < 5; i++ {
+		sectionPath := "section" + strconv.Itoa(i)
+		err := ioutil.WriteFile(filepath.Join(blogPath, sectionPath), []byte("Not a directory"), 0664)
+		if err != nil {
+			t.Fatalf("Error (%s) PRIOR TO RUNNING TEST.", err.Error())
+		}
+		conf.Sections = append(conf.Sections, sectionConfig{Path: sectionPath})
+	}
+	tmpl := template.Must(template.New("Whatever").Parse("{{.ContentHTML}}"))
+	err := processBlog(tmpl, tmpl, blogPath) //No feeds, tags or archive directories either
+	if err == nil {
+		t.Errorf("No error when every output fails")
+	}
+}